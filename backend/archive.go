@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"link-analytics-service/archive"
+	"link-analytics-service/config"
+	"link-analytics-service/db"
+	"os"
+	"time"
+)
+
+const archiveUsage = `usage: main archive [FROM TO]
+       main restore ID [TABLE]
+
+  archive          export every detached clicks partition, then drop it
+  archive FROM TO  export clicks from FROM up to (not including) TO, dates as YYYY-MM-DD;
+                   the rows stay in the database
+  restore ID       load export ID into TABLE (default restored_ID)`
+
+// newArchiver connects the archive storage from ARCHIVE_URL to database.
+// Archiving needs the Postgres backend; SQLite installs keep their clicks.
+func newArchiver(cfg *config.Config, database db.Database) (*archive.Archiver, error) {
+	source, ok := database.(db.ClickArchiver)
+	if !ok {
+		return nil, fmt.Errorf("click archiving needs the %s backend", config.DatabasePostgres)
+	}
+	if cfg.ArchiveURL == "" {
+		return nil, fmt.Errorf("ARCHIVE_URL is not set")
+	}
+	storage, err := archive.OpenStorage(cfg.ArchiveURL, archive.S3Config{
+		Endpoint:  cfg.ArchiveS3Endpoint,
+		Region:    cfg.ArchiveS3Region,
+		AccessKey: cfg.ArchiveAccessKey,
+		SecretKey: cfg.ArchiveSecretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archive.New(source, storage), nil
+}
+
+// runArchive implements the archive subcommand
+func runArchive(cfg *config.Config, args []string) error {
+	var from, to time.Time
+	switch len(args) {
+	case 0:
+	case 2:
+		var err error
+		if from, err = time.Parse(time.DateOnly, args[0]); err != nil {
+			return fmt.Errorf("invalid FROM date %q\n%s", args[0], archiveUsage)
+		}
+		if to, err = time.Parse(time.DateOnly, args[1]); err != nil {
+			return fmt.Errorf("invalid TO date %q\n%s", args[1], archiveUsage)
+		}
+	default:
+		return fmt.Errorf("unexpected arguments\n%s", archiveUsage)
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	archiver, err := newArchiver(cfg, database)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if len(args) == 0 {
+		manifests, err := archiver.ArchivePartitions(ctx)
+		for _, m := range manifests {
+			fmt.Fprintf(os.Stdout, "archived %s: %d click(s) in %d file(s)\n", m.ID, m.Rows, len(m.Files))
+		}
+		if err != nil {
+			return err
+		}
+		if len(manifests) == 0 {
+			fmt.Fprintln(os.Stdout, "no detached clicks partitions to archive")
+		}
+		return nil
+	}
+
+	m, err := archiver.ArchiveRange(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "archived %s: %d click(s) in %d file(s)\n", m.ID, m.Rows, len(m.Files))
+	return nil
+}
+
+// runRestore implements the restore subcommand
+func runRestore(cfg *config.Config, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("restore needs an archive ID\n%s", archiveUsage)
+	}
+	table := ""
+	if len(args) == 2 {
+		table = args[1]
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	archiver, err := newArchiver(cfg, database)
+	if err != nil {
+		return err
+	}
+
+	n, err := archiver.Restore(context.Background(), args[0], table)
+	if err != nil {
+		return err
+	}
+	if table == "" {
+		table = archive.RestoreTable(args[0])
+	}
+	fmt.Fprintf(os.Stdout, "restored %d click(s) into %s\n", n, table)
+	return nil
+}
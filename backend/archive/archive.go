@@ -0,0 +1,414 @@
+// Package archive moves raw clicks that have aged out of Postgres into cold storage.
+// An export is a set of gzip-compressed NDJSON files (one click per line) with a
+// SHA256SUMS file and a manifest.json, stored under clicks/{id}/ in a local directory
+// or an S3-compatible bucket. The manifest is written last, so an export without
+// one is incomplete. Restore loads an export into its own table for re-analysis.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// Format is the only export format: newline-delimited JSON, gzip-compressed
+	Format = "ndjson+gzip"
+	// RowsPerFile caps the clicks in one export file
+	RowsPerFile = 500_000
+
+	manifestVersion = 1
+)
+
+// Manifest describes one export
+type Manifest struct {
+	Version   int       `json:"version"`
+	ID        string    `json:"id"`
+	Source    string    `json:"source"` // partition name, or "clicks" for a date range
+	From      time.Time `json:"from"`   // inclusive
+	To        time.Time `json:"to"`     // exclusive
+	Format    string    `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+	Rows      int64     `json:"rows"`
+	Files     []File    `json:"files"`
+}
+
+// File is one compressed NDJSON file of an export
+type File struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Archiver exports clicks from source to storage and restores them
+type Archiver struct {
+	source  db.ClickArchiver
+	storage Storage
+}
+
+func New(source db.ClickArchiver, storage Storage) *Archiver {
+	return &Archiver{source: source, storage: storage}
+}
+
+func exportKey(id, name string) string {
+	return path.Join("clicks", id, name)
+}
+
+// ErrPartitionsLocked is returned by ArchivePartitions when another instance is
+// maintaining or archiving partitions
+var ErrPartitionsLocked = errors.New("another instance is maintaining clicks partitions")
+
+// ArchivePartitions exports every detached clicks partition, verifies the upload
+// against its checksums and then drops the partition. A partition whose export
+// already has a manifest (an earlier run stopped before dropping it) is verified
+// and dropped without exporting again. The run holds the partition maintenance
+// lock, so replicas never export or drop the same partition concurrently.
+func (a *Archiver) ArchivePartitions(ctx context.Context) ([]*Manifest, error) {
+	var archived []*Manifest
+	locked, err := a.source.WithPartitionLock(ctx, func() error {
+		var err error
+		archived, err = a.archivePartitions(ctx)
+		return err
+	})
+	if err == nil && !locked {
+		err = ErrPartitionsLocked
+	}
+	return archived, err
+}
+
+func (a *Archiver) archivePartitions(ctx context.Context) ([]*Manifest, error) {
+	partitions, err := a.source.DetachedClickPartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []*Manifest
+	for _, partition := range partitions {
+		manifest, err := a.readManifest(ctx, partition.Name)
+		if errors.Is(err, ErrNotExist) {
+			manifest, err = a.export(ctx, partition.Name, partition.Name, partition.From, partition.To,
+				func(fn func(*models.ClickRecord) error) error {
+					return a.source.ScanClickPartition(ctx, partition.Name, fn)
+				})
+		}
+		if err != nil {
+			return archived, fmt.Errorf("failed to archive %s: %w", partition.Name, err)
+		}
+
+		if err := a.Verify(ctx, manifest); err != nil {
+			return archived, fmt.Errorf("archive of %s failed verification, partition kept: %w", partition.Name, err)
+		}
+		if err := a.source.DropClickPartition(ctx, partition.Name); err != nil {
+			return archived, err
+		}
+		slog.InfoContext(ctx, "archived clicks partition", "partition", partition.Name, "rows", manifest.Rows)
+		archived = append(archived, manifest)
+	}
+	return archived, nil
+}
+
+// ArchiveRange exports clicks with from <= clicked_at < to. The rows stay in Postgres.
+func (a *Archiver) ArchiveRange(ctx context.Context, from, to time.Time) (*Manifest, error) {
+	if !from.Before(to) {
+		return nil, &models.ValidationError{Message: "archive range must end after it starts"}
+	}
+	id := "range_" + from.Format("20060102T150405") + "_" + to.Format("20060102T150405")
+	if _, err := a.readManifest(ctx, id); err == nil {
+		return nil, fmt.Errorf("archive %s already exists", id)
+	} else if !errors.Is(err, ErrNotExist) {
+		return nil, err
+	}
+
+	manifest, err := a.export(ctx, id, "clicks", from, to, func(fn func(*models.ClickRecord) error) error {
+		return a.source.ScanClicks(ctx, from, to, fn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Verify(ctx, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// partWriter compresses one export file into a local temporary file
+type partWriter struct {
+	file   *os.File
+	buf    *bufio.Writer
+	gz     *gzip.Writer
+	enc    *json.Encoder
+	hasher hash.Hash
+	rows   int64
+}
+
+func newPartWriter() (*partWriter, error) {
+	file, err := os.CreateTemp("", "clicks-archive-*.ndjson.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	p := &partWriter{file: file, hasher: sha256.New()}
+	p.buf = bufio.NewWriter(io.MultiWriter(file, p.hasher))
+	p.gz = gzip.NewWriter(p.buf)
+	p.enc = json.NewEncoder(p.gz)
+	return p, nil
+}
+
+func (p *partWriter) close() (size int64, sum string, err error) {
+	if err := p.gz.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := p.buf.Flush(); err != nil {
+		return 0, "", err
+	}
+	size, err = p.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(p.hasher.Sum(nil)), nil
+}
+
+func (p *partWriter) remove() {
+	p.file.Close()
+	os.Remove(p.file.Name())
+}
+
+// export writes the rows produced by scan as an export called id. Files are compressed
+// locally while scanning, uploaded afterwards, and the manifest goes up last.
+func (a *Archiver) export(ctx context.Context, id, source string, from, to time.Time,
+	scan func(fn func(*models.ClickRecord) error) error) (*Manifest, error) {
+	var parts []*partWriter
+	defer func() {
+		for _, p := range parts {
+			p.remove()
+		}
+	}()
+
+	var current *partWriter
+	err := scan(func(rec *models.ClickRecord) error {
+		if current == nil || current.rows >= RowsPerFile {
+			p, err := newPartWriter()
+			if err != nil {
+				return err
+			}
+			parts = append(parts, p)
+			current = p
+		}
+		current.rows++
+		return current.enc.Encode(rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		Version:   manifestVersion,
+		ID:        id,
+		Source:    source,
+		From:      from,
+		To:        to,
+		Format:    Format,
+		CreatedAt: time.Now().UTC(),
+		Files:     []File{},
+	}
+	var sums strings.Builder
+	for i, p := range parts {
+		size, sum, err := p.close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress export file: %w", err)
+		}
+		name := fmt.Sprintf("part-%05d.ndjson.gz", i)
+		if err := a.storage.Put(ctx, exportKey(id, name), p.file, size); err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, File{Name: name, Rows: p.rows, Bytes: size, SHA256: sum})
+		manifest.Rows += p.rows
+		fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+	}
+
+	// SHA256SUMS lets `sha256sum -c` check a downloaded export without reading the manifest
+	if err := a.putBytes(ctx, exportKey(id, "SHA256SUMS"), []byte(sums.String())); err != nil {
+		return nil, err
+	}
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := a.putBytes(ctx, exportKey(id, "manifest.json"), body); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (a *Archiver) putBytes(ctx context.Context, key string, body []byte) error {
+	return a.storage.Put(ctx, key, bytes.NewReader(body), int64(len(body)))
+}
+
+func (a *Archiver) readManifest(ctx context.Context, id string) (*Manifest, error) {
+	r, err := a.storage.Get(ctx, exportKey(id, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %w", id, err)
+	}
+	if manifest.Version != manifestVersion || manifest.Format != Format {
+		return nil, fmt.Errorf("archive %s has unsupported version %d or format %q", id, manifest.Version, manifest.Format)
+	}
+	return &manifest, nil
+}
+
+// Verify reads every file of an export back from storage and checks its size and checksum
+func (a *Archiver) Verify(ctx context.Context, manifest *Manifest) error {
+	for _, f := range manifest.Files {
+		r, err := a.storage.Get(ctx, exportKey(manifest.ID, f.Name))
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		n, err := io.Copy(hasher, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		if n != f.Bytes || hex.EncodeToString(hasher.Sum(nil)) != f.SHA256 {
+			return fmt.Errorf("%s/%s does not match its checksum", manifest.ID, f.Name)
+		}
+	}
+	return nil
+}
+
+// RestoreTable is the table an export is restored into when none is given
+func RestoreTable(id string) string {
+	return "restored_" + strings.ToLower(id)
+}
+
+// Restore loads export id into table (restored_{id} when empty) and returns the row count.
+// Every file is checked against the manifest; on any mismatch nothing is loaded.
+func (a *Archiver) Restore(ctx context.Context, id, table string) (int64, error) {
+	manifest, err := a.readManifest(ctx, id)
+	if errors.Is(err, ErrNotExist) {
+		return 0, &models.NotFoundError{Message: fmt.Sprintf("archive %s not found", id)}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if table == "" {
+		table = RestoreTable(manifest.ID)
+	}
+
+	reader := &exportReader{ctx: ctx, storage: a.storage, manifest: manifest}
+	defer reader.close()
+
+	count, err := a.source.RestoreClicks(ctx, table, reader.next)
+	if err != nil {
+		return 0, err
+	}
+	if count != manifest.Rows {
+		return 0, fmt.Errorf("restored %d rows but the manifest lists %d", count, manifest.Rows)
+	}
+	return count, nil
+}
+
+// exportReader yields the records of an export file by file, checking each file's
+// checksum and row count when it has been read to the end
+type exportReader struct {
+	ctx      context.Context
+	storage  Storage
+	manifest *Manifest
+
+	index  int // next file to open
+	file   io.ReadCloser
+	hasher hash.Hash
+	gz     *gzip.Reader
+	dec    *json.Decoder
+	rows   int64
+}
+
+func (r *exportReader) next() (*models.ClickRecord, error) {
+	for {
+		if r.dec == nil {
+			if r.index >= len(r.manifest.Files) {
+				return nil, nil
+			}
+			if err := r.open(r.manifest.Files[r.index]); err != nil {
+				return nil, err
+			}
+		}
+
+		var rec models.ClickRecord
+		err := r.dec.Decode(&rec)
+		if err == nil {
+			r.rows++
+			return &rec, nil
+		}
+		if err != io.EOF {
+			return nil, fmt.Errorf("failed to decode %s: %w", r.manifest.Files[r.index].Name, err)
+		}
+		if err := r.finish(r.manifest.Files[r.index]); err != nil {
+			return nil, err
+		}
+		r.index++
+	}
+}
+
+func (r *exportReader) open(f File) error {
+	file, err := r.storage.Get(r.ctx, exportKey(r.manifest.ID, f.Name))
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.hasher = sha256.New()
+	r.gz, err = gzip.NewReader(io.TeeReader(bufio.NewReader(file), r.hasher))
+	if err != nil {
+		r.close()
+		return fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	r.dec = json.NewDecoder(r.gz)
+	r.rows = 0
+	return nil
+}
+
+func (r *exportReader) finish(f File) error {
+	// Hash whatever the decompressor left unread before comparing
+	if _, err := io.Copy(r.hasher, r.file); err != nil {
+		return fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	sum := hex.EncodeToString(r.hasher.Sum(nil))
+	r.close()
+	if sum != f.SHA256 {
+		return fmt.Errorf("%s/%s does not match its checksum", r.manifest.ID, f.Name)
+	}
+	if r.rows != f.Rows {
+		return fmt.Errorf("%s/%s has %d rows, manifest lists %d", r.manifest.ID, f.Name, r.rows, f.Rows)
+	}
+	return nil
+}
+
+func (r *exportReader) close() {
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file, r.gz, r.dec = nil, nil, nil
+}
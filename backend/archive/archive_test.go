@@ -0,0 +1,280 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSource is a db.ClickArchiver over detached partitions held in memory
+type fakeSource struct {
+	partitions map[string][]*models.ClickRecord
+	scans      map[string]int
+	dropErr    error
+	locked     bool // another instance holds the partition lock
+	restored   map[string][]*models.ClickRecord
+	restoreCap int // when set, RestoreClicks keeps at most this many rows
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		partitions: make(map[string][]*models.ClickRecord),
+		scans:      make(map[string]int),
+		restored:   make(map[string][]*models.ClickRecord),
+	}
+}
+
+func (f *fakeSource) addPartition(name string, rows int) {
+	month, _ := time.Parse("clicks_2006_01", name)
+	f.partitions[name] = []*models.ClickRecord{}
+	for i := 0; i < rows; i++ {
+		f.partitions[name] = append(f.partitions[name], &models.ClickRecord{
+			ID:          int64(i + 1),
+			ShortCode:   "abc123",
+			ClickedAt:   month.Add(time.Duration(i) * time.Minute),
+			VisitorHash: fmt.Sprintf("v%d", i%3),
+		})
+	}
+}
+
+func (f *fakeSource) DetachedClickPartitions(ctx context.Context) ([]db.ClickPartition, error) {
+	var partitions []db.ClickPartition
+	for name := range f.partitions {
+		month, _ := time.Parse("clicks_2006_01", name)
+		partitions = append(partitions, db.ClickPartition{Name: name, From: month, To: month.AddDate(0, 1, 0)})
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Name < partitions[j].Name })
+	return partitions, nil
+}
+
+func (f *fakeSource) ScanClickPartition(ctx context.Context, partition string, fn func(*models.ClickRecord) error) error {
+	f.scans[partition]++
+	for _, rec := range f.partitions[partition] {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeSource) ScanClicks(ctx context.Context, from, to time.Time, fn func(*models.ClickRecord) error) error {
+	return errors.New("not used")
+}
+
+func (f *fakeSource) DropClickPartition(ctx context.Context, partition string) error {
+	if f.dropErr != nil {
+		return f.dropErr
+	}
+	delete(f.partitions, partition)
+	return nil
+}
+
+func (f *fakeSource) RestoreClicks(ctx context.Context, table string, next func() (*models.ClickRecord, error)) (int64, error) {
+	var rows []*models.ClickRecord
+	for {
+		rec, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		if f.restoreCap == 0 || len(rows) < f.restoreCap {
+			rows = append(rows, rec)
+		}
+	}
+	f.restored[table] = rows
+	return int64(len(rows)), nil
+}
+
+func (f *fakeSource) WithPartitionLock(ctx context.Context, fn func() error) (bool, error) {
+	if f.locked {
+		return false, nil
+	}
+	return true, fn()
+}
+
+func newTestArchiver(t *testing.T, source *fakeSource) (*Archiver, string) {
+	t.Helper()
+	dir := t.TempDir()
+	storage, err := OpenStorage(dir, S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(source, storage), dir
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newFakeSource()
+	source.addPartition("clicks_2024_01", 5)
+	source.addPartition("clicks_2024_02", 0)
+	archiver, dir := newTestArchiver(t, source)
+
+	manifests, err := archiver.ArchivePartitions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 || manifests[0].ID != "clicks_2024_01" || manifests[0].Rows != 5 || len(manifests[0].Files) != 1 {
+		t.Fatalf("manifests = %+v", manifests)
+	}
+	if len(source.partitions) != 0 {
+		t.Errorf("partitions left after archiving: %v", source.partitions)
+	}
+	for _, name := range []string{"part-00000.ndjson.gz", "SHA256SUMS", "manifest.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "clicks", "clicks_2024_01", name)); err != nil {
+			t.Errorf("export file missing: %v", err)
+		}
+	}
+	sums, _ := os.ReadFile(filepath.Join(dir, "clicks", "clicks_2024_01", "SHA256SUMS"))
+	if want := manifests[0].Files[0].SHA256 + "  part-00000.ndjson.gz\n"; string(sums) != want {
+		t.Errorf("SHA256SUMS = %q, want %q", sums, want)
+	}
+
+	count, err := archiver.Restore(ctx, "clicks_2024_01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := source.restored[RestoreTable("clicks_2024_01")]
+	if count != 5 || len(rows) != 5 {
+		t.Fatalf("restored %d rows (%d loaded), want 5", count, len(rows))
+	}
+	if rows[4].ID != 5 || rows[4].VisitorHash != "v1" || !rows[4].ClickedAt.Equal(time.Date(2024, 1, 1, 0, 4, 0, 0, time.UTC)) {
+		t.Errorf("last restored row = %+v", rows[4])
+	}
+
+	var notFound *models.NotFoundError
+	if _, err := archiver.Restore(ctx, "clicks_2023_12", ""); !errors.As(err, &notFound) {
+		t.Errorf("restoring a missing export: error %v, want NotFoundError", err)
+	}
+}
+
+func TestArchiveResumesWithoutReexport(t *testing.T) {
+	ctx := context.Background()
+	source := newFakeSource()
+	source.addPartition("clicks_2024_01", 3)
+	archiver, _ := newTestArchiver(t, source)
+
+	// The first run uploads everything, then fails to drop the partition
+	source.dropErr = errors.New("connection reset")
+	if _, err := archiver.ArchivePartitions(ctx); err == nil {
+		t.Fatal("expected the drop error")
+	}
+	if source.scans["clicks_2024_01"] != 1 || source.partitions["clicks_2024_01"] == nil {
+		t.Fatalf("after a failed drop: %d scans, partition kept %v", source.scans["clicks_2024_01"], source.partitions["clicks_2024_01"] != nil)
+	}
+
+	source.dropErr = nil
+	manifests, err := archiver.ArchivePartitions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.scans["clicks_2024_01"] != 1 {
+		t.Errorf("partition scanned %d times, want the existing export reused", source.scans["clicks_2024_01"])
+	}
+	if len(manifests) != 1 || manifests[0].Rows != 3 || len(source.partitions) != 0 {
+		t.Errorf("manifests %+v, partitions left %v", manifests, source.partitions)
+	}
+}
+
+// corruptingStorage flips a byte of every export file it stores
+type corruptingStorage struct {
+	Storage
+}
+
+func (s corruptingStorage) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	if !strings.HasSuffix(key, ".ndjson.gz") {
+		return s.Storage.Put(ctx, key, body, size)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	data[len(data)/2] ^= 0xff
+	return s.Storage.Put(ctx, key, bytes.NewReader(data), size)
+}
+
+func TestArchiveKeepsPartitionFailingVerification(t *testing.T) {
+	ctx := context.Background()
+	source := newFakeSource()
+	source.addPartition("clicks_2024_01", 3)
+	storage, err := OpenStorage(t.TempDir(), S3Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiver := New(source, corruptingStorage{storage})
+
+	manifests, err := archiver.ArchivePartitions(ctx)
+	if err == nil || !strings.Contains(err.Error(), "failed verification") {
+		t.Fatalf("error = %v, want a verification failure", err)
+	}
+	if len(manifests) != 0 || source.partitions["clicks_2024_01"] == nil {
+		t.Errorf("partition dropped after a failed verification")
+	}
+
+	// The corrupt export is never restored either
+	if _, err := archiver.Restore(ctx, "clicks_2024_01", ""); err == nil {
+		t.Error("restored a corrupt export")
+	}
+	if len(source.restored) != 0 {
+		t.Errorf("rows loaded from a corrupt export: %v", source.restored)
+	}
+}
+
+func TestRestoreRowCountMismatch(t *testing.T) {
+	ctx := context.Background()
+	source := newFakeSource()
+	source.addPartition("clicks_2024_01", 4)
+	archiver, _ := newTestArchiver(t, source)
+	if _, err := archiver.ArchivePartitions(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file holding fewer rows than its manifest entry fails even with a good checksum
+	manifest, err := archiver.readManifest(ctx, "clicks_2024_01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Files[0].Rows++
+	manifest.Rows++
+	body, _ := json.Marshal(manifest)
+	archiver.putBytes(ctx, exportKey(manifest.ID, "manifest.json"), body)
+	if _, err := archiver.Restore(ctx, "clicks_2024_01", ""); err == nil || !strings.Contains(err.Error(), "manifest lists 5") {
+		t.Errorf("file row mismatch: error %v", err)
+	}
+	manifest.Files[0].Rows--
+	manifest.Rows--
+	body, _ = json.Marshal(manifest)
+	archiver.putBytes(ctx, exportKey(manifest.ID, "manifest.json"), body)
+
+	// So does a load that keeps fewer rows than it was given
+	source.restoreCap = 3
+	if _, err := archiver.Restore(ctx, "clicks_2024_01", "t"); err == nil || !strings.Contains(err.Error(), "restored 3 rows") {
+		t.Errorf("load row mismatch: error %v", err)
+	}
+}
+
+func TestArchiveSkipsWhenLocked(t *testing.T) {
+	source := newFakeSource()
+	source.addPartition("clicks_2024_01", 1)
+	source.locked = true
+	archiver, _ := newTestArchiver(t, source)
+
+	if _, err := archiver.ArchivePartitions(context.Background()); !errors.Is(err, ErrPartitionsLocked) {
+		t.Errorf("error = %v, want ErrPartitionsLocked", err)
+	}
+	if source.scans["clicks_2024_01"] != 0 || source.partitions["clicks_2024_01"] == nil {
+		t.Error("archived while another instance held the lock")
+	}
+}
@@ -0,0 +1,205 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned by Storage.Get for a missing key
+var ErrNotExist = errors.New("archive object does not exist")
+
+// Storage holds archive files under slash-separated keys
+type Storage interface {
+	// Put stores size bytes from body under key, replacing any existing object
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
+	// Get opens the object stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// S3Config locates an S3-compatible service
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// OpenStorage returns the storage for an ARCHIVE_URL: s3://bucket/prefix for an
+// S3-compatible bucket, or file:///path (or a plain path) for a local directory
+func OpenStorage(archiveURL string, s3 S3Config) (Storage, error) {
+	if bucketPath, ok := strings.CutPrefix(archiveURL, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(bucketPath, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("archive URL %q has no bucket", archiveURL)
+		}
+		if s3.AccessKey == "" || s3.SecretKey == "" {
+			return nil, fmt.Errorf("S3 archive needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		if s3.Endpoint == "" {
+			s3.Endpoint = "https://s3." + s3.Region + ".amazonaws.com"
+		}
+		endpoint, err := url.Parse(s3.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", s3.Endpoint)
+		}
+		return &S3Storage{
+			endpoint: endpoint,
+			bucket:   bucket,
+			prefix:   strings.Trim(prefix, "/"),
+			config:   s3,
+			client:   &http.Client{Timeout: 10 * time.Minute},
+		}, nil
+	}
+
+	dir := strings.TrimPrefix(archiveURL, "file://")
+	if dir == "" {
+		return nil, fmt.Errorf("archive URL is empty")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &DirStorage{dir: dir}, nil
+}
+
+// DirStorage keeps archives in a local directory
+type DirStorage struct {
+	dir string
+}
+
+func (d *DirStorage) path(key string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file and renames it, so a key never holds a partial file
+func (d *DirStorage) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	target := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, body)
+	if err == nil && n != size {
+		err = fmt.Errorf("wrote %d bytes, expected %d", n, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+func (d *DirStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// S3Storage keeps archives in an S3-compatible bucket, addressed path-style
+// (endpoint/bucket/key) so it also works with MinIO and similar services
+type S3Storage struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	config   S3Config
+	client   *http.Client
+}
+
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	objectPath := s.bucket + "/" + key
+	if s.prefix != "" {
+		objectPath = s.bucket + "/" + s.prefix + "/" + key
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + objectPath
+	return &u
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), body)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		var statusErr *s3StatusError
+		if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+// s3StatusError is a non-2xx response from the S3 service
+type s3StatusError struct {
+	status int
+	body   string
+}
+
+func (e *s3StatusError) Error() string {
+	return fmt.Sprintf("S3 returned %d: %s", e.status, e.body)
+}
+
+// do signs and sends req; the payload is not hashed for the signature since
+// every archive file is verified against the manifest checksums instead
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signV4(req, s.config.AccessKey, s.config.SecretKey, s.config.Region, "s3", unsignedPayload, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &s3StatusError{status: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
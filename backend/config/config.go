@@ -3,14 +3,49 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage backends, chosen by the DATABASE_URL scheme
+const (
+	DatabasePostgres = "postgres" // postgres:// or postgresql://, with Redis
+	DatabaseSQLite   = "sqlite"   // sqlite://, sqlite3:// or file:, with in-process cache and rate limiting
 )
 
 type Config struct {
-	DatabaseURL string
-	RedisURL    string
-	Port        string
-	BaseURL     string // Base URL for generating short URLs (e.g., http://localhost:8080)
-	FrontendURL string // Frontend URL for CORS and short URL generation (e.g., http://localhost:3000)
+	DatabaseURL     string
+	DatabaseBackend string // DatabasePostgres or DatabaseSQLite
+	ReplicaURL      string // Optional Postgres read replica for analytics queries
+	ReplicaMaxLag   time.Duration
+	RedisURL        string
+	Port            string
+	BaseURL         string // Base URL for generating short URLs (e.g., http://localhost:8080)
+	FrontendURL     string // Frontend URL for CORS and short URL generation (e.g., http://localhost:3000)
+
+	BlocklistFile    string // Optional path to the destination blocklist (domains and regex: lines)
+	LinkQuotaPerHour int    // Max links a user can create per hour, 0 disables the quota
+	ResolveRedirects bool   // Follow destination redirects on link creation
+	LiveClickFeed    bool   // Publish per-click events to stream clients that opt in
+	AutoMigrate      bool   // Apply pending schema migrations on startup
+
+	ClickRetentionMonths int  // Months of raw clicks kept in Postgres, 0 keeps everything
+	DetachExpiredClicks  bool // Detach expired clicks partitions for archiving instead of dropping them; only with ArchiveURL
+
+	ArchiveURL        string // Where archived clicks go: s3://bucket/prefix or a local directory, empty disables archiving
+	ArchiveS3Endpoint string // S3-compatible endpoint, defaults to AWS for the region
+	ArchiveS3Region   string
+	ArchiveAccessKey  string
+	ArchiveSecretKey  string
+
+	TracingExporter    string  // none, otlp or stdout
+	TracingEndpoint    string  // OTLP/HTTP collector URL, e.g. http://otel-collector:4318
+	TracingSampleRatio float64 // Fraction of new traces to sample (0-1)
+
+	LogLevel               string  // debug, info, warn or error
+	LogFormat              string  // json or text
+	LogRedirectSampleRatio float64 // Fraction of redirects that are logged (0-1)
 }
 
 func Load() (*Config, error) {
@@ -18,6 +53,24 @@ func Load() (*Config, error) {
 	if dbURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
 	}
+	dbBackend, err := databaseBackend(dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaURL := os.Getenv("DATABASE_REPLICA_URL")
+	if replicaURL != "" && dbBackend != DatabasePostgres {
+		return nil, fmt.Errorf("DATABASE_REPLICA_URL needs a Postgres DATABASE_URL")
+	}
+
+	replicaMaxLag := 10 * time.Second
+	if v := os.Getenv("DATABASE_REPLICA_MAX_LAG"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("DATABASE_REPLICA_MAX_LAG must be a positive duration such as 10s")
+		}
+		replicaMaxLag = d
+	}
 
 	redisURL := os.Getenv("REDIS_URL")
 	if redisURL == "" {
@@ -39,12 +92,121 @@ func Load() (*Config, error) {
 		frontendURL = "http://localhost:3000"
 	}
 
+	linkQuota := 100
+	if v := os.Getenv("LINK_QUOTA_PER_HOUR"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("LINK_QUOTA_PER_HOUR must be a non-negative integer")
+		}
+		linkQuota = n
+	}
+
+	retentionMonths := 0
+	if v := os.Getenv("CLICK_RETENTION_MONTHS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("CLICK_RETENTION_MONTHS must be a non-negative integer")
+		}
+		retentionMonths = n
+	}
+
+	// Detached partitions are only dropped once archived, so without an archive
+	// expired partitions are dropped straight away
+	archiveURL := os.Getenv("ARCHIVE_URL")
+	detachExpired := archiveURL != ""
+	switch mode := os.Getenv("CLICK_RETENTION_MODE"); mode {
+	case "":
+	case "detach":
+		if archiveURL == "" && retentionMonths > 0 {
+			return nil, fmt.Errorf("CLICK_RETENTION_MODE=detach needs ARCHIVE_URL, or detached partitions are never dropped")
+		}
+		detachExpired = true
+	case "drop":
+		detachExpired = false
+	default:
+		return nil, fmt.Errorf("CLICK_RETENTION_MODE must be detach or drop, got %q", mode)
+	}
+
+	tracingExporter := os.Getenv("TRACING_EXPORTER")
+	if tracingExporter == "" {
+		tracingExporter = "none"
+	}
+
+	sampleRatio := 1.0
+	if v := os.Getenv("TRACING_SAMPLE_RATIO"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("TRACING_SAMPLE_RATIO must be a number between 0 and 1")
+		}
+		sampleRatio = f
+	}
+
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "json"
+	}
+
+	redirectSampleRatio := 0.01
+	if v := os.Getenv("LOG_REDIRECT_SAMPLE_RATIO"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("LOG_REDIRECT_SAMPLE_RATIO must be a number between 0 and 1")
+		}
+		redirectSampleRatio = f
+	}
+
 	return &Config{
-		DatabaseURL: dbURL,
-		RedisURL:    redisURL,
-		Port:        port,
-		BaseURL:     baseURL,
-		FrontendURL: frontendURL,
+		DatabaseURL:      dbURL,
+		DatabaseBackend:  dbBackend,
+		ReplicaURL:       replicaURL,
+		ReplicaMaxLag:    replicaMaxLag,
+		RedisURL:         redisURL,
+		Port:             port,
+		BaseURL:          baseURL,
+		FrontendURL:      frontendURL,
+		BlocklistFile:    os.Getenv("BLOCKLIST_FILE"),
+		LinkQuotaPerHour: linkQuota,
+		ResolveRedirects: os.Getenv("RESOLVE_REDIRECTS") == "true",
+		LiveClickFeed:    os.Getenv("LIVE_CLICK_FEED") == "true",
+		AutoMigrate:      os.Getenv("AUTO_MIGRATE") == "true",
+
+		ClickRetentionMonths: retentionMonths,
+		DetachExpiredClicks:  detachExpired,
+
+		ArchiveURL:        archiveURL,
+		ArchiveS3Endpoint: os.Getenv("ARCHIVE_S3_ENDPOINT"),
+		ArchiveS3Region:   os.Getenv("ARCHIVE_S3_REGION"),
+		ArchiveAccessKey:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		ArchiveSecretKey:  os.Getenv("AWS_SECRET_ACCESS_KEY"),
+
+		TracingExporter:    tracingExporter,
+		TracingEndpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		TracingSampleRatio: sampleRatio,
+
+		LogLevel:               logLevel,
+		LogFormat:              logFormat,
+		LogRedirectSampleRatio: redirectSampleRatio,
 	}, nil
 }
 
+// databaseBackend picks the storage backend from the DATABASE_URL scheme.
+// A DSN without a scheme (host=... dbname=...) is passed to Postgres as before.
+func databaseBackend(dbURL string) (string, error) {
+	scheme, _, found := strings.Cut(dbURL, ":")
+	if !found || strings.Contains(scheme, "=") || strings.Contains(scheme, " ") {
+		return DatabasePostgres, nil
+	}
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return DatabasePostgres, nil
+	case "sqlite", "sqlite3", "file":
+		return DatabaseSQLite, nil
+	default:
+		return "", fmt.Errorf("DATABASE_URL has unsupported scheme %q (use postgres://, sqlite:// or file:)", scheme)
+	}
+}
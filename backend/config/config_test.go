@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestClickRetentionMode(t *testing.T) {
+	for _, tc := range []struct {
+		retention, mode, archive string
+		detach                   bool
+		fails                    bool
+	}{
+		{retention: "3", detach: false},
+		{retention: "3", archive: "/var/archive", detach: true},
+		{retention: "3", mode: "drop", archive: "/var/archive", detach: false},
+		{retention: "3", mode: "detach", archive: "/var/archive", detach: true},
+		{retention: "3", mode: "detach", fails: true}, // nothing would ever drop the partitions
+		{retention: "0", mode: "detach", detach: true},
+		{retention: "3", mode: "archive", fails: true},
+	} {
+		t.Setenv("DATABASE_URL", "postgres://localhost/links")
+		t.Setenv("CLICK_RETENTION_MONTHS", tc.retention)
+		t.Setenv("CLICK_RETENTION_MODE", tc.mode)
+		t.Setenv("ARCHIVE_URL", tc.archive)
+
+		cfg, err := Load()
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%+v: loaded, want an error", tc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tc, err)
+			continue
+		}
+		if cfg.DetachExpiredClicks != tc.detach {
+			t.Errorf("%+v: detach = %v", tc, cfg.DetachExpiredClicks)
+		}
+	}
+}
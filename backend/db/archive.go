@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ClickPartition is a monthly clicks partition that has been detached from clicks
+type ClickPartition struct {
+	Name string
+	From time.Time // first day of the month, inclusive
+	To   time.Time // first day of the next month, exclusive
+}
+
+// Restored clicks go into a new plain table with a simple lower-case name
+var restoreTableName = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+const selectClickRecords = `SELECT id, short_code, clicked_at, COALESCE(HOST(ip_address), ''), COALESCE(user_agent, ''),
+                                   COALESCE(referer, ''), COALESCE(visitor_hash, ''), COALESCE(request_id, '')`
+
+// DetachedClickPartitions lists the clicks_YYYY_MM tables that are no longer attached
+// to clicks, oldest first. These are what the retention policy leaves for archiving.
+func (p *PostgresDB) DetachedClickPartitions(ctx context.Context) (_ []ClickPartition, err error) {
+	ctx, span := startPostgresSpan(ctx, "DetachedClickPartitions")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := p.db.QueryContext(ctx, `SELECT c.relname
+	                                     FROM pg_class c
+	                                     JOIN pg_namespace n ON n.oid = c.relnamespace
+	                                     WHERE c.relkind = 'r' AND NOT c.relispartition
+	                                       AND n.nspname = current_schema()
+	                                       AND c.relname ~ '^clicks_[0-9]{4}_[0-9]{2}$'
+	                                     ORDER BY c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list detached partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []ClickPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		if month, ok := parseClickPartition(name); ok {
+			partitions = append(partitions, ClickPartition{Name: name, From: month, To: month.AddDate(0, 1, 0)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return partitions, nil
+}
+
+// ScanClickPartition calls fn for every row of a detached partition, in id order
+func (p *PostgresDB) ScanClickPartition(ctx context.Context, partition string, fn func(*models.ClickRecord) error) (err error) {
+	ctx, span := startPostgresSpan(ctx, "ScanClickPartition")
+	defer func() { endSpan(span, err) }()
+
+	if !clickPartitionPattern.MatchString(partition) {
+		return &models.ValidationError{Message: fmt.Sprintf("%q is not a clicks partition", partition)}
+	}
+	return p.scanClicks(ctx, p.db, selectClickRecords+` FROM `+pq.QuoteIdentifier(partition)+` ORDER BY id`, fn)
+}
+
+// ScanClicks calls fn for every click with from <= clicked_at < to, in time order
+func (p *PostgresDB) ScanClicks(ctx context.Context, from, to time.Time, fn func(*models.ClickRecord) error) (err error) {
+	ctx, span := startPostgresSpan(ctx, "ScanClicks")
+	defer func() { endSpan(span, err) }()
+
+	return p.scanClicks(ctx, p.db, selectClickRecords+` FROM clicks WHERE clicked_at >= $1 AND clicked_at < $2 ORDER BY clicked_at, id`,
+		fn, from, to)
+}
+
+// ScanLinkClicks streams from the replica when it is healthy. The rows are handed
+// to fn as they arrive, so a failure is not retried on the primary.
+func (p *PostgresDB) ScanLinkClicks(ctx context.Context, shortCode string, from, to time.Time, fn func(*models.ClickRecord) error) (err error) {
+	ctx, span := startPostgresSpan(ctx, "ScanLinkClicks")
+	defer func() { endSpan(span, err) }()
+
+	return p.scanClicks(ctx, p.reader(ctx), selectClickRecords+` FROM clicks
+	                     WHERE short_code = $1 AND clicked_at >= $2 AND clicked_at < $3 ORDER BY clicked_at, id`,
+		fn, shortCode, from, to)
+}
+
+func (p *PostgresDB) scanClicks(ctx context.Context, db *sql.DB, query string, fn func(*models.ClickRecord) error, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query clicks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec models.ClickRecord
+		if err := rows.Scan(&rec.ID, &rec.ShortCode, &rec.ClickedAt, &rec.IPAddress, &rec.UserAgent,
+			&rec.Referer, &rec.VisitorHash, &rec.RequestID); err != nil {
+			return fmt.Errorf("failed to scan click: %w", err)
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("row iteration error: %w", err)
+	}
+	return nil
+}
+
+// DropClickPartition drops a detached partition once it has been archived.
+// Partitions still attached to clicks are refused.
+func (p *PostgresDB) DropClickPartition(ctx context.Context, partition string) (err error) {
+	ctx, span := startPostgresSpan(ctx, "DropClickPartition")
+	defer func() { endSpan(span, err) }()
+
+	if !clickPartitionPattern.MatchString(partition) {
+		return &models.ValidationError{Message: fmt.Sprintf("%q is not a clicks partition", partition)}
+	}
+
+	var attached bool
+	err = p.db.QueryRowContext(ctx, `SELECT relispartition FROM pg_class WHERE oid = to_regclass($1)`, partition).Scan(&attached)
+	if err == sql.ErrNoRows {
+		return &models.NotFoundError{Message: "partition not found"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up partition: %w", err)
+	}
+	if attached {
+		return &models.ValidationError{Message: fmt.Sprintf("partition %s is still attached to clicks", partition)}
+	}
+
+	if _, err := p.db.ExecContext(ctx, `DROP TABLE `+pq.QuoteIdentifier(partition)); err != nil {
+		return fmt.Errorf("failed to drop partition: %w", err)
+	}
+	return nil
+}
+
+// RestoreClicks creates table with the clicks columns and loads the rows returned by
+// next until it returns nil, all in one transaction. The table stays separate from
+// clicks, so restored rows never count twice in stats.
+func (p *PostgresDB) RestoreClicks(ctx context.Context, table string, next func() (*models.ClickRecord, error)) (_ int64, err error) {
+	ctx, span := startPostgresSpan(ctx, "RestoreClicks")
+	defer func() { endSpan(span, err) }()
+
+	if !restoreTableName.MatchString(table) {
+		return 0, &models.ValidationError{Message: fmt.Sprintf("invalid table name %q", table)}
+	}
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE `+pq.QuoteIdentifier(table)+` (
+		id BIGINT NOT NULL,
+		short_code VARCHAR(10) NOT NULL,
+		clicked_at TIMESTAMP NOT NULL,
+		ip_address INET,
+		user_agent TEXT,
+		referer TEXT,
+		visitor_hash VARCHAR(64),
+		request_id TEXT
+	)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create table %s: %w", table, err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, "id", "short_code", "clicked_at", "ip_address",
+		"user_agent", "referer", "visitor_hash", "request_id"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to start copy: %w", err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	for {
+		rec, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if rec == nil {
+			break
+		}
+		_, err = stmt.ExecContext(ctx, rec.ID, rec.ShortCode, rec.ClickedAt, nullIfEmpty(rec.IPAddress),
+			nullIfEmpty(rec.UserAgent), nullIfEmpty(rec.Referer), nullIfEmpty(rec.VisitorHash), nullIfEmpty(rec.RequestID))
+		if err != nil {
+			return 0, fmt.Errorf("failed to copy click %d: %w", rec.ID, err)
+		}
+		count++
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, fmt.Errorf("failed to finish copy: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE INDEX ON `+pq.QuoteIdentifier(table)+` (short_code, clicked_at)`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to index %s: %w", table, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"time"
+)
+
+// maxShortCodeAttempts bounds the short codes tried per link in CreateLinks
+const maxShortCodeAttempts = 5
+
+// createLinks inserts links in one transaction. ON CONFLICT DO NOTHING turns a
+// taken short code into an empty result instead of an error, which would abort
+// the whole transaction in Postgres, so the link can retry with a new code.
+func createLinks(ctx context.Context, db *sql.DB, dialect linkDialect, links []*models.Link, newCode func() string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO links (short_code, original_url, user_id, created_at, resolved_url,
+	                                                       redirect_chain, title, description, tags, folder_id)
+	                                     VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10)
+	                                     ON CONFLICT (short_code) DO NOTHING
+	                                     RETURNING id, created_at`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	createdAt := dialect.bindTime(time.Now())
+	for _, link := range links {
+		var chain interface{}
+		if len(link.RedirectChain) > 0 {
+			chain = dialect.bindStrings(link.RedirectChain)
+		}
+		if link.ShortCode == "" {
+			link.ShortCode = newCode()
+		}
+		for attempt := 1; ; attempt++ {
+			err := stmt.QueryRowContext(ctx, link.ShortCode, link.OriginalURL, link.UserID, createdAt, link.ResolvedURL,
+				chain, nullString(link.Title), nullString(link.Description), dialect.bindStrings(link.Tags),
+				nullInt64Arg(link.FolderID)).Scan(&link.ID, &link.CreatedAt)
+			if err == nil {
+				break
+			}
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("failed to create link: %w", err)
+			}
+			if attempt == maxShortCodeAttempts {
+				return fmt.Errorf("failed to create link: no free short code after %d attempts", attempt)
+			}
+			link.ShortCode = newCode()
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
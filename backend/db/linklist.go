@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"strings"
+	"time"
+)
+
+// linkDialect holds the SQL that differs between backends for the link queries
+// shared by PostgresDB and SQLiteDB
+type linkDialect struct {
+	// hostContains returns a condition that is true when the destination host of
+	// l.original_url contains the placeholder arg, ignoring case
+	hostContains func(arg string) string
+	// hasTag returns a condition that is true when l.tags contains the placeholder arg
+	hasTag func(arg string) string
+	// tagCounts selects tag and link count for the tags of user $1, most used first
+	tagCounts string
+	// bindTime converts a time for use as a query argument
+	bindTime func(time.Time) interface{}
+	// bindStrings and scanStrings convert string lists, such as tags, to and from
+	// the column type
+	bindStrings func([]string) interface{}
+	scanStrings func(*[]string) interface{}
+	// lockFolders, if set, locks the folders of user $1 for the rest of the
+	// transaction, so concurrent folder moves are checked one at a time
+	lockFolders string
+}
+
+// listLinks runs ListLinks as two queries: the page, joined with link_stats for the
+// click totals, and the count of all matching links. Pages are keyset-paginated on
+// the sort column with the link id as tie-breaker, so deep pages cost the same as the first.
+func listLinks(ctx context.Context, db *sql.DB, dialect linkDialect, q models.LinkListQuery) (*models.LinkPage, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where = append(where, "l.user_id = "+arg(q.UserID))
+	if q.Domain != "" {
+		where = append(where, dialect.hostContains(arg(q.Domain)))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "l.created_at >= "+arg(dialect.bindTime(q.From)))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "l.created_at < "+arg(dialect.bindTime(q.To)))
+	}
+	if q.Tag != "" {
+		where = append(where, dialect.hasTag(arg(q.Tag)))
+	}
+	if q.FolderID != 0 {
+		where = append(where, "l.folder_id = "+arg(q.FolderID))
+	}
+
+	var total int64
+	countQuery := `SELECT COUNT(*) FROM links l WHERE ` + strings.Join(where, " AND ")
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count links: %w", err)
+	}
+
+	sortColumn := "l.created_at"
+	if q.Sort == models.LinkSortClicks {
+		sortColumn = "COALESCE(s.total_clicks, 0)"
+	}
+	direction, after := "DESC", "<"
+	if q.Ascending {
+		direction, after = "ASC", ">"
+	}
+
+	if q.After != nil {
+		var position interface{} = dialect.bindTime(q.After.CreatedAt)
+		if q.Sort == models.LinkSortClicks {
+			position = q.After.TotalClicks
+		}
+		where = append(where, fmt.Sprintf("(%s, l.id) %s (%s, %s)", sortColumn, after, arg(position), arg(q.After.ID)))
+	}
+
+	// One row more than the page tells whether there is a next page
+	pageQuery := `SELECT l.id, l.short_code, l.original_url, l.user_id, l.created_at,
+	                     COALESCE(l.title, ''), l.tags, l.folder_id, COALESCE(s.total_clicks, 0)
+	              FROM links l
+	              LEFT JOIN link_stats s ON s.short_code = l.short_code
+	              WHERE ` + strings.Join(where, " AND ") + `
+	              ORDER BY ` + sortColumn + ` ` + direction + `, l.id ` + direction + `
+	              LIMIT ` + arg(q.Limit+1)
+
+	rows, err := db.QueryContext(ctx, pageQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query links: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.LinkPage{Links: make([]*models.LinkSummary, 0, q.Limit), Total: total}
+	for rows.Next() {
+		link := &models.LinkSummary{}
+		var folderID sql.NullInt64
+		if err := rows.Scan(&link.ID, &link.ShortCode, &link.OriginalURL, &link.UserID, &link.CreatedAt,
+			&link.Title, dialect.scanStrings(&link.Tags), &folderID, &link.TotalClicks); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		link.FolderID = nullInt64Ptr(folderID)
+		page.Links = append(page.Links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	if len(page.Links) > q.Limit {
+		page.Links = page.Links[:q.Limit]
+		page.Next = linkCursor(q, page.Links[q.Limit-1])
+	}
+	return page, nil
+}
+
+// linkCursor is the position of link in the order q lists links in
+func linkCursor(q models.LinkListQuery, link *models.LinkSummary) *models.LinkCursor {
+	return &models.LinkCursor{
+		Sort:        q.Sort,
+		Ascending:   q.Ascending,
+		CreatedAt:   link.CreatedAt,
+		TotalClicks: link.TotalClicks,
+		ID:          link.ID,
+	}
+}
@@ -0,0 +1,961 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"link-analytics-service/models"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store. It keeps everything in maps and is meant
+// for tests and local experiments; nothing survives a restart.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	nextID       int
+	links        map[string]*models.Link
+	clicks       []models.ClickEvent
+	stats        map[string]*models.LinkStats
+	referrers    map[string]map[string]int64 // shortCode -> referer -> count
+	folders      map[int64]*models.Folder
+	nextFolderID int64
+	settings     map[string]models.UserSettings
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		links:     make(map[string]*models.Link),
+		stats:     make(map[string]*models.LinkStats),
+		referrers: make(map[string]map[string]int64),
+		folders:   make(map[int64]*models.Folder),
+		settings:  make(map[string]models.UserSettings),
+	}
+}
+
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStore) CreateLink(ctx context.Context, link *models.Link) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.links[link.ShortCode]; exists {
+		return fmt.Errorf("failed to create link: short code %s already exists", link.ShortCode)
+	}
+	m.nextID++
+	link.ID = m.nextID
+	link.CreatedAt = time.Now()
+	stored := *link
+	stored.Tags = slices.Clone(link.Tags)
+	m.links[link.ShortCode] = &stored
+	return nil
+}
+
+// CreateLinks adds all links or none, retrying taken short codes with newCode
+func (m *MemoryStore) CreateLinks(ctx context.Context, links []*models.Link, newCode func() string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	taken := make(map[string]bool, len(links))
+	for _, link := range links {
+		if link.ShortCode == "" {
+			link.ShortCode = newCode()
+		}
+		for attempt := 1; m.links[link.ShortCode] != nil || taken[link.ShortCode]; attempt++ {
+			if attempt == maxShortCodeAttempts {
+				return fmt.Errorf("failed to create link: no free short code after %d attempts", attempt)
+			}
+			link.ShortCode = newCode()
+		}
+		taken[link.ShortCode] = true
+	}
+
+	now := time.Now()
+	for _, link := range links {
+		m.nextID++
+		link.ID = m.nextID
+		link.CreatedAt = now
+		stored := *link
+		stored.Tags = slices.Clone(link.Tags)
+		m.links[link.ShortCode] = &stored
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetLinkByCode(ctx context.Context, shortCode string) (*models.Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	link, ok := m.links[shortCode]
+	if !ok {
+		return nil, &models.NotFoundError{Message: "link not found"}
+	}
+	found := *link
+	return &found, nil
+}
+
+func (m *MemoryStore) GetLinksByUser(ctx context.Context, userID string) ([]*models.Link, error) {
+	return m.filterLinks(func(link *models.Link) bool { return link.UserID == userID }), nil
+}
+
+func (m *MemoryStore) FindLinkByURL(ctx context.Context, userID, url string) (*models.Link, error) {
+	links := m.filterLinks(func(link *models.Link) bool { return link.UserID == userID && link.OriginalURL == url })
+	if len(links) == 0 {
+		return nil, &models.NotFoundError{Message: "link not found"}
+	}
+	oldest := links[0]
+	for _, link := range links[1:] {
+		if link.ID < oldest.ID {
+			oldest = link
+		}
+	}
+	return oldest, nil
+}
+
+// ListLinks filters, sorts and pages the user's links the same way the SQL stores do
+func (m *MemoryStore) ListLinks(ctx context.Context, q models.LinkListQuery) (*models.LinkPage, error) {
+	domain := strings.ToLower(q.Domain)
+	links := m.filterLinks(func(link *models.Link) bool {
+		if link.UserID != q.UserID {
+			return false
+		}
+		if q.Tag != "" && !slices.Contains(link.Tags, q.Tag) {
+			return false
+		}
+		if q.FolderID != 0 && (link.FolderID == nil || *link.FolderID != q.FolderID) {
+			return false
+		}
+		if domain != "" {
+			u, err := url.Parse(link.OriginalURL)
+			if err != nil || !strings.Contains(strings.ToLower(u.Host), domain) {
+				return false
+			}
+		}
+		return (q.From.IsZero() || !link.CreatedAt.Before(q.From)) && (q.To.IsZero() || link.CreatedAt.Before(q.To))
+	})
+
+	m.mu.RLock()
+	summaries := make([]*models.LinkSummary, 0, len(links))
+	for _, link := range links {
+		summary := &models.LinkSummary{Link: *link}
+		if stats, ok := m.stats[link.ShortCode]; ok {
+			summary.TotalClicks = stats.TotalClicks
+		}
+		summaries = append(summaries, summary)
+	}
+	m.mu.RUnlock()
+
+	// compare orders a before b (-1), at the same position (0) or after b (1)
+	compare := func(a, b *models.LinkCursor) int {
+		c := a.CreatedAt.Compare(b.CreatedAt)
+		if q.Sort == models.LinkSortClicks {
+			c = cmp.Compare(a.TotalClicks, b.TotalClicks)
+		}
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if !q.Ascending {
+			c = -c
+		}
+		return c
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return compare(linkCursor(q, summaries[i]), linkCursor(q, summaries[j])) < 0
+	})
+
+	page := &models.LinkPage{Links: make([]*models.LinkSummary, 0, q.Limit), Total: int64(len(summaries))}
+	for _, summary := range summaries {
+		if q.After != nil && compare(linkCursor(q, summary), q.After) <= 0 {
+			continue
+		}
+		if len(page.Links) == q.Limit {
+			page.Next = linkCursor(q, page.Links[q.Limit-1])
+			break
+		}
+		page.Links = append(page.Links, summary)
+	}
+	return page, nil
+}
+
+// SearchLinks ranks the user's links with the same matching as SQLiteDB.SearchLinks
+func (m *MemoryStore) SearchLinks(ctx context.Context, q models.LinkSearchQuery) ([]*models.LinkSearchResult, error) {
+	results := []*models.LinkSearchResult{}
+	if len(q.Terms) == 0 {
+		return results, nil
+	}
+
+	links := m.filterLinks(func(link *models.Link) bool { return link.UserID == q.UserID })
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, link := range links {
+		rank := rankLinkMatch(link, q.Terms)
+		if rank == 0 {
+			continue
+		}
+		result := &models.LinkSearchResult{LinkSummary: models.LinkSummary{Link: *link}, Rank: rank}
+		if stats, ok := m.stats[link.ShortCode]; ok {
+			result.TotalClicks = stats.TotalClicks
+		}
+		results = append(results, result)
+	}
+	return sortSearchResults(results, q.Limit), nil
+}
+
+// UpdateLinkMetadata changes the title, description, tags or folder of one of the user's links
+func (m *MemoryStore) UpdateLinkMetadata(ctx context.Context, userID, shortCode string, update models.LinkMetadataUpdate) (*models.Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.links[shortCode]
+	if !ok || link.UserID != userID {
+		return nil, &models.NotFoundError{Message: "link not found"}
+	}
+	if update.FolderID != nil && *update.FolderID != 0 {
+		if folder, ok := m.folders[*update.FolderID]; !ok || folder.UserID != userID {
+			return nil, &models.ValidationError{Message: fmt.Sprintf("folder %d not found", *update.FolderID)}
+		}
+	}
+
+	if update.Title != nil {
+		link.Title = *update.Title
+	}
+	if update.Description != nil {
+		link.Description = *update.Description
+	}
+	if update.Tags != nil {
+		link.Tags = slices.Clone(*update.Tags)
+	}
+	if update.FolderID != nil {
+		link.FolderID = nil
+		if id := *update.FolderID; id != 0 {
+			link.FolderID = &id
+		}
+	}
+	found := *link
+	found.Tags = slices.Clone(link.Tags)
+	return &found, nil
+}
+
+func (m *MemoryStore) GetUserSettings(ctx context.Context, userID string) (*models.UserSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	settings, ok := m.settings[userID]
+	if !ok {
+		settings = models.UserSettings{UserID: userID}
+	}
+	return &settings, nil
+}
+
+func (m *MemoryStore) UpdateUserSettings(ctx context.Context, settings *models.UserSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.settings[settings.UserID] = *settings
+	return nil
+}
+
+// GetTags lists the tags on the user's links with how many links carry each
+func (m *MemoryStore) GetTags(ctx context.Context, userID string) ([]models.TagCount, error) {
+	counts := make(map[string]int64)
+	for _, link := range m.filterLinks(func(link *models.Link) bool { return link.UserID == userID }) {
+		for _, tag := range link.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]models.TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, models.TagCount{Tag: tag, Links: n})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Links != tags[j].Links {
+			return tags[i].Links > tags[j].Links
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+func (m *MemoryStore) CreateFolder(ctx context.Context, folder *models.Folder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkFolderPlacement(folder); err != nil {
+		return err
+	}
+	m.nextFolderID++
+	folder.ID = m.nextFolderID
+	folder.CreatedAt = time.Now()
+	stored := *folder
+	m.folders[folder.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) GetFolder(ctx context.Context, userID string, id int64) (*models.Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folder, ok := m.folders[id]
+	if !ok || folder.UserID != userID {
+		return nil, &models.NotFoundError{Message: "folder not found"}
+	}
+	found := *folder
+	return &found, nil
+}
+
+func (m *MemoryStore) GetFolders(ctx context.Context, userID string) ([]*models.Folder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	folders := []*models.Folder{}
+	for _, folder := range m.folders {
+		if folder.UserID == userID {
+			found := *folder
+			folders = append(folders, &found)
+		}
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		if folders[i].Name != folders[j].Name {
+			return folders[i].Name < folders[j].Name
+		}
+		return folders[i].ID < folders[j].ID
+	})
+	return folders, nil
+}
+
+func (m *MemoryStore) UpdateFolder(ctx context.Context, folder *models.Folder) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.folders[folder.ID]
+	if !ok || stored.UserID != folder.UserID {
+		return &models.NotFoundError{Message: "folder not found"}
+	}
+	if folder.ParentID != nil && m.folderSubtree(folder.ID)[*folder.ParentID] {
+		return &models.ValidationError{Message: "a folder cannot be moved into itself or one of its subfolders"}
+	}
+	if err := m.checkFolderPlacement(folder); err != nil {
+		return err
+	}
+	stored.Name = folder.Name
+	stored.ParentID = folder.ParentID
+	return nil
+}
+
+// DeleteFolder deletes the folder and its subfolders; their links stay, without a folder
+func (m *MemoryStore) DeleteFolder(ctx context.Context, userID string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if folder, ok := m.folders[id]; !ok || folder.UserID != userID {
+		return &models.NotFoundError{Message: "folder not found"}
+	}
+	subtree := m.folderSubtree(id)
+	for _, link := range m.links {
+		if link.FolderID != nil && subtree[*link.FolderID] {
+			link.FolderID = nil
+		}
+	}
+	for folderID := range subtree {
+		delete(m.folders, folderID)
+	}
+	return nil
+}
+
+// GetGroupLinkCodes returns the short codes of the user's links with a tag, or in a
+// folder or any of its subfolders
+func (m *MemoryStore) GetGroupLinkCodes(ctx context.Context, group models.LinkGroup) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subtree map[int64]bool
+	if group.Tag == "" {
+		if folder, ok := m.folders[group.FolderID]; !ok || folder.UserID != group.UserID {
+			return nil, &models.NotFoundError{Message: "folder not found"}
+		}
+		subtree = m.folderSubtree(group.FolderID)
+	}
+
+	codes := []string{}
+	for _, link := range m.links {
+		if link.UserID != group.UserID {
+			continue
+		}
+		if group.Tag != "" && slices.Contains(link.Tags, group.Tag) ||
+			group.Tag == "" && link.FolderID != nil && subtree[*link.FolderID] {
+			codes = append(codes, link.ShortCode)
+		}
+	}
+	return codes, nil
+}
+
+// folderSubtree returns the ids of folder id and every folder below it; m.mu must be held
+func (m *MemoryStore) folderSubtree(id int64) map[int64]bool {
+	subtree := map[int64]bool{id: true}
+	for grew := true; grew; {
+		grew = false
+		for _, folder := range m.folders {
+			if folder.ParentID != nil && subtree[*folder.ParentID] && !subtree[folder.ID] {
+				subtree[folder.ID] = true
+				grew = true
+			}
+		}
+	}
+	return subtree
+}
+
+// checkFolderPlacement is the MemoryStore version of the SQL stores' check; m.mu must be held
+func (m *MemoryStore) checkFolderPlacement(folder *models.Folder) error {
+	if folder.ParentID != nil {
+		if parent, ok := m.folders[*folder.ParentID]; !ok || parent.UserID != folder.UserID {
+			return &models.ValidationError{Message: fmt.Sprintf("folder %d not found", *folder.ParentID)}
+		}
+	}
+	for _, sibling := range m.folders {
+		if sibling.ID != folder.ID && sibling.UserID == folder.UserID && sibling.Name == folder.Name &&
+			ptrEqual(sibling.ParentID, folder.ParentID) {
+			return &models.ValidationError{Message: fmt.Sprintf("a folder named %q already exists there", folder.Name)}
+		}
+	}
+	return nil
+}
+
+func ptrEqual(a, b *int64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func (m *MemoryStore) GetAllLinks(ctx context.Context) ([]*models.Link, error) {
+	return m.filterLinks(func(*models.Link) bool { return true }), nil
+}
+
+// filterLinks returns copies of matching links, newest first
+func (m *MemoryStore) filterLinks(match func(*models.Link) bool) []*models.Link {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var links []*models.Link
+	for _, link := range m.links {
+		if match(link) {
+			found := *link
+			links = append(links, &found)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links
+}
+
+func (m *MemoryStore) InsertClickEvent(ctx context.Context, event *models.ClickEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clicks = append(m.clicks, *event)
+	return nil
+}
+
+func (m *MemoryStore) BatchInsertClickEvents(ctx context.Context, events []*models.ClickEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, event := range events {
+		m.clicks = append(m.clicks, *event)
+	}
+	return nil
+}
+
+// GetClicksOverTime buckets clicks by hour for periods up to a day, by whole day otherwise
+func (m *MemoryStore) GetClicksOverTime(ctx context.Context, shortCode string, period time.Duration) ([]models.TimePoint, error) {
+	startTime := time.Now().Add(-period)
+	bucket := 24 * time.Hour
+	if period <= 24*time.Hour {
+		bucket = time.Hour
+	} else {
+		startTime = firstWholeDay(startTime)
+	}
+
+	m.mu.RLock()
+	counts := make(map[time.Time]int64)
+	for _, click := range m.clicks {
+		if click.ShortCode == shortCode && !click.Timestamp.Before(startTime) {
+			counts[click.Timestamp.UTC().Truncate(bucket)]++
+		}
+	}
+	m.mu.RUnlock()
+
+	points := make([]models.TimePoint, 0, len(counts))
+	for ts, count := range counts {
+		points = append(points, models.TimePoint{Timestamp: ts, Count: count})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+	return points, nil
+}
+
+func (m *MemoryStore) GetClicksBetween(ctx context.Context, shortCode string, from, to time.Time) ([]models.TimePoint, error) {
+	bucket := 24 * time.Hour
+	if to.Sub(from) <= 24*time.Hour {
+		bucket = time.Hour
+	} else {
+		from, to = dailyRange(from, to)
+	}
+
+	m.mu.RLock()
+	counts := make(map[time.Time]int64)
+	for _, click := range m.clicks {
+		if click.ShortCode == shortCode && !click.Timestamp.Before(from) && click.Timestamp.Before(to) {
+			counts[click.Timestamp.UTC().Truncate(bucket)]++
+		}
+	}
+	m.mu.RUnlock()
+
+	points := make([]models.TimePoint, 0, len(counts))
+	for ts, count := range counts {
+		points = append(points, models.TimePoint{Timestamp: ts, Count: count})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+	return points, nil
+}
+
+// ScanLinkClicks numbers clicks by their position in the store, which has no ids
+func (m *MemoryStore) ScanLinkClicks(ctx context.Context, shortCode string, from, to time.Time, fn func(*models.ClickRecord) error) error {
+	m.mu.RLock()
+	var records []*models.ClickRecord
+	for i, click := range m.clicks {
+		if click.ShortCode == shortCode && !click.Timestamp.Before(from) && click.Timestamp.Before(to) {
+			records = append(records, &models.ClickRecord{
+				ID:          int64(i + 1),
+				ShortCode:   click.ShortCode,
+				ClickedAt:   click.Timestamp,
+				IPAddress:   click.IPAddress,
+				UserAgent:   click.UserAgent,
+				Referer:     click.Referer,
+				VisitorHash: click.VisitorHash,
+				RequestID:   click.RequestID,
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ClickedAt.Before(records[j].ClickedAt) })
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetUniqueVisitors(ctx context.Context, shortCode string, startTime time.Time) (int64, error) {
+	return m.countVisitors(shortCode, startTime), nil
+}
+
+func (m *MemoryStore) RecalculateUniqueVisitors(ctx context.Context, shortCode string) (int64, error) {
+	return m.countVisitors(shortCode, time.Time{}), nil
+}
+
+func (m *MemoryStore) countVisitors(shortCode string, startTime time.Time) int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	visitors := make(map[string]bool)
+	for _, click := range m.clicks {
+		if click.ShortCode == shortCode && !click.Timestamp.Before(startTime) {
+			visitors[click.VisitorHash] = true
+		}
+	}
+	return int64(len(visitors))
+}
+
+func (m *MemoryStore) GetLinkStats(ctx context.Context, shortCode string) (*models.LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if stats, ok := m.stats[shortCode]; ok {
+		found := *stats
+		return &found, nil
+	}
+	return &models.LinkStats{ShortCode: shortCode}, nil
+}
+
+// GetGroupStats sums the links' totals and counts distinct visitors across them
+func (m *MemoryStore) GetGroupStats(ctx context.Context, shortCodes []string) (*models.LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := &models.LinkStats{}
+	for _, code := range shortCodes {
+		if s, ok := m.stats[code]; ok {
+			stats.TotalClicks += s.TotalClicks
+		}
+	}
+	visitors := make(map[string]bool)
+	for _, click := range m.clicks {
+		if slices.Contains(shortCodes, click.ShortCode) {
+			visitors[click.VisitorHash] = true
+		}
+	}
+	stats.UniqueVisitors = int64(len(visitors))
+	return stats, nil
+}
+
+func (m *MemoryStore) GetGroupClicksOverTime(ctx context.Context, shortCodes []string, period time.Duration) ([]models.TimePoint, error) {
+	counts := make(map[time.Time]int64)
+	for _, code := range shortCodes {
+		points, err := m.GetClicksOverTime(ctx, code, period)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range points {
+			counts[point.Timestamp] += point.Count
+		}
+	}
+
+	points := make([]models.TimePoint, 0, len(counts))
+	for ts, count := range counts {
+		points = append(points, models.TimePoint{Timestamp: ts, Count: count})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+	return points, nil
+}
+
+func (m *MemoryStore) CountGroupClicks(ctx context.Context, shortCodes []string, from, to time.Time) (*models.LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := &models.LinkStats{}
+	visitors := make(map[string]bool)
+	for _, click := range m.clicks {
+		if slices.Contains(shortCodes, click.ShortCode) && !click.Timestamp.Before(from) && click.Timestamp.Before(to) {
+			stats.TotalClicks++
+			visitors[click.VisitorHash] = true
+		}
+	}
+	stats.UniqueVisitors = int64(len(visitors))
+	return stats, nil
+}
+
+func (m *MemoryStore) GetTopLinks(ctx context.Context, shortCodes []string, from, to time.Time, limit int) ([]models.LinkStats, error) {
+	m.mu.RLock()
+	byCode := make(map[string]*models.LinkStats)
+	visitors := make(map[string]map[string]bool)
+	for _, click := range m.clicks {
+		if !slices.Contains(shortCodes, click.ShortCode) || click.Timestamp.Before(from) || !click.Timestamp.Before(to) {
+			continue
+		}
+		stats, ok := byCode[click.ShortCode]
+		if !ok {
+			stats = &models.LinkStats{ShortCode: click.ShortCode}
+			byCode[click.ShortCode] = stats
+			visitors[click.ShortCode] = make(map[string]bool)
+		}
+		stats.TotalClicks++
+		visitors[click.ShortCode][click.VisitorHash] = true
+	}
+	m.mu.RUnlock()
+
+	links := make([]models.LinkStats, 0, len(byCode))
+	for code, stats := range byCode {
+		stats.UniqueVisitors = int64(len(visitors[code]))
+		links = append(links, *stats)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].TotalClicks != links[j].TotalClicks {
+			return links[i].TotalClicks > links[j].TotalClicks
+		}
+		return links[i].ShortCode < links[j].ShortCode
+	})
+	if len(links) > limit {
+		links = links[:limit]
+	}
+	return links, nil
+}
+
+func (m *MemoryStore) GetTopReferrersBetween(ctx context.Context, shortCodes []string, from, to time.Time, limit int) ([]models.Referrer, error) {
+	m.mu.RLock()
+	counts := make(map[string]int64)
+	for _, click := range m.clicks {
+		if click.Referer != "" && slices.Contains(shortCodes, click.ShortCode) && !click.Timestamp.Before(from) && click.Timestamp.Before(to) {
+			counts[click.Referer]++
+		}
+	}
+	m.mu.RUnlock()
+	return sortReferrers(counts, limit), nil
+}
+
+func (m *MemoryStore) GetGroupTopReferrers(ctx context.Context, shortCodes []string, limit int) ([]models.Referrer, error) {
+	m.mu.RLock()
+	counts := make(map[string]int64)
+	for _, code := range shortCodes {
+		for referer, count := range m.referrers[code] {
+			counts[referer] += count
+		}
+	}
+	m.mu.RUnlock()
+	return sortReferrers(counts, limit), nil
+}
+
+// sortReferrers returns up to limit of the referrers in counts, most clicks first
+func sortReferrers(counts map[string]int64, limit int) []models.Referrer {
+	referrers := make([]models.Referrer, 0, len(counts))
+	for referer, count := range counts {
+		referrers = append(referrers, models.Referrer{Referer: referer, ClickCount: count})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].ClickCount != referrers[j].ClickCount {
+			return referrers[i].ClickCount > referrers[j].ClickCount
+		}
+		return referrers[i].Referer < referrers[j].Referer
+	})
+	if len(referrers) > limit {
+		referrers = referrers[:limit]
+	}
+	return referrers
+}
+
+func (m *MemoryStore) UpdateLinkStats(ctx context.Context, shortCode string, totalClicks int64, uniqueVisitors int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.stats[shortCode]
+	if !ok {
+		stats = &models.LinkStats{ShortCode: shortCode}
+		m.stats[shortCode] = stats
+	}
+	stats.TotalClicks += totalClicks
+	stats.UniqueVisitors = uniqueVisitors
+	return nil
+}
+
+func (m *MemoryStore) GetTopReferrers(ctx context.Context, shortCode string, limit int) ([]models.Referrer, error) {
+	m.mu.RLock()
+	referrers := make([]models.Referrer, 0, len(m.referrers[shortCode]))
+	for referer, count := range m.referrers[shortCode] {
+		referrers = append(referrers, models.Referrer{Referer: referer, ClickCount: count})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].ClickCount != referrers[j].ClickCount {
+			return referrers[i].ClickCount > referrers[j].ClickCount
+		}
+		return referrers[i].Referer < referrers[j].Referer
+	})
+	if len(referrers) > limit {
+		referrers = referrers[:limit]
+	}
+	return referrers, nil
+}
+
+func (m *MemoryStore) UpdateTopReferrers(ctx context.Context, shortCode string, referer string, count int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.referrers[shortCode] == nil {
+		m.referrers[shortCode] = make(map[string]int64)
+	}
+	m.referrers[shortCode][referer] += count
+	return nil
+}
+
+// MemoryCache is an in-process Cache. Pub/sub only reaches subscribers in the
+// same process, so it stands in for Redis on a single instance.
+type MemoryCache struct {
+	mu          sync.Mutex
+	entries     map[string]cacheEntry
+	writes      int
+	subscribers map[string][]chan []byte
+}
+
+type cacheEntry struct {
+	value     string
+	expiresAt time.Time // zero means no expiry
+}
+
+// Expired entries are removed when read and in a sweep every this many writes
+const memoryCacheSweepEvery = 1024
+
+// Messages queued per subscriber before Publish starts dropping them
+const memoryCacheSubscriberBuffer = 1024
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:     make(map[string]cacheEntry),
+		subscribers: make(map[string][]chan []byte),
+	}
+}
+
+func (c *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// lookup returns a live entry; callers hold c.mu
+func (c *MemoryCache) lookup(key string, now time.Time) (cacheEntry, bool) {
+	entry, ok := c.entries[key]
+	if ok && !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return cacheEntry{}, false
+	}
+	return entry, ok
+}
+
+// store writes an entry and occasionally sweeps expired ones; callers hold c.mu
+func (c *MemoryCache) store(key string, entry cacheEntry, now time.Time) {
+	c.entries[key] = entry
+	c.writes++
+	if c.writes%memoryCacheSweepEvery == 0 {
+		for k, e := range c.entries {
+			if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+	}
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key, time.Now())
+	if !ok {
+		return "", fmt.Errorf("key not found")
+	}
+	return entry.value, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry := cacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	c.store(key, entry, now)
+	return nil
+}
+
+func (c *MemoryCache) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.lookup(key, now); ok {
+		return false, nil
+	}
+	entry := cacheEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	c.store(key, entry, now)
+	return true, nil
+}
+
+func (c *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	return c.IncrWithTTL(ctx, key, 60*time.Second)
+}
+
+func (c *MemoryCache) IncrWithTTL(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return c.IncrByWithTTL(ctx, key, 1, ttl)
+}
+
+func (c *MemoryCache) IncrByWithTTL(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := c.lookup(key, now)
+	var val int64
+	if ok {
+		n, err := strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to increment key: %w", err)
+		}
+		val = n
+	} else if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+	val += n
+	entry.value = strconv.FormatInt(val, 10)
+	c.store(key, entry, now)
+	return val, nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *MemoryCache) GetInt(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.lookup(key, time.Now())
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get int: %w", err)
+	}
+	return n, nil
+}
+
+// Publish hands the message to every subscriber of channel without blocking;
+// a subscriber that has fallen behind misses the message
+func (c *MemoryCache) Publish(ctx context.Context, channel string, message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ch := range c.subscribers[channel] {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCache) Subscribe(ctx context.Context, channel string, handler func([]byte)) error {
+	ch := make(chan []byte, memoryCacheSubscriberBuffer)
+
+	c.mu.Lock()
+	c.subscribers[channel] = append(c.subscribers[channel], ch)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.unsubscribe(channel, ch)
+		for {
+			select {
+			case msg := <-ch:
+				handler(msg)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *MemoryCache) unsubscribe(channel string, ch chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := c.subscribers[channel]
+	for i, sub := range subs {
+		if sub == ch {
+			c.subscribers[channel] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(c.subscribers[channel]) == 0 {
+		delete(c.subscribers, channel)
+	}
+}
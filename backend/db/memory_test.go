@@ -0,0 +1,281 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"link-analytics-service/models"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreLinks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	link := &models.Link{ShortCode: "abc123", OriginalURL: "https://example.com/a", UserID: "user1", Tags: []string{"spring"}}
+	if err := store.CreateLink(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+	if link.ID == 0 || link.CreatedAt.IsZero() {
+		t.Errorf("created link has no id or creation time: %+v", link)
+	}
+	if err := store.CreateLink(ctx, &models.Link{ShortCode: "abc123", OriginalURL: "https://example.com/b"}); err == nil {
+		t.Error("duplicate short code accepted")
+	}
+
+	// The store keeps its own copy
+	link.Tags[0] = "changed"
+	got, err := store.GetLinkByCode(ctx, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OriginalURL != "https://example.com/a" || got.Tags[0] != "spring" {
+		t.Errorf("stored link = %+v", got)
+	}
+
+	var notFound *models.NotFoundError
+	if _, err := store.GetLinkByCode(ctx, "nosuch"); !errors.As(err, &notFound) {
+		t.Errorf("unknown code: error %v, want NotFoundError", err)
+	}
+	if _, err := store.FindLinkByURL(ctx, "user2", "https://example.com/a"); !errors.As(err, &notFound) {
+		t.Errorf("another user's URL: error %v, want NotFoundError", err)
+	}
+
+	store.CreateLink(ctx, &models.Link{ShortCode: "def456", OriginalURL: "https://example.com/a", UserID: "user1"})
+	if found, err := store.FindLinkByURL(ctx, "user1", "https://example.com/a"); err != nil || found.ShortCode != "abc123" {
+		t.Errorf("FindLinkByURL = %+v, %v, want the oldest link", found, err)
+	}
+	if links, _ := store.GetLinksByUser(ctx, "user1"); len(links) != 2 {
+		t.Errorf("user1 has %d links, want 2", len(links))
+	}
+}
+
+func TestMemoryStoreCreateLinks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	store.CreateLink(ctx, &models.Link{ShortCode: "taken", OriginalURL: "https://example.com/"})
+
+	var n int
+	newCode := func() string {
+		n++
+		return fmt.Sprintf("gen%d", n)
+	}
+	links := []*models.Link{
+		{ShortCode: "taken", OriginalURL: "https://example.com/1"},
+		{OriginalURL: "https://example.com/2"},
+	}
+	if err := store.CreateLinks(ctx, links, newCode); err != nil {
+		t.Fatal(err)
+	}
+	if links[0].ShortCode == "taken" || links[1].ShortCode == "" || links[0].ShortCode == links[1].ShortCode {
+		t.Errorf("short codes %q and %q", links[0].ShortCode, links[1].ShortCode)
+	}
+
+	// A batch that can't get free codes creates nothing
+	before, _ := store.GetAllLinks(ctx)
+	err := store.CreateLinks(ctx, []*models.Link{{OriginalURL: "https://example.com/3"}}, func() string { return "taken" })
+	if err == nil {
+		t.Fatal("expected an error when no short code is free")
+	}
+	if after, _ := store.GetAllLinks(ctx); len(after) != len(before) {
+		t.Errorf("%d links after a failed batch, want %d", len(after), len(before))
+	}
+}
+
+func TestMemoryStoreClicks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	from := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	click := func(code, visitor string, at time.Time, referer string) *models.ClickEvent {
+		return &models.ClickEvent{ShortCode: code, VisitorHash: visitor, Timestamp: at, Referer: referer}
+	}
+	err := store.BatchInsertClickEvents(ctx, []*models.ClickEvent{
+		click("a", "v1", from.Add(10*time.Minute), "https://twitter.com"),
+		click("a", "v1", from.Add(20*time.Minute), ""),
+		click("a", "v2", from.Add(90*time.Minute), "https://news.ycombinator.com"),
+		click("b", "v2", from.Add(30*time.Minute), "https://twitter.com"),
+		click("b", "v3", from.Add(3*time.Hour), "https://news.ycombinator.com"), // after the range
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := from.Add(2 * time.Hour)
+
+	points, _ := store.GetClicksBetween(ctx, "a", from, to)
+	if fmt.Sprint(points) != fmt.Sprint([]models.TimePoint{{Timestamp: from, Count: 2}, {Timestamp: from.Add(time.Hour), Count: 1}}) {
+		t.Errorf("hourly clicks = %v", points)
+	}
+
+	// A visitor of both links counts once
+	stats, _ := store.CountGroupClicks(ctx, []string{"a", "b"}, from, to)
+	if stats.TotalClicks != 4 || stats.UniqueVisitors != 2 {
+		t.Errorf("group clicks = %+v, want 4 clicks by 2 visitors", stats)
+	}
+
+	top, _ := store.GetTopLinks(ctx, []string{"a", "b"}, from, to, 1)
+	if len(top) != 1 || top[0].ShortCode != "a" || top[0].TotalClicks != 3 || top[0].UniqueVisitors != 2 {
+		t.Errorf("top links = %+v", top)
+	}
+
+	refs, _ := store.GetTopReferrersBetween(ctx, []string{"a", "b"}, from, to, 10)
+	if fmt.Sprint(refs) != fmt.Sprint([]models.Referrer{{Referer: "https://twitter.com", ClickCount: 2}, {Referer: "https://news.ycombinator.com", ClickCount: 1}}) {
+		t.Errorf("top referrers between = %+v", refs)
+	}
+
+	var visited []string
+	store.ScanLinkClicks(ctx, "b", from, to.Add(2*time.Hour), func(rec *models.ClickRecord) error {
+		visited = append(visited, rec.VisitorHash)
+		return nil
+	})
+	if fmt.Sprint(visited) != "[v2 v3]" {
+		t.Errorf("scanned visitors %v", visited)
+	}
+}
+
+func TestMemoryStoreDailySeries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	from := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	periodStart := time.Now().Add(-7 * 24 * time.Hour)
+	firstDay := periodStart.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	var events []*models.ClickEvent
+	for _, at := range []time.Time{
+		from.Add(2 * time.Hour), // the partial first day
+		time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 7, 23, 0, 0, 0, time.UTC),
+		to.Add(-time.Hour),         // the partial last day
+		firstDay.Add(-time.Minute), // in the period, before its first whole day
+		firstDay.Add(time.Minute),
+		time.Now(),
+	} {
+		events = append(events, &models.ClickEvent{ShortCode: "a", Timestamp: at})
+	}
+	store.BatchInsertClickEvents(ctx, events)
+
+	// Only whole days are bucketed
+	points, _ := store.GetClicksBetween(ctx, "a", from, to)
+	want := []models.TimePoint{
+		{Timestamp: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Count: 1},
+		{Timestamp: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC), Count: 1},
+	}
+	if fmt.Sprint(points) != fmt.Sprint(want) {
+		t.Errorf("daily clicks between = %v, want %v", points, want)
+	}
+
+	// The series up to now starts at the period's first midnight and ends with today
+	points, _ = store.GetClicksOverTime(ctx, "a", 7*24*time.Hour)
+	if len(points) == 0 || !points[0].Timestamp.Equal(firstDay) || len(points) > 7 {
+		t.Fatalf("daily series = %v, want at most 7 days from %v", points, firstDay)
+	}
+	var total int64
+	for _, point := range points {
+		total += point.Count
+	}
+	if total != 2 {
+		t.Errorf("daily series counts %d clicks, want 2", total)
+	}
+}
+
+func TestMemoryStoreStats(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	if stats, err := store.GetLinkStats(ctx, "a"); err != nil || stats.TotalClicks != 0 || stats.ShortCode != "a" {
+		t.Errorf("stats without clicks = %+v, %v, want zero stats", stats, err)
+	}
+
+	// Totals accumulate, the visitor count is replaced
+	store.UpdateLinkStats(ctx, "a", 3, 2)
+	store.UpdateLinkStats(ctx, "a", 4, 5)
+	if stats, _ := store.GetLinkStats(ctx, "a"); stats.TotalClicks != 7 || stats.UniqueVisitors != 5 {
+		t.Errorf("stats = %+v, want 7 clicks and 5 visitors", stats)
+	}
+
+	store.UpdateTopReferrers(ctx, "a", "https://twitter.com", 2)
+	store.UpdateTopReferrers(ctx, "a", "https://news.ycombinator.com", 3)
+	store.UpdateTopReferrers(ctx, "b", "https://twitter.com", 2)
+	if refs, _ := store.GetTopReferrers(ctx, "a", 1); len(refs) != 1 || refs[0].Referer != "https://news.ycombinator.com" {
+		t.Errorf("top referrers = %+v", refs)
+	}
+	if refs, _ := store.GetGroupTopReferrers(ctx, []string{"a", "b"}, 10); len(refs) != 2 || refs[0].Referer != "https://twitter.com" || refs[0].ClickCount != 4 {
+		t.Errorf("group top referrers = %+v", refs)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+
+	if _, err := cache.Get(ctx, "missing"); err == nil {
+		t.Error("Get of a missing key succeeded")
+	}
+	if n, err := cache.GetInt(ctx, "missing"); err != nil || n != 0 {
+		t.Errorf("GetInt of a missing key = %d, %v, want 0", n, err)
+	}
+
+	cache.Set(ctx, "short", "v", 20*time.Millisecond)
+	if v, err := cache.Get(ctx, "short"); err != nil || v != "v" {
+		t.Errorf("Get = %q, %v", v, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get(ctx, "short"); err == nil {
+		t.Error("key outlived its ttl")
+	}
+
+	if ok, _ := cache.SetNX(ctx, "once", "first", time.Minute); !ok {
+		t.Error("SetNX of a new key failed")
+	}
+	if ok, _ := cache.SetNX(ctx, "once", "second", time.Minute); ok {
+		t.Error("SetNX overwrote an existing key")
+	}
+	cache.Delete(ctx, "once")
+	if ok, _ := cache.SetNX(ctx, "once", "third", time.Minute); !ok {
+		t.Error("SetNX after Delete failed")
+	}
+
+	cache.IncrByWithTTL(ctx, "counter", 5, time.Minute)
+	if n, _ := cache.IncrByWithTTL(ctx, "counter", -2, time.Minute); n != 3 {
+		t.Errorf("counter = %d, want 3", n)
+	}
+	if n, _ := cache.Incr(ctx, "counter"); n != 4 {
+		t.Errorf("counter = %d, want 4", n)
+	}
+}
+
+func TestMemoryCachePubSub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := NewMemoryCache()
+
+	received := make(chan string, 1)
+	if err := cache.Subscribe(ctx, "events", func(msg []byte) { received <- string(msg) }); err != nil {
+		t.Fatal(err)
+	}
+	cache.Publish(ctx, "other", []byte("ignored"))
+	cache.Publish(ctx, "events", []byte("hello"))
+	select {
+	case msg := <-received:
+		if msg != "hello" {
+			t.Errorf("received %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// Cancelling the context ends the subscription
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		cache.mu.Lock()
+		subs := len(cache.subscribers["events"])
+		cache.mu.Unlock()
+		if subs == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription outlived its context")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
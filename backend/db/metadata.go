@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"strings"
+	"time"
+)
+
+// Link metadata and folders, shared by PostgresDB and SQLiteDB. Folders are
+// per user; a folder's parent must belong to the same user.
+
+// folderSubtree is a CTE of folder $2 of user $1 and every folder below it.
+// UNION rather than UNION ALL stops at a cycle, though updateFolder never creates one.
+const folderSubtree = `WITH RECURSIVE subtree(id) AS (
+	SELECT id FROM folders WHERE id = $2 AND user_id = $1
+	UNION
+	SELECT f.id FROM folders f JOIN subtree ON f.parent_id = subtree.id
+)`
+
+func updateLinkMetadata(ctx context.Context, db *sql.DB, dialect linkDialect, userID, shortCode string, update models.LinkMetadataUpdate) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM links WHERE short_code = $1 AND user_id = $2`, shortCode, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return &models.NotFoundError{Message: "link not found"}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get link: %w", err)
+	}
+
+	var (
+		set  []string
+		args []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if update.Title != nil {
+		set = append(set, "title = "+arg(nullString(*update.Title)))
+	}
+	if update.Description != nil {
+		set = append(set, "description = "+arg(nullString(*update.Description)))
+	}
+	if update.Tags != nil {
+		set = append(set, "tags = "+arg(dialect.bindStrings(*update.Tags)))
+	}
+	if update.FolderID != nil {
+		var folderID interface{}
+		if *update.FolderID != 0 {
+			if err := checkFolderOwner(ctx, tx, userID, *update.FolderID); err != nil {
+				return err
+			}
+			folderID = *update.FolderID
+		}
+		set = append(set, "folder_id = "+arg(folderID))
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	query := `UPDATE links SET ` + strings.Join(set, ", ") + ` WHERE id = ` + arg(id)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update link: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func getTags(ctx context.Context, db *sql.DB, dialect linkDialect, userID string) ([]models.TagCount, error) {
+	rows, err := db.QueryContext(ctx, dialect.tagCounts, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []models.TagCount{}
+	for rows.Next() {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Links); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return tags, nil
+}
+
+func createFolder(ctx context.Context, db *sql.DB, dialect linkDialect, folder *models.Folder) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkFolderPlacement(ctx, tx, folder); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO folders (user_id, name, parent_id, created_at)
+	          VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, query, folder.UserID, folder.Name, nullInt64Arg(folder.ParentID),
+		dialect.bindTime(time.Now())).Scan(&folder.ID, &folder.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func getFolder(ctx context.Context, db *sql.DB, userID string, id int64) (*models.Folder, error) {
+	folders, err := queryFolders(ctx, db, `SELECT id, user_id, name, parent_id, created_at
+	                                       FROM folders WHERE user_id = $1 AND id = $2`, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(folders) == 0 {
+		return nil, &models.NotFoundError{Message: "folder not found"}
+	}
+	return folders[0], nil
+}
+
+func getFolders(ctx context.Context, db *sql.DB, userID string) ([]*models.Folder, error) {
+	return queryFolders(ctx, db, `SELECT id, user_id, name, parent_id, created_at
+	                              FROM folders WHERE user_id = $1 ORDER BY name, id`, userID)
+}
+
+func queryFolders(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]*models.Folder, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer rows.Close()
+
+	folders := []*models.Folder{}
+	for rows.Next() {
+		folder := &models.Folder{}
+		var parentID sql.NullInt64
+		if err := rows.Scan(&folder.ID, &folder.UserID, &folder.Name, &parentID, &folder.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folder.ParentID = nullInt64Ptr(parentID)
+		folders = append(folders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return folders, nil
+}
+
+// updateFolder renames or moves a folder. Moves of the same user's folders are
+// serialized, since two moves that are each fine alone (A into B, B into A) could
+// otherwise both pass the check and leave a cycle.
+func updateFolder(ctx context.Context, db *sql.DB, dialect linkDialect, folder *models.Folder) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if folder.ParentID != nil {
+		if dialect.lockFolders != "" {
+			if _, err := tx.ExecContext(ctx, dialect.lockFolders, folder.UserID); err != nil {
+				return fmt.Errorf("failed to lock folders: %w", err)
+			}
+		}
+
+		// Moving a folder below itself would detach the subtree from the root
+		var cycle bool
+		err := tx.QueryRowContext(ctx, folderSubtree+` SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $3)`,
+			folder.UserID, folder.ID, *folder.ParentID).Scan(&cycle)
+		if err != nil {
+			return fmt.Errorf("failed to check folder parent: %w", err)
+		}
+		if cycle {
+			return &models.ValidationError{Message: "a folder cannot be moved into itself or one of its subfolders"}
+		}
+	}
+	if err := checkFolderPlacement(ctx, tx, folder); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE folders SET name = $1, parent_id = $2 WHERE id = $3 AND user_id = $4`,
+		folder.Name, nullInt64Arg(folder.ParentID), folder.ID, folder.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update folder: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &models.NotFoundError{Message: "folder not found"}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteFolder removes the folder and its subfolders. Their links are kept and
+// taken out of the folders here rather than by foreign keys, which SQLite does not enforce.
+func deleteFolder(ctx context.Context, db *sql.DB, userID string, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, folderSubtree+` UPDATE links SET folder_id = NULL WHERE folder_id IN (SELECT id FROM subtree)`,
+		userID, id); err != nil {
+		return fmt.Errorf("failed to clear folder from links: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, folderSubtree+` DELETE FROM folders WHERE id IN (SELECT id FROM subtree)`, userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &models.NotFoundError{Message: "folder not found"}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// groupLinkCodes returns the short codes of the user's links with a tag, or in a
+// folder or any of its subfolders
+func groupLinkCodes(ctx context.Context, db *sql.DB, dialect linkDialect, group models.LinkGroup) ([]string, error) {
+	var (
+		query string
+		args  = []interface{}{group.UserID}
+	)
+	if group.Tag != "" {
+		query = `SELECT l.short_code FROM links l WHERE l.user_id = $1 AND ` + dialect.hasTag("$2")
+		args = append(args, group.Tag)
+	} else {
+		if _, err := getFolder(ctx, db, group.UserID, group.FolderID); err != nil {
+			return nil, err
+		}
+		query = folderSubtree + ` SELECT l.short_code FROM links l
+		                          WHERE l.user_id = $1 AND l.folder_id IN (SELECT id FROM subtree)`
+		args = append(args, group.FolderID)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group links: %w", err)
+	}
+	defer rows.Close()
+
+	codes := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("failed to scan short code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return codes, nil
+}
+
+// checkFolderOwner returns a validation error unless folder id belongs to the user
+func checkFolderOwner(ctx context.Context, tx *sql.Tx, userID string, id int64) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM folders WHERE id = $1 AND user_id = $2)`, id, userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check folder: %w", err)
+	}
+	if !exists {
+		return &models.ValidationError{Message: fmt.Sprintf("folder %d not found", id)}
+	}
+	return nil
+}
+
+// checkFolderPlacement checks that the folder's parent belongs to the same user
+// and that no sibling has the same name
+func checkFolderPlacement(ctx context.Context, tx *sql.Tx, folder *models.Folder) error {
+	var parentID int64
+	if folder.ParentID != nil {
+		parentID = *folder.ParentID
+		if err := checkFolderOwner(ctx, tx, folder.UserID, parentID); err != nil {
+			return err
+		}
+	}
+
+	var taken bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM folders
+	                                WHERE user_id = $1 AND COALESCE(parent_id, 0) = $2 AND name = $3 AND id <> $4)`,
+		folder.UserID, parentID, folder.Name, folder.ID).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check folder name: %w", err)
+	}
+	if taken {
+		return &models.ValidationError{Message: fmt.Sprintf("a folder named %q already exists there", folder.Name)}
+	}
+	return nil
+}
+
+// nullString stores empty text as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt64Arg(v *int64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"link-analytics-service/models"
+	"path/filepath"
+	"testing"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteDB {
+	t.Helper()
+	store, err := NewSQLiteDB("sqlite://" + filepath.Join(t.TempDir(), "links.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMemoryStoreFolders(t *testing.T) {
+	testFolders(t, NewMemoryStore())
+}
+
+func TestSQLiteFolders(t *testing.T) {
+	testFolders(t, newTestSQLite(t))
+}
+
+// testFolders checks the folder tree rules every Store enforces
+func testFolders(t *testing.T, store Store) {
+	ctx := context.Background()
+	folder := func(name string, parent *models.Folder) *models.Folder {
+		t.Helper()
+		f := &models.Folder{UserID: "user1", Name: name}
+		if parent != nil {
+			f.ParentID = &parent.ID
+		}
+		if err := store.CreateFolder(ctx, f); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		return f
+	}
+	move := func(f, parent *models.Folder) error {
+		moved := *f
+		moved.ParentID = nil
+		if parent != nil {
+			moved.ParentID = &parent.ID
+		}
+		return store.UpdateFolder(ctx, &moved)
+	}
+	var invalid *models.ValidationError
+	var notFound *models.NotFoundError
+
+	work := folder("Work", nil)
+	clients := folder("Clients", work)
+	acme := folder("Acme", clients)
+	home := folder("Home", nil)
+
+	// Sibling names are unique, names in different places are not
+	for _, f := range []*models.Folder{
+		{UserID: "user1", Name: "Clients", ParentID: &work.ID},
+		{UserID: "user1", Name: "Home"},
+	} {
+		if err := store.CreateFolder(ctx, f); !errors.As(err, &invalid) {
+			t.Errorf("duplicate %s: error %v, want ValidationError", f.Name, err)
+		}
+	}
+	homeClients := folder("Clients", home)
+	if err := store.CreateFolder(ctx, &models.Folder{UserID: "user2", Name: "Work"}); err != nil {
+		t.Errorf("another user's Work: %v", err)
+	}
+	if err := store.UpdateFolder(ctx, &models.Folder{ID: home.ID, UserID: "user1", Name: "Work"}); !errors.As(err, &invalid) {
+		t.Errorf("renaming to a sibling's name: error %v, want ValidationError", err)
+	}
+	if err := move(homeClients, work); !errors.As(err, &invalid) {
+		t.Errorf("moving next to a folder of the same name: error %v, want ValidationError", err)
+	}
+
+	// A folder can't move into itself or below itself
+	for _, parent := range []*models.Folder{work, clients, acme} {
+		if err := move(work, parent); !errors.As(err, &invalid) {
+			t.Errorf("moving Work under %s: error %v, want ValidationError", parent.Name, err)
+		}
+	}
+	if got, _ := store.GetFolder(ctx, "user1", work.ID); got == nil || got.ParentID != nil {
+		t.Errorf("Work after refused moves = %+v", got)
+	}
+	if err := move(acme, home); err != nil {
+		t.Fatalf("moving Acme under Home: %v", err)
+	}
+	if err := move(acme, clients); err != nil {
+		t.Fatalf("moving Acme back: %v", err)
+	}
+	if err := store.UpdateFolder(ctx, &models.Folder{ID: work.ID, UserID: "user2", Name: "Mine"}); !errors.As(err, &notFound) {
+		t.Errorf("updating another user's folder: error %v, want NotFoundError", err)
+	}
+
+	// Deleting a folder deletes its subfolders and keeps their links, outside any folder
+	inFolders := map[string]*models.Folder{"inwork": work, "inacme": acme, "inhome": home}
+	for code, f := range inFolders {
+		link := &models.Link{ShortCode: code, OriginalURL: "https://example.com/" + code, UserID: "user1", FolderID: &f.ID}
+		if err := store.CreateLink(ctx, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteFolder(ctx, "user2", work.ID); !errors.As(err, &notFound) {
+		t.Errorf("deleting another user's folder: error %v, want NotFoundError", err)
+	}
+	if err := store.DeleteFolder(ctx, "user1", work.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*models.Folder{work, clients, acme} {
+		if _, err := store.GetFolder(ctx, "user1", f.ID); !errors.As(err, &notFound) {
+			t.Errorf("%s after deleting Work: error %v, want NotFoundError", f.Name, err)
+		}
+	}
+	if folders, _ := store.GetFolders(ctx, "user1"); len(folders) != 2 {
+		t.Errorf("%d folders left, want Home and its Clients", len(folders))
+	}
+	for code, f := range inFolders {
+		link, err := store.GetLinkByCode(ctx, code)
+		if err != nil {
+			t.Fatalf("link %s deleted with its folder: %v", code, err)
+		}
+		if code == "inhome" && (link.FolderID == nil || *link.FolderID != f.ID) {
+			t.Errorf("link in Home moved to folder %v", link.FolderID)
+		} else if code != "inhome" && link.FolderID != nil {
+			t.Errorf("link %s still in deleted folder %d", code, *link.FolderID)
+		}
+	}
+	if err := store.DeleteFolder(ctx, "user1", work.ID); !errors.As(err, &notFound) {
+		t.Errorf("deleting twice: error %v, want NotFoundError", err)
+	}
+}
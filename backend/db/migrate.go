@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key held while migrating,
+// so replicas starting at the same time apply each migration exactly once
+const migrationLockID int64 = 7265091831
+
+// Migration files are named {version}_{name}.up.sql and {version}_{name}.down.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationDialect is what the migration runner needs to know about a database
+type migrationDialect struct {
+	dir         string // directory of migrationFiles holding this database's scripts
+	createTable string
+	tableExists string // query returning whether schema_migrations exists
+	lock        string // statements holding the migration lock on a connection; empty for none
+	unlock      string
+}
+
+var postgresMigrations = migrationDialect{
+	dir: "migrations",
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`,
+	tableExists: `SELECT to_regclass('schema_migrations') IS NOT NULL`,
+	lock:        fmt.Sprintf(`SELECT pg_advisory_lock(%d)`, migrationLockID),
+	unlock:      fmt.Sprintf(`SELECT pg_advisory_unlock(%d)`, migrationLockID),
+}
+
+// SQLite serializes writers itself, so it needs no migration lock
+var sqliteMigrations = migrationDialect{
+	dir: "migrations/sqlite",
+	createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	tableExists: `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`,
+}
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations returns the migrations embedded in dir ordered by version.
+// Every version must have both an up and a down file.
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		body, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both up and down files", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies all pending migrations and returns how many were applied
+func (p *PostgresDB) Migrate(ctx context.Context) (int, error) {
+	return migrateUp(ctx, p.db, postgresMigrations)
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first
+func (p *PostgresDB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	return migrateDown(ctx, p.db, postgresMigrations, steps)
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (p *PostgresDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return migrationStatus(ctx, p.db, postgresMigrations)
+}
+
+func migrateUp(ctx context.Context, db *sql.DB, dialect migrationDialect) (int, error) {
+	migrations, err := loadMigrations(dialect.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = withMigrationLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			slog.Info("applying migration", "version", mig.Version, "name", mig.Name)
+			if err := runMigration(ctx, conn, mig.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+
+		known := make(map[int64]bool, len(migrations))
+		for _, mig := range migrations {
+			known[mig.Version] = true
+		}
+		for version := range applied {
+			if !known[version] {
+				slog.Warn("database has a migration this build does not know about", "version", version)
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func migrateDown(ctx context.Context, db *sql.DB, dialect migrationDialect, steps int) (int, error) {
+	migrations, err := loadMigrations(dialect.dir)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[int64]Migration, len(migrations))
+	for _, mig := range migrations {
+		known[mig.Version] = mig
+	}
+
+	count := 0
+	err = withMigrationLock(ctx, db, dialect, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions {
+			if count >= steps {
+				break
+			}
+			mig, ok := known[version]
+			if !ok {
+				return fmt.Errorf("migration %d is applied but not known to this build", version)
+			}
+			slog.Info("reverting migration", "version", mig.Version, "name", mig.Name)
+			if err := runMigration(ctx, conn, mig.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func migrationStatus(ctx context.Context, db *sql.DB, dialect migrationDialect) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(dialect.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read-only: a database that was never migrated simply has nothing applied
+	var exists bool
+	if err := db.QueryRowContext(ctx, dialect.tableExists).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check schema_migrations: %w", err)
+	}
+	applied := map[int64]time.Time{}
+	if exists {
+		if applied, err = appliedMigrations(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		appliedAt, ok := applied[mig.Version]
+		status = append(status, MigrationStatus{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return status, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration lock, if the
+// database needs one. Postgres advisory locks belong to a session, so the lock and the
+// migrations must share one connection.
+func withMigrationLock(ctx context.Context, db *sql.DB, dialect migrationDialect, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, dialect.lock); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer func() {
+			// Use a fresh context so the lock is released even if ctx was cancelled
+			unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := conn.ExecContext(unlockCtx, dialect.unlock); err != nil {
+				slog.Warn("failed to release migration lock", "error", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, dialect.createTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return fn(conn)
+}
+
+// appliedMigrations returns the applied versions and when each was applied
+func appliedMigrations(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) (map[int64]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return applied, nil
+}
+
+// runMigration executes a migration script and records it in one transaction,
+// so a failed migration leaves neither schema changes nor a version row behind
+func runMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// No arguments: both drivers then run the script as a whole, which allows several statements
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
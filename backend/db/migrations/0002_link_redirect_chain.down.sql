@@ -0,0 +1,2 @@
+ALTER TABLE links DROP COLUMN IF EXISTS redirect_chain;
+ALTER TABLE links DROP COLUMN IF EXISTS resolved_url;
@@ -0,0 +1,3 @@
+-- Where the destination really leads, recorded when RESOLVE_REDIRECTS is enabled
+ALTER TABLE links ADD COLUMN IF NOT EXISTS resolved_url TEXT;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS redirect_chain TEXT[];
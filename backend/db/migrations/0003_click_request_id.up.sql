@@ -0,0 +1,2 @@
+-- X-Request-ID of the redirect or track call that produced the click
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS request_id TEXT;
@@ -0,0 +1,30 @@
+-- Back to a single clicks table. Rows in dropped or detached partitions are not restored.
+
+DROP TABLE IF EXISTS click_daily_rollups;
+DROP TABLE IF EXISTS link_visitors;
+
+ALTER TABLE clicks RENAME TO clicks_partitioned;
+ALTER SEQUENCE IF EXISTS clicks_id_seq RENAME TO clicks_partitioned_id_seq;
+DROP INDEX IF EXISTS idx_short_code_time;
+
+CREATE TABLE clicks (
+    id BIGSERIAL PRIMARY KEY,
+    short_code VARCHAR(10) NOT NULL,
+    clicked_at TIMESTAMP DEFAULT NOW(),
+    ip_address INET,
+    user_agent TEXT,
+    referer TEXT,
+    visitor_hash VARCHAR(64),
+    request_id TEXT
+);
+
+INSERT INTO clicks (id, short_code, clicked_at, ip_address, user_agent, referer, visitor_hash, request_id)
+SELECT id, short_code, clicked_at, ip_address, user_agent, referer, visitor_hash, request_id
+FROM clicks_partitioned;
+
+SELECT setval(pg_get_serial_sequence('clicks', 'id'), COALESCE((SELECT MAX(id) FROM clicks), 0) + 1, false);
+
+DROP TABLE clicks_partitioned;
+
+CREATE INDEX IF NOT EXISTS idx_short_code_time ON clicks(short_code, clicked_at DESC);
+CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
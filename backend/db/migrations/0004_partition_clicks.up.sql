@@ -0,0 +1,74 @@
+-- Move clicks to monthly range partitions (clicks_YYYY_MM) so old months can be
+-- dropped or detached as a whole, and add rollups that keep historical totals
+-- correct once raw rows are gone. This rewrites the clicks table in one transaction.
+
+ALTER TABLE clicks RENAME TO clicks_unpartitioned;
+ALTER SEQUENCE IF EXISTS clicks_id_seq RENAME TO clicks_unpartitioned_id_seq;
+DROP INDEX IF EXISTS idx_short_code_time;
+-- Partition pruning replaces the global clicked_at index, which slowed every insert
+DROP INDEX IF EXISTS idx_clicked_at;
+
+CREATE TABLE clicks (
+    id BIGSERIAL,
+    short_code VARCHAR(10) NOT NULL,
+    clicked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ip_address INET,
+    user_agent TEXT,
+    referer TEXT,
+    visitor_hash VARCHAR(64),
+    request_id TEXT,
+    PRIMARY KEY (id, clicked_at)
+) PARTITION BY RANGE (clicked_at);
+
+CREATE INDEX IF NOT EXISTS idx_short_code_time ON clicks(short_code, clicked_at DESC);
+
+-- One partition per month from the oldest click up to three months ahead;
+-- the service keeps creating partitions ahead from here on
+DO $$
+DECLARE
+    part_start DATE;
+    last_start DATE := DATE_TRUNC('month', NOW()) + INTERVAL '3 months';
+BEGIN
+    SELECT DATE_TRUNC('month', COALESCE(MIN(clicked_at), NOW())) INTO part_start FROM clicks_unpartitioned;
+    WHILE part_start <= last_start LOOP
+        EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF clicks FOR VALUES FROM (%L) TO (%L)',
+            'clicks_' || to_char(part_start, 'YYYY_MM'), part_start, part_start + INTERVAL '1 month');
+        part_start := part_start + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+-- Rows without a timestamp never showed up in time series; file them under now
+INSERT INTO clicks (id, short_code, clicked_at, ip_address, user_agent, referer, visitor_hash, request_id)
+SELECT id, short_code, COALESCE(clicked_at, NOW()), ip_address, user_agent, referer, visitor_hash, request_id
+FROM clicks_unpartitioned;
+
+SELECT setval(pg_get_serial_sequence('clicks', 'id'), COALESCE((SELECT MAX(id) FROM clicks), 0) + 1, false);
+
+DROP TABLE clicks_unpartitioned;
+
+-- Every distinct visitor per link, so unique visitor counts survive dropped partitions
+CREATE TABLE IF NOT EXISTS link_visitors (
+    short_code VARCHAR(10) NOT NULL,
+    visitor_hash VARCHAR(64) NOT NULL,
+    first_seen TIMESTAMP NOT NULL,
+    PRIMARY KEY (short_code, visitor_hash)
+);
+
+INSERT INTO link_visitors (short_code, visitor_hash, first_seen)
+SELECT short_code, visitor_hash, MIN(clicked_at)
+FROM clicks
+WHERE visitor_hash IS NOT NULL
+GROUP BY short_code, visitor_hash;
+
+-- Clicks per link per day, the source for daily time series
+CREATE TABLE IF NOT EXISTS click_daily_rollups (
+    short_code VARCHAR(10) NOT NULL,
+    day DATE NOT NULL,
+    clicks BIGINT NOT NULL,
+    PRIMARY KEY (short_code, day)
+);
+
+INSERT INTO click_daily_rollups (short_code, day, clicks)
+SELECT short_code, DATE_TRUNC('day', clicked_at)::date, COUNT(*)
+FROM clicks
+GROUP BY short_code, DATE_TRUNC('day', clicked_at)::date;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_links_url_trgm;
+DROP INDEX IF EXISTS idx_links_search;
+ALTER TABLE links DROP COLUMN IF EXISTS search_vector;
+-- pg_trgm is left installed; other database objects may use it
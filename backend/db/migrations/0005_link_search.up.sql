@@ -0,0 +1,13 @@
+-- Full-text and fuzzy search over a user's links (GET /api/links/search).
+-- URLs are split on punctuation before indexing, since the text parser would
+-- otherwise keep "example.com/spring-sale" as one host/path token.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE links ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', short_code), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(original_url, '[^[:alnum:]]+', ' ', 'g')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_links_search ON links USING GIN (search_vector);
+-- Substring and typo-tolerant matches on the destination
+CREATE INDEX IF NOT EXISTS idx_links_url_trgm ON links USING GIN (original_url gin_trgm_ops);
@@ -0,0 +1,16 @@
+ALTER TABLE links DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE links ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', short_code), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(original_url, '[^[:alnum:]]+', ' ', 'g')), 'B')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_links_search ON links USING GIN (search_vector);
+DROP FUNCTION IF EXISTS link_tags_text(TEXT[]);
+
+DROP INDEX IF EXISTS idx_links_folder;
+DROP INDEX IF EXISTS idx_links_tags;
+ALTER TABLE links DROP COLUMN IF EXISTS folder_id;
+ALTER TABLE links DROP COLUMN IF EXISTS tags;
+ALTER TABLE links DROP COLUMN IF EXISTS description;
+ALTER TABLE links DROP COLUMN IF EXISTS title;
+
+DROP TABLE IF EXISTS folders;
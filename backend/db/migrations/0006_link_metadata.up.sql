@@ -0,0 +1,37 @@
+-- Link metadata (title, description, tags) and per-user folders that nest
+CREATE TABLE IF NOT EXISTS folders (
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    parent_id BIGINT REFERENCES folders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Sibling folders have distinct names; top-level folders have parent 0 here
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_user_parent_name ON folders(user_id, COALESCE(parent_id, 0), name);
+CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders(parent_id);
+
+ALTER TABLE links ADD COLUMN IF NOT EXISTS title TEXT;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE links ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE links ADD COLUMN IF NOT EXISTS folder_id BIGINT REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_links_tags ON links USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_links_folder ON links(folder_id) WHERE folder_id IS NOT NULL;
+
+-- array_to_string is only STABLE, which generated columns don't accept; for text[] it is immutable
+CREATE OR REPLACE FUNCTION link_tags_text(tags TEXT[]) RETURNS TEXT
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE
+    AS $$ SELECT array_to_string(tags, ' ') $$;
+
+-- Rebuild the search document with the new fields: short code, title and tags weigh
+-- most, then the destination, then the description
+ALTER TABLE links DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE links ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', short_code), 'A') ||
+    setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(link_tags_text(tags), '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+    setweight(to_tsvector('simple', regexp_replace(original_url, '[^[:alnum:]]+', ' ', 'g')), 'B') ||
+    setweight(to_tsvector('simple', COALESCE(description, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_links_search ON links USING GIN (search_vector);
@@ -0,0 +1,9 @@
+-- Per-user preferences; users without a row have the defaults
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id VARCHAR(50) PRIMARY KEY,
+    reuse_existing_links BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Finds a user's link to a destination; hashed because a URL can outgrow a btree entry
+CREATE INDEX IF NOT EXISTS idx_links_user_url ON links(user_id, md5(original_url));
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS top_referrers;
+DROP TABLE IF EXISTS link_stats;
+DROP TABLE IF EXISTS clicks;
+DROP TABLE IF EXISTS links;
@@ -0,0 +1,45 @@
+-- SQLite schema for single-node installs; mirrors the Postgres migrations version for version.
+-- Timestamps are stored as UTC text ("YYYY-MM-DD HH:MM:SS.fff+00:00") so they sort and compare as strings.
+
+-- Links table
+CREATE TABLE IF NOT EXISTS links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_code TEXT UNIQUE NOT NULL,
+    original_url TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_created ON links(user_id, created_at DESC);
+
+-- Analytics events
+CREATE TABLE IF NOT EXISTS clicks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    short_code TEXT NOT NULL,
+    clicked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ip_address TEXT,
+    user_agent TEXT,
+    referer TEXT,
+    visitor_hash TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_code_time ON clicks(short_code, clicked_at DESC);
+CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
+
+-- Aggregated statistics (updated by workers)
+CREATE TABLE IF NOT EXISTS link_stats (
+    short_code TEXT PRIMARY KEY,
+    total_clicks INTEGER NOT NULL DEFAULT 0,
+    unique_visitors INTEGER NOT NULL DEFAULT 0,
+    last_updated TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Top referrers
+CREATE TABLE IF NOT EXISTS top_referrers (
+    short_code TEXT NOT NULL,
+    referer TEXT NOT NULL,
+    click_count INTEGER NOT NULL,
+    PRIMARY KEY (short_code, referer)
+);
+
+CREATE INDEX IF NOT EXISTS idx_short_code_count ON top_referrers(short_code, click_count DESC);
@@ -0,0 +1,2 @@
+ALTER TABLE links DROP COLUMN redirect_chain;
+ALTER TABLE links DROP COLUMN resolved_url;
@@ -0,0 +1,4 @@
+-- Where the destination really leads, recorded when RESOLVE_REDIRECTS is enabled.
+-- The chain is a JSON array of URLs.
+ALTER TABLE links ADD COLUMN resolved_url TEXT;
+ALTER TABLE links ADD COLUMN redirect_chain TEXT;
@@ -0,0 +1 @@
+ALTER TABLE clicks DROP COLUMN request_id;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_links_folder;
+ALTER TABLE links DROP COLUMN folder_id;
+ALTER TABLE links DROP COLUMN tags;
+ALTER TABLE links DROP COLUMN description;
+ALTER TABLE links DROP COLUMN title;
+
+DROP TABLE IF EXISTS folders;
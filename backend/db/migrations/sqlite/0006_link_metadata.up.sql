@@ -0,0 +1,20 @@
+-- Link metadata (title, description, tags) and per-user folders that nest.
+-- Tags are a JSON array of strings. Foreign keys are not enforced in SQLite;
+-- the store clears folder references itself when folders are deleted.
+CREATE TABLE IF NOT EXISTS folders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    parent_id INTEGER REFERENCES folders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_user_parent_name ON folders(user_id, COALESCE(parent_id, 0), name);
+CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders(parent_id);
+
+ALTER TABLE links ADD COLUMN title TEXT;
+ALTER TABLE links ADD COLUMN description TEXT;
+ALTER TABLE links ADD COLUMN tags TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE links ADD COLUMN folder_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_links_folder ON links(folder_id) WHERE folder_id IS NOT NULL;
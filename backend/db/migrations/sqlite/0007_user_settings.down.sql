@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_links_user_url;
+DROP TABLE IF EXISTS user_settings;
@@ -0,0 +1,8 @@
+-- Per-user preferences; users without a row have the defaults
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id TEXT PRIMARY KEY,
+    reuse_existing_links INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_links_user_url ON links(user_id, original_url);
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// partitionLockID is the Postgres advisory lock key held during partition maintenance,
+// so only one replica creates or expires partitions at a time
+const partitionLockID int64 = 7265091832
+
+// Monthly clicks partitions are named clicks_YYYY_MM and cover that calendar month
+var clickPartitionPattern = regexp.MustCompile(`^clicks_(\d{4})_(\d{2})$`)
+
+// clickPartitionName returns the name of the clicks partition holding month
+func clickPartitionName(month time.Time) string {
+	return fmt.Sprintf("clicks_%04d_%02d", month.Year(), int(month.Month()))
+}
+
+// parseClickPartition returns the first day of the month a partition covers
+func parseClickPartition(name string) (time.Time, bool) {
+	m := clickPartitionPattern.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, false
+	}
+	month, err := time.Parse("2006_01", m[1]+"_"+m[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// monthStart truncates t to the first instant of its month, keeping the wall clock
+// as clicked_at (TIMESTAMP without time zone) does
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsureClickPartitions creates the partitions for the month of now and the monthsAhead
+// months after it, returning how many were created. It does nothing if another
+// replica is already maintaining partitions.
+func (p *PostgresDB) EnsureClickPartitions(ctx context.Context, now time.Time, monthsAhead int) (_ int, err error) {
+	ctx, span := startPostgresSpan(ctx, "EnsureClickPartitions")
+	defer func() { endSpan(span, err) }()
+
+	created := 0
+	err = p.withPartitionLock(ctx, func(tx *sql.Tx) error {
+		existing, err := clickPartitions(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		first := monthStart(now)
+		for i := 0; i <= monthsAhead; i++ {
+			from := first.AddDate(0, i, 0)
+			name := clickPartitionName(from)
+			if existing[name] {
+				continue
+			}
+			query := fmt.Sprintf(`CREATE TABLE %s PARTITION OF clicks FOR VALUES FROM ('%s') TO ('%s')`,
+				pq.QuoteIdentifier(name), from.Format("2006-01-02"), from.AddDate(0, 1, 0).Format("2006-01-02"))
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to create partition %s: %w", name, err)
+			}
+			created++
+		}
+		return nil
+	})
+	return created, err
+}
+
+// ExpireClickPartitions removes the partitions whose whole month is before cutoff and
+// returns their names. With detach the tables are detached from clicks and kept for
+// archiving; otherwise they are dropped. Rollups are not touched, so totals stay correct.
+func (p *PostgresDB) ExpireClickPartitions(ctx context.Context, cutoff time.Time, detach bool) (_ []string, err error) {
+	ctx, span := startPostgresSpan(ctx, "ExpireClickPartitions")
+	defer func() { endSpan(span, err) }()
+
+	// Partition bounds are wall clock dates, like clicked_at
+	cutoff = time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), cutoff.Hour(), cutoff.Minute(), cutoff.Second(), 0, time.UTC)
+
+	var expired []string
+	err = p.withPartitionLock(ctx, func(tx *sql.Tx) error {
+		existing, err := clickPartitions(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for name := range existing {
+			month, ok := parseClickPartition(name)
+			if !ok || month.AddDate(0, 1, 0).After(cutoff) {
+				continue
+			}
+			query := `DROP TABLE ` + pq.QuoteIdentifier(name)
+			if detach {
+				query = `ALTER TABLE clicks DETACH PARTITION ` + pq.QuoteIdentifier(name)
+			}
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to expire partition %s: %w", name, err)
+			}
+			expired = append(expired, name)
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// withPartitionLock runs fn in a transaction holding the partition advisory lock.
+// If another session holds the lock, fn is skipped.
+func (p *PostgresDB) withPartitionLock(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, partitionLockID).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to acquire partition lock: %w", err)
+	}
+	if !locked {
+		return nil
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// WithPartitionLock runs fn while holding the partition advisory lock, so archiving
+// never overlaps partition maintenance on another replica. The lock is held by a
+// dedicated connection rather than a transaction, since an archive run can take a
+// long time. Returns false without calling fn if another session holds the lock.
+func (p *PostgresDB) WithPartitionLock(ctx context.Context, fn func() error) (bool, error) {
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, partitionLockID).Scan(&locked); err != nil {
+		return false, fmt.Errorf("failed to acquire partition lock: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, partitionLockID); err != nil {
+			// Closing the session is the only other way to release the lock
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return true, fn()
+}
+
+// clickPartitions returns the names of the partitions currently attached to clicks
+func clickPartitions(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT child.relname
+	                                   FROM pg_inherits
+	                                   JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+	                                   JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+	                                   WHERE parent.oid = 'clicks'::regclass`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	partitions := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partitions[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return partitions, nil
+}
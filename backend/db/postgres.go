@@ -7,11 +7,12 @@ import (
 	"link-analytics-service/models"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDB struct {
-	db *sql.DB
+	db      *sql.DB
+	replica *replica // optional, see SetReplica
 }
 
 func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
@@ -35,19 +36,37 @@ func NewPostgresDB(databaseURL string) (*PostgresDB, error) {
 }
 
 func (p *PostgresDB) Close() error {
+	if p.replica != nil {
+		p.replica.close()
+	}
 	return p.db.Close()
 }
 
+// Stats returns connection pool statistics
+func (p *PostgresDB) Stats() sql.DBStats {
+	return p.db.Stats()
+}
+
 // Ping checks database connectivity
 func (p *PostgresDB) Ping(ctx context.Context) error {
 	return p.db.PingContext(ctx)
 }
 
-func (p *PostgresDB) CreateLink(ctx context.Context, link *models.Link) error {
-	query := `INSERT INTO links (short_code, original_url, user_id, created_at) 
-	          VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+func (p *PostgresDB) CreateLink(ctx context.Context, link *models.Link) (err error) {
+	ctx, span := startPostgresSpan(ctx, "CreateLink")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO links (short_code, original_url, user_id, created_at, resolved_url, redirect_chain,
+	                             title, description, tags, folder_id) 
+	          VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10) RETURNING id, created_at`
 	
-	err := p.db.QueryRowContext(ctx, query, link.ShortCode, link.OriginalURL, link.UserID, time.Now()).
+	var chain interface{}
+	if len(link.RedirectChain) > 0 {
+		chain = pq.Array(link.RedirectChain)
+	}
+	err = p.db.QueryRowContext(ctx, query, link.ShortCode, link.OriginalURL, link.UserID, time.Now(),
+		link.ResolvedURL, chain, nullString(link.Title), nullString(link.Description),
+		postgresLinks.bindStrings(link.Tags), nullInt64Arg(link.FolderID)).
 		Scan(&link.ID, &link.CreatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create link: %w", err)
@@ -55,23 +74,57 @@ func (p *PostgresDB) CreateLink(ctx context.Context, link *models.Link) error {
 	return nil
 }
 
-func (p *PostgresDB) GetLinkByCode(ctx context.Context, shortCode string) (*models.Link, error) {
-	query := `SELECT id, short_code, original_url, user_id, created_at 
-	          FROM links WHERE short_code = $1`
-	
+// CreateLinks inserts links in one transaction, retrying taken short codes with newCode
+func (p *PostgresDB) CreateLinks(ctx context.Context, links []*models.Link, newCode func() string) (err error) {
+	ctx, span := startPostgresSpan(ctx, "CreateLinks")
+	defer func() { endSpan(span, err) }()
+
+	return createLinks(ctx, p.db, postgresLinks, links, newCode)
+}
+
+func (p *PostgresDB) GetLinkByCode(ctx context.Context, shortCode string) (_ *models.Link, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetLinkByCode")
+	defer func() { endSpan(span, err) }()
+
+	return p.queryLink(ctx, `short_code = $1`, shortCode)
+}
+
+// FindLinkByURL matches on the hash first, which idx_links_user_url indexes
+func (p *PostgresDB) FindLinkByURL(ctx context.Context, userID, url string) (_ *models.Link, err error) {
+	ctx, span := startPostgresSpan(ctx, "FindLinkByURL")
+	defer func() { endSpan(span, err) }()
+
+	return p.queryLink(ctx, `user_id = $1 AND md5(original_url) = md5($2) AND original_url = $2 ORDER BY id LIMIT 1`, userID, url)
+}
+
+// queryLink returns the first link matching where, or *models.NotFoundError
+func (p *PostgresDB) queryLink(ctx context.Context, where string, args ...interface{}) (*models.Link, error) {
+	query := `SELECT id, short_code, original_url, user_id, created_at, resolved_url, redirect_chain,
+	                 COALESCE(title, ''), COALESCE(description, ''), tags, folder_id
+	          FROM links WHERE ` + where
+
 	link := &models.Link{}
-	err := p.db.QueryRowContext(ctx, query, shortCode).
-		Scan(&link.ID, &link.ShortCode, &link.OriginalURL, &link.UserID, &link.CreatedAt)
+	var resolvedURL sql.NullString
+	var folderID sql.NullInt64
+	err := p.db.QueryRowContext(ctx, query, args...).
+		Scan(&link.ID, &link.ShortCode, &link.OriginalURL, &link.UserID, &link.CreatedAt,
+			&resolvedURL, pq.Array(&link.RedirectChain),
+			&link.Title, &link.Description, pq.Array(&link.Tags), &folderID)
 	if err == sql.ErrNoRows {
 		return nil, &models.NotFoundError{Message: "link not found"}
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get link: %w", err)
 	}
+	link.ResolvedURL = resolvedURL.String
+	link.FolderID = nullInt64Ptr(folderID)
 	return link, nil
 }
 
-func (p *PostgresDB) GetLinksByUser(ctx context.Context, userID string) ([]*models.Link, error) {
+func (p *PostgresDB) GetLinksByUser(ctx context.Context, userID string) (_ []*models.Link, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetLinksByUser")
+	defer func() { endSpan(span, err) }()
+
 	query := `SELECT id, short_code, original_url, user_id, created_at 
 	          FROM links WHERE user_id = $1 ORDER BY created_at DESC`
 	
@@ -97,8 +150,113 @@ func (p *PostgresDB) GetLinksByUser(ctx context.Context, userID string) ([]*mode
 	return links, nil
 }
 
+var postgresLinks = linkDialect{
+	hostContains: func(arg string) string {
+		return `POSITION(LOWER(` + arg + `) IN LOWER(SUBSTRING(l.original_url FROM '://([^/?#]+)'))) > 0`
+	},
+	// Containment rather than = ANY so the GIN index on tags is used
+	hasTag: func(arg string) string { return `l.tags @> ARRAY[` + arg + `]::text[]` },
+	tagCounts: `SELECT tag, COUNT(*) FROM links, unnest(tags) AS tag
+	            WHERE user_id = $1 GROUP BY tag ORDER BY COUNT(*) DESC, tag`,
+	lockFolders: `SELECT id FROM folders WHERE user_id = $1 ORDER BY id FOR UPDATE`,
+	bindTime:    func(t time.Time) interface{} { return t.UTC() },
+	bindStrings: func(v []string) interface{} {
+		if v == nil {
+			v = []string{} // a nil pq.StringArray is NULL
+		}
+		return pq.Array(v)
+	},
+	scanStrings: func(v *[]string) interface{} { return pq.Array(v) },
+}
+
+// ListLinks returns one page of a user's links, with click totals from one joined query
+func (p *PostgresDB) ListLinks(ctx context.Context, query models.LinkListQuery) (_ *models.LinkPage, err error) {
+	ctx, span := startPostgresSpan(ctx, "ListLinks")
+	defer func() { endSpan(span, err) }()
+
+	return listLinks(ctx, p.db, postgresLinks, query)
+}
+
+// UpdateLinkMetadata changes the title, description, tags or folder of one of the user's links
+func (p *PostgresDB) UpdateLinkMetadata(ctx context.Context, userID, shortCode string, update models.LinkMetadataUpdate) (_ *models.Link, err error) {
+	ctx, span := startPostgresSpan(ctx, "UpdateLinkMetadata")
+	defer func() { endSpan(span, err) }()
+
+	if err := updateLinkMetadata(ctx, p.db, postgresLinks, userID, shortCode, update); err != nil {
+		return nil, err
+	}
+	return p.GetLinkByCode(ctx, shortCode)
+}
+
+func (p *PostgresDB) GetUserSettings(ctx context.Context, userID string) (_ *models.UserSettings, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetUserSettings")
+	defer func() { endSpan(span, err) }()
+
+	return getUserSettings(ctx, p.db, userID)
+}
+
+func (p *PostgresDB) UpdateUserSettings(ctx context.Context, settings *models.UserSettings) (err error) {
+	ctx, span := startPostgresSpan(ctx, "UpdateUserSettings")
+	defer func() { endSpan(span, err) }()
+
+	return updateUserSettings(ctx, p.db, postgresLinks, settings)
+}
+
+// GetTags lists the tags on the user's links with how many links carry each
+func (p *PostgresDB) GetTags(ctx context.Context, userID string) (_ []models.TagCount, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetTags")
+	defer func() { endSpan(span, err) }()
+
+	return getTags(ctx, p.db, postgresLinks, userID)
+}
+
+func (p *PostgresDB) CreateFolder(ctx context.Context, folder *models.Folder) (err error) {
+	ctx, span := startPostgresSpan(ctx, "CreateFolder")
+	defer func() { endSpan(span, err) }()
+
+	return createFolder(ctx, p.db, postgresLinks, folder)
+}
+
+func (p *PostgresDB) GetFolder(ctx context.Context, userID string, id int64) (_ *models.Folder, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetFolder")
+	defer func() { endSpan(span, err) }()
+
+	return getFolder(ctx, p.db, userID, id)
+}
+
+func (p *PostgresDB) GetFolders(ctx context.Context, userID string) (_ []*models.Folder, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetFolders")
+	defer func() { endSpan(span, err) }()
+
+	return getFolders(ctx, p.db, userID)
+}
+
+func (p *PostgresDB) UpdateFolder(ctx context.Context, folder *models.Folder) (err error) {
+	ctx, span := startPostgresSpan(ctx, "UpdateFolder")
+	defer func() { endSpan(span, err) }()
+
+	return updateFolder(ctx, p.db, postgresLinks, folder)
+}
+
+func (p *PostgresDB) DeleteFolder(ctx context.Context, userID string, id int64) (err error) {
+	ctx, span := startPostgresSpan(ctx, "DeleteFolder")
+	defer func() { endSpan(span, err) }()
+
+	return deleteFolder(ctx, p.db, userID, id)
+}
+
+func (p *PostgresDB) GetGroupLinkCodes(ctx context.Context, group models.LinkGroup) (_ []string, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetGroupLinkCodes")
+	defer func() { endSpan(span, err) }()
+
+	return groupLinkCodes(ctx, p.db, postgresLinks, group)
+}
+
 // GetAllLinks retrieves all links from the database (for cache pre-population)
-func (p *PostgresDB) GetAllLinks(ctx context.Context) ([]*models.Link, error) {
+func (p *PostgresDB) GetAllLinks(ctx context.Context) (_ []*models.Link, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetAllLinks")
+	defer func() { endSpan(span, err) }()
+
 	query := `SELECT id, short_code, original_url, user_id, created_at 
 	          FROM links ORDER BY created_at DESC`
 	
@@ -124,31 +282,34 @@ func (p *PostgresDB) GetAllLinks(ctx context.Context) ([]*models.Link, error) {
 	return links, nil
 }
 
-func (p *PostgresDB) InsertClickEvent(ctx context.Context, event *models.ClickEvent) error {
-	query := `INSERT INTO clicks (short_code, clicked_at, ip_address, user_agent, referer, visitor_hash)
-	          VALUES ($1, $2, $3, $4, $5, $6)`
-	
-	_, err := p.db.ExecContext(ctx, query, event.ShortCode, event.Timestamp, event.IPAddress, 
-		event.UserAgent, event.Referer, event.VisitorHash)
-	if err != nil {
-		return fmt.Errorf("failed to insert click event: %w", err)
-	}
-	return nil
+func (p *PostgresDB) InsertClickEvent(ctx context.Context, event *models.ClickEvent) (err error) {
+	ctx, span := startPostgresSpan(ctx, "InsertClickEvent")
+	defer func() { endSpan(span, err) }()
+
+	return p.insertClicks(ctx, []*models.ClickEvent{event})
 }
 
-func (p *PostgresDB) BatchInsertClickEvents(ctx context.Context, events []*models.ClickEvent) error {
+func (p *PostgresDB) BatchInsertClickEvents(ctx context.Context, events []*models.ClickEvent) (err error) {
+	ctx, span := startPostgresSpan(ctx, "BatchInsertClickEvents")
+	defer func() { endSpan(span, err) }()
+
 	if len(events) == 0 {
 		return nil
 	}
+	return p.insertClicks(ctx, events)
+}
 
+// insertClicks stores raw click rows and updates the rollups in one transaction,
+// so the rollups always agree with the clicks that were ever inserted
+func (p *PostgresDB) insertClicks(ctx context.Context, events []*models.ClickEvent) error {
 	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO clicks (short_code, clicked_at, ip_address, user_agent, referer, visitor_hash)
-	                                      VALUES ($1, $2, $3, $4, $5, $6)`)
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO clicks (short_code, clicked_at, ip_address, user_agent, referer, visitor_hash, request_id)
+	                                      VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
@@ -156,12 +317,16 @@ func (p *PostgresDB) BatchInsertClickEvents(ctx context.Context, events []*model
 
 	for _, event := range events {
 		_, err := stmt.ExecContext(ctx, event.ShortCode, event.Timestamp, event.IPAddress,
-			event.UserAgent, event.Referer, event.VisitorHash)
+			event.UserAgent, event.Referer, event.VisitorHash, event.RequestID)
 		if err != nil {
 			return fmt.Errorf("failed to insert event: %w", err)
 		}
 	}
 
+	if err := recordClickRollups(ctx, tx, events); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -169,12 +334,62 @@ func (p *PostgresDB) BatchInsertClickEvents(ctx context.Context, events []*model
 	return nil
 }
 
-func (p *PostgresDB) GetLinkStats(ctx context.Context, shortCode string) (*models.LinkStats, error) {
+// recordClickRollups adds events to link_visitors and click_daily_rollups.
+// Those tables outlive the clicks partitions dropped by the retention policy.
+func recordClickRollups(ctx context.Context, tx *sql.Tx, events []*models.ClickEvent) error {
+	type dayKey struct {
+		shortCode string
+		day       string
+	}
+	type visitorKey struct {
+		shortCode   string
+		visitorHash string
+	}
+	days := make(map[dayKey]int64)
+	visitors := make(map[visitorKey]time.Time)
+	for _, event := range events {
+		// clicked_at is TIMESTAMP without time zone, which keeps the wall clock time as sent
+		days[dayKey{event.ShortCode, event.Timestamp.Format("2006-01-02")}]++
+		if event.VisitorHash == "" {
+			continue
+		}
+		key := visitorKey{event.ShortCode, event.VisitorHash}
+		if seen, ok := visitors[key]; !ok || event.Timestamp.Before(seen) {
+			visitors[key] = event.Timestamp
+		}
+	}
+
+	for key, count := range days {
+		_, err := tx.ExecContext(ctx, `INSERT INTO click_daily_rollups (short_code, day, clicks)
+		                               VALUES ($1, $2::date, $3)
+		                               ON CONFLICT (short_code, day)
+		                               DO UPDATE SET clicks = click_daily_rollups.clicks + $3`,
+			key.shortCode, key.day, count)
+		if err != nil {
+			return fmt.Errorf("failed to update daily rollup: %w", err)
+		}
+	}
+	for key, firstSeen := range visitors {
+		_, err := tx.ExecContext(ctx, `INSERT INTO link_visitors (short_code, visitor_hash, first_seen)
+		                               VALUES ($1, $2, $3)
+		                               ON CONFLICT (short_code, visitor_hash) DO NOTHING`,
+			key.shortCode, key.visitorHash, firstSeen)
+		if err != nil {
+			return fmt.Errorf("failed to record visitor: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *PostgresDB) GetLinkStats(ctx context.Context, shortCode string) (_ *models.LinkStats, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetLinkStats")
+	defer func() { endSpan(span, err) }()
+
 	query := `SELECT short_code, total_clicks, unique_visitors 
 	          FROM link_stats WHERE short_code = $1`
 	
 	stats := &models.LinkStats{}
-	err := p.db.QueryRowContext(ctx, query, shortCode).
+	err = p.db.QueryRowContext(ctx, query, shortCode).
 		Scan(&stats.ShortCode, &stats.TotalClicks, &stats.UniqueVisitors)
 	if err == sql.ErrNoRows {
 		return &models.LinkStats{
@@ -189,7 +404,10 @@ func (p *PostgresDB) GetLinkStats(ctx context.Context, shortCode string) (*model
 	return stats, nil
 }
 
-func (p *PostgresDB) GetClicksOverTime(ctx context.Context, shortCode string, period time.Duration) ([]models.TimePoint, error) {
+func (p *PostgresDB) GetClicksOverTime(ctx context.Context, shortCode string, period time.Duration) (_ []models.TimePoint, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetClicksOverTime")
+	defer func() { endSpan(span, err) }()
+
 	startTime := time.Now().Add(-period)
 	
 	var query string
@@ -201,65 +419,315 @@ func (p *PostgresDB) GetClicksOverTime(ctx context.Context, shortCode string, pe
 		         GROUP BY time_bucket
 		         ORDER BY time_bucket ASC`
 	} else {
-		query = `SELECT DATE_TRUNC('day', clicked_at) as time_bucket, COUNT(*) as count
-		         FROM clicks
-		         WHERE short_code = $1 AND clicked_at >= $2
-		         GROUP BY time_bucket
-		         ORDER BY time_bucket ASC`
-	}
-
-	rows, err := p.db.QueryContext(ctx, query, shortCode, startTime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query clicks over time: %w", err)
+		// Daily buckets come from the rollup, which still has days whose partitions were dropped
+		startTime = firstWholeDay(startTime)
+		query = `SELECT day::timestamp as time_bucket, clicks as count
+		         FROM click_daily_rollups
+		         WHERE short_code = $1 AND day >= $2::timestamp
+		         ORDER BY day ASC`
 	}
-	defer rows.Close()
 
 	var points []models.TimePoint
-	for rows.Next() {
-		var point models.TimePoint
-		if err := rows.Scan(&point.Timestamp, &point.Count); err != nil {
-			return nil, fmt.Errorf("failed to scan time point: %w", err)
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, shortCode, startTime)
+		if err != nil {
+			return fmt.Errorf("failed to query clicks over time: %w", err)
+		}
+		defer rows.Close()
+
+		points = nil
+		for rows.Next() {
+			var point models.TimePoint
+			if err := rows.Scan(&point.Timestamp, &point.Count); err != nil {
+				return fmt.Errorf("failed to scan time point: %w", err)
+			}
+			points = append(points, point)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("row iteration error: %w", err)
 		}
-		points = append(points, point)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+	return points, nil
+}
+
+func (p *PostgresDB) GetClicksBetween(ctx context.Context, shortCode string, from, to time.Time) (_ []models.TimePoint, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetClicksBetween")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT DATE_TRUNC('hour', clicked_at) as time_bucket, COUNT(*) as count
+	          FROM clicks
+	          WHERE short_code = $1 AND clicked_at >= $2 AND clicked_at < $3
+	          GROUP BY time_bucket
+	          ORDER BY time_bucket ASC`
+	if to.Sub(from) > 24*time.Hour {
+		// Whole days, as the rollup cannot split them
+		from, to = dailyRange(from, to)
+		query = `SELECT day::timestamp as time_bucket, clicks as count
+		         FROM click_daily_rollups
+		         WHERE short_code = $1 AND day >= $2::timestamp AND day < $3::timestamp
+		         ORDER BY day ASC`
 	}
 
+	var points []models.TimePoint
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, shortCode, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to query clicks between: %w", err)
+		}
+		defer rows.Close()
+
+		points = nil
+		for rows.Next() {
+			var point models.TimePoint
+			if err := rows.Scan(&point.Timestamp, &point.Count); err != nil {
+				return fmt.Errorf("failed to scan time point: %w", err)
+			}
+			points = append(points, point)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
 	return points, nil
 }
 
-func (p *PostgresDB) GetTopReferrers(ctx context.Context, shortCode string, limit int) ([]models.Referrer, error) {
+func (p *PostgresDB) GetTopReferrers(ctx context.Context, shortCode string, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetTopReferrers")
+	defer func() { endSpan(span, err) }()
+
 	query := `SELECT referer, click_count 
 	          FROM top_referrers 
 	          WHERE short_code = $1 
 	          ORDER BY click_count DESC 
 	          LIMIT $2`
 	
-	rows, err := p.db.QueryContext(ctx, query, shortCode, limit)
+	var referrers []models.Referrer
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, shortCode, limit)
+		if err != nil {
+			return fmt.Errorf("failed to query top referrers: %w", err)
+		}
+		defer rows.Close()
+
+		referrers = nil
+		for rows.Next() {
+			var ref models.Referrer
+			if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+				return fmt.Errorf("failed to scan referrer: %w", err)
+			}
+			referrers = append(referrers, ref)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("row iteration error: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to query top referrers: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	var referrers []models.Referrer
-	for rows.Next() {
-		var ref models.Referrer
-		if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
-			return nil, fmt.Errorf("failed to scan referrer: %w", err)
+	return referrers, nil
+}
+
+// GetGroupStats sums the links' totals; unique visitors come from link_visitors so a
+// visitor of several of the links is counted once
+func (p *PostgresDB) GetGroupStats(ctx context.Context, shortCodes []string) (_ *models.LinkStats, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetGroupStats")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT (SELECT COALESCE(SUM(total_clicks), 0) FROM link_stats WHERE short_code = ANY($1)),
+	                 (SELECT COUNT(DISTINCT visitor_hash) FROM link_visitors WHERE short_code = ANY($1))`
+
+	stats := &models.LinkStats{}
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, pq.Array(shortCodes)).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (p *PostgresDB) GetGroupClicksOverTime(ctx context.Context, shortCodes []string, period time.Duration) (_ []models.TimePoint, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetGroupClicksOverTime")
+	defer func() { endSpan(span, err) }()
+
+	startTime := time.Now().Add(-period)
+
+	query := `SELECT day::timestamp as time_bucket, SUM(clicks) as count
+	          FROM click_daily_rollups
+	          WHERE short_code = ANY($1) AND day >= $2::timestamp
+	          GROUP BY day
+	          ORDER BY day ASC`
+	if period > 24*time.Hour {
+		startTime = firstWholeDay(startTime)
+	} else {
+		query = `SELECT DATE_TRUNC('hour', clicked_at) as time_bucket, COUNT(*) as count
+		         FROM clicks
+		         WHERE short_code = ANY($1) AND clicked_at >= $2
+		         GROUP BY time_bucket
+		         ORDER BY time_bucket ASC`
+	}
+
+	var points []models.TimePoint
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, pq.Array(shortCodes), startTime)
+		if err != nil {
+			return fmt.Errorf("failed to query group clicks over time: %w", err)
+		}
+		defer rows.Close()
+
+		points = nil
+		for rows.Next() {
+			var point models.TimePoint
+			if err := rows.Scan(&point.Timestamp, &point.Count); err != nil {
+				return fmt.Errorf("failed to scan time point: %w", err)
+			}
+			points = append(points, point)
 		}
-		referrers = append(referrers, ref)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
+	return points, nil
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("row iteration error: %w", err)
+func (p *PostgresDB) CountGroupClicks(ctx context.Context, shortCodes []string, from, to time.Time) (_ *models.LinkStats, err error) {
+	ctx, span := startPostgresSpan(ctx, "CountGroupClicks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(*), COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code = ANY($1) AND clicked_at >= $2 AND clicked_at < $3`
+
+	stats := &models.LinkStats{}
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, pq.Array(shortCodes), from, to).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count group clicks: %w", err)
+	}
+	return stats, nil
+}
+
+func (p *PostgresDB) GetTopLinks(ctx context.Context, shortCodes []string, from, to time.Time, limit int) (_ []models.LinkStats, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetTopLinks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT short_code, COUNT(*) AS clicks, COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code = ANY($1) AND clicked_at >= $2 AND clicked_at < $3
+	          GROUP BY short_code
+	          ORDER BY clicks DESC, short_code
+	          LIMIT $4`
+
+	var links []models.LinkStats
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, pq.Array(shortCodes), from, to, limit)
+		if err != nil {
+			return fmt.Errorf("failed to query top links: %w", err)
+		}
+		defer rows.Close()
+
+		links = nil
+		for rows.Next() {
+			var link models.LinkStats
+			if err := rows.Scan(&link.ShortCode, &link.TotalClicks, &link.UniqueVisitors); err != nil {
+				return fmt.Errorf("failed to scan top link: %w", err)
+			}
+			links = append(links, link)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
+	return links, nil
+}
 
+func (p *PostgresDB) GetTopReferrersBetween(ctx context.Context, shortCodes []string, from, to time.Time, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetTopReferrersBetween")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT referer, COUNT(*) AS click_count
+	          FROM clicks
+	          WHERE short_code = ANY($1) AND clicked_at >= $2 AND clicked_at < $3 AND referer <> ''
+	          GROUP BY referer
+	          ORDER BY click_count DESC, referer
+	          LIMIT $4`
+
+	var referrers []models.Referrer
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, pq.Array(shortCodes), from, to, limit)
+		if err != nil {
+			return fmt.Errorf("failed to query top referrers between: %w", err)
+		}
+		defer rows.Close()
+
+		referrers = nil
+		for rows.Next() {
+			var ref models.Referrer
+			if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+				return fmt.Errorf("failed to scan referrer: %w", err)
+			}
+			referrers = append(referrers, ref)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
 	return referrers, nil
 }
 
-func (p *PostgresDB) UpdateLinkStats(ctx context.Context, shortCode string, totalClicks int64, uniqueVisitors int64) error {
+func (p *PostgresDB) GetGroupTopReferrers(ctx context.Context, shortCodes []string, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetGroupTopReferrers")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT referer, SUM(click_count) AS click_count
+	          FROM top_referrers
+	          WHERE short_code = ANY($1)
+	          GROUP BY referer
+	          ORDER BY click_count DESC, referer
+	          LIMIT $2`
+
+	var referrers []models.Referrer
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, pq.Array(shortCodes), limit)
+		if err != nil {
+			return fmt.Errorf("failed to query group top referrers: %w", err)
+		}
+		defer rows.Close()
+
+		referrers = nil
+		for rows.Next() {
+			var ref models.Referrer
+			if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+				return fmt.Errorf("failed to scan referrer: %w", err)
+			}
+			referrers = append(referrers, ref)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return referrers, nil
+}
+
+func (p *PostgresDB) UpdateLinkStats(ctx context.Context, shortCode string, totalClicks int64, uniqueVisitors int64) (err error) {
+	ctx, span := startPostgresSpan(ctx, "UpdateLinkStats")
+	defer func() { endSpan(span, err) }()
+
 	query := `INSERT INTO link_stats (short_code, total_clicks, unique_visitors, last_updated)
 	          VALUES ($1, $2, $3, NOW())
 	          ON CONFLICT (short_code) 
@@ -268,47 +736,60 @@ func (p *PostgresDB) UpdateLinkStats(ctx context.Context, shortCode string, tota
 	            unique_visitors = $3,
 	            last_updated = NOW()`
 	
-	_, err := p.db.ExecContext(ctx, query, shortCode, totalClicks, uniqueVisitors)
+	_, err = p.db.ExecContext(ctx, query, shortCode, totalClicks, uniqueVisitors)
 	if err != nil {
 		return fmt.Errorf("failed to update link stats: %w", err)
 	}
 	return nil
 }
 
-// RecalculateUniqueVisitors recalculates unique visitors count from clicks table
-func (p *PostgresDB) RecalculateUniqueVisitors(ctx context.Context, shortCode string) (int64, error) {
-	query := `SELECT COUNT(DISTINCT visitor_hash) 
-	          FROM clicks 
+// RecalculateUniqueVisitors counts every visitor the link has ever had, including
+// visitors whose clicks are in partitions that have since been dropped. With a
+// replica the count can trail the primary by up to the allowed lag.
+func (p *PostgresDB) RecalculateUniqueVisitors(ctx context.Context, shortCode string) (_ int64, err error) {
+	ctx, span := startPostgresSpan(ctx, "RecalculateUniqueVisitors")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(*) 
+	          FROM link_visitors 
 	          WHERE short_code = $1`
 	
 	var count int64
-	err := p.db.QueryRowContext(ctx, query, shortCode).Scan(&count)
+	err = p.withReader(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, shortCode).Scan(&count)
+	})
 	if err != nil {
 		return 0, fmt.Errorf("failed to recalculate unique visitors: %w", err)
 	}
 	return count, nil
 }
 
-func (p *PostgresDB) UpdateTopReferrers(ctx context.Context, shortCode string, referer string, count int64) error {
+func (p *PostgresDB) UpdateTopReferrers(ctx context.Context, shortCode string, referer string, count int64) (err error) {
+	ctx, span := startPostgresSpan(ctx, "UpdateTopReferrers")
+	defer func() { endSpan(span, err) }()
+
 	query := `INSERT INTO top_referrers (short_code, referer, click_count)
 	          VALUES ($1, $2, $3)
 	          ON CONFLICT (short_code, referer)
 	          DO UPDATE SET click_count = top_referrers.click_count + $3`
 	
-	_, err := p.db.ExecContext(ctx, query, shortCode, referer, count)
+	_, err = p.db.ExecContext(ctx, query, shortCode, referer, count)
 	if err != nil {
 		return fmt.Errorf("failed to update top referrers: %w", err)
 	}
 	return nil
 }
 
-func (p *PostgresDB) GetUniqueVisitors(ctx context.Context, shortCode string, startTime time.Time) (int64, error) {
+func (p *PostgresDB) GetUniqueVisitors(ctx context.Context, shortCode string, startTime time.Time) (_ int64, err error) {
+	ctx, span := startPostgresSpan(ctx, "GetUniqueVisitors")
+	defer func() { endSpan(span, err) }()
+
 	query := `SELECT COUNT(DISTINCT visitor_hash) 
 	          FROM clicks 
 	          WHERE short_code = $1 AND clicked_at >= $2`
 	
 	var count int64
-	err := p.db.QueryRowContext(ctx, query, shortCode, startTime).Scan(&count)
+	err = p.db.QueryRowContext(ctx, query, shortCode, startTime).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get unique visitors: %w", err)
 	}
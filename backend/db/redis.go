@@ -38,6 +38,7 @@ func NewRedisDB(redisURL string) (*RedisDB, error) {
 	opt.PoolTimeout = 50 * time.Millisecond   // Fast fail if pool exhausted
 
 	client := redis.NewClient(opt)
+	client.AddHook(redisTracingHook{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -72,6 +73,14 @@ func (r *RedisDB) Set(ctx context.Context, key, value string, ttl time.Duration)
 	return nil
 }
 
+func (r *RedisDB) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	ok, err := r.client.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to set key: %w", err)
+	}
+	return ok, nil
+}
+
 func (r *RedisDB) Incr(ctx context.Context, key string) (int64, error) {
 	val, err := r.client.Incr(ctx, key).Result()
 	if err != nil {
@@ -91,6 +100,22 @@ func (r *RedisDB) Incr(ctx context.Context, key string) (int64, error) {
 	return val, nil
 }
 
+// IncrWithTTL increments a counter and sets its expiry only when the key is new,
+// so the window is fixed from the first increment rather than the 60s used by Incr
+func (r *RedisDB) IncrWithTTL(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return r.IncrByWithTTL(ctx, key, 1, ttl)
+}
+
+func (r *RedisDB) IncrByWithTTL(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	pipe := r.client.TxPipeline()
+	incr := pipe.IncrBy(ctx, key, n)
+	pipe.ExpireNX(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to increment key: %w", err)
+	}
+	return incr.Val(), nil
+}
+
 func (r *RedisDB) Delete(ctx context.Context, key string) error {
 	err := r.client.Del(ctx, key).Err()
 	if err != nil {
@@ -110,6 +135,48 @@ func (r *RedisDB) GetInt(ctx context.Context, key string) (int64, error) {
 	return val, nil
 }
 
+// Publish sends a message on a pub/sub channel
+func (r *RedisDB) Publish(ctx context.Context, channel string, message []byte) error {
+	if err := r.client.Publish(ctx, channel, message).Err(); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+// Subscribe delivers messages published on channel to handler until ctx is cancelled.
+// It returns once the subscription is confirmed; go-redis re-subscribes automatically
+// after connection errors.
+func (r *RedisDB) Subscribe(ctx context.Context, channel string, handler func([]byte)) error {
+	pubsub := r.client.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				handler([]byte(msg.Payload))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// PoolStats returns connection pool statistics
+func (r *RedisDB) PoolStats() *redis.PoolStats {
+	return r.client.PoolStats()
+}
+
 // Ping checks Redis connectivity
 func (r *RedisDB) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
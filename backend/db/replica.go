@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaCheckInterval is how often the read replica's health and lag are checked
+const ReplicaCheckInterval = 5 * time.Second
+
+// ReplicaStatus is the result of the last read replica health check
+type ReplicaStatus struct {
+	Healthy   bool
+	Lag       time.Duration
+	CheckedAt time.Time
+	Error     string // why the replica is not used, empty when healthy
+}
+
+// replica is a read-only Postgres pool used for analytics reads while it is
+// reachable and no further behind the primary than maxLag
+type replica struct {
+	db     *sql.DB
+	maxLag time.Duration
+	stop   chan struct{}
+
+	healthy   atomic.Bool
+	fallbacks atomic.Int64
+
+	mu     sync.RWMutex
+	status ReplicaStatus
+}
+
+// Replay lag is 0 while the replica has replayed everything it received, so an idle
+// primary does not look like a lagging replica. On a primary it is always 0.
+const replicaLagQuery = `SELECT CASE
+                                  WHEN NOT pg_is_in_recovery() THEN 0
+                                  WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+                                  ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)
+                                END`
+
+// SetReplica sends read-only analytics queries (GetClicksOverTime, GetTopReferrers,
+// RecalculateUniqueVisitors) to the replica at replicaURL. Those queries go to the
+// primary instead while the replica is unreachable or more than maxLag behind.
+// An unreachable replica is not an error here; it is retried every ReplicaCheckInterval.
+func (p *PostgresDB) SetReplica(replicaURL string, maxLag time.Duration) error {
+	db, err := sql.Open("postgres", replicaURL)
+	if err != nil {
+		return fmt.Errorf("failed to open replica: %w", err)
+	}
+
+	// Only analytics reads go here, so the pool is smaller than the primary's
+	db.SetMaxOpenConns(50)
+	db.SetMaxIdleConns(10)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetConnMaxIdleTime(1 * time.Minute)
+
+	r := &replica{db: db, maxLag: maxLag, stop: make(chan struct{})}
+	r.check()
+	p.replica = r
+	go r.monitor()
+	return nil
+}
+
+func (r *replica) monitor() {
+	ticker := time.NewTicker(ReplicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	status := ReplicaStatus{CheckedAt: time.Now()}
+	var lagSeconds float64
+	if err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lagSeconds); err != nil {
+		status.Error = "unreachable: " + err.Error()
+	} else {
+		status.Lag = time.Duration(lagSeconds * float64(time.Second))
+		if status.Lag > r.maxLag {
+			status.Error = fmt.Sprintf("replication lag %s exceeds %s", status.Lag.Round(time.Millisecond), r.maxLag)
+		} else {
+			status.Healthy = true
+		}
+	}
+
+	if was := r.healthy.Swap(status.Healthy); was != status.Healthy {
+		if status.Healthy {
+			slog.Info("read replica healthy, analytics reads use it", "lag", status.Lag)
+		} else {
+			slog.Warn("read replica not usable, analytics reads use the primary", "reason", status.Error)
+		}
+	}
+
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+}
+
+func (r *replica) close() error {
+	close(r.stop)
+	return r.db.Close()
+}
+
+type primaryReadKey struct{}
+
+// ReadFromPrimary marks ctx so replica-eligible queries run on the primary, for
+// callers that must see their own writes
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey{}, true)
+}
+
+// reader returns the replica when it is healthy and ctx allows it, and the primary
+// otherwise. Unlike withReader it cannot retry, for queries consumed as they stream.
+func (p *PostgresDB) reader(ctx context.Context) *sql.DB {
+	if r := p.replica; r != nil && r.healthy.Load() && ctx.Value(primaryReadKey{}) == nil {
+		return r.db
+	}
+	return p.db
+}
+
+// withReader runs a read-only query on the replica when it is healthy, and on the
+// primary otherwise. A query that fails on the replica is retried on the primary.
+func (p *PostgresDB) withReader(ctx context.Context, query func(db *sql.DB) error) error {
+	r := p.replica
+	if r == nil || !r.healthy.Load() || ctx.Value(primaryReadKey{}) != nil {
+		return query(p.db)
+	}
+	err := query(r.db)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	r.fallbacks.Add(1)
+	slog.WarnContext(ctx, "replica query failed, retrying on the primary", "error", err)
+	return query(p.db)
+}
+
+// ReplicaStatus returns the last replica health check; ok is false without a replica
+func (p *PostgresDB) ReplicaStatus() (status ReplicaStatus, ok bool) {
+	r := p.replica
+	if r == nil {
+		return ReplicaStatus{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status, true
+}
+
+// ReplicaFallbacks counts replica queries that failed and were retried on the primary
+func (p *PostgresDB) ReplicaFallbacks() int64 {
+	if p.replica == nil {
+		return 0
+	}
+	return p.replica.fallbacks.Load()
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SearchLinks ranks the user's links with the search_vector full-text index (prefix
+// matches on every term; short code, title and tags weighted above the destination,
+// and the destination above the description) plus trigram word similarity on the
+// destination, so substrings and small typos still match
+func (p *PostgresDB) SearchLinks(ctx context.Context, q models.LinkSearchQuery) (_ []*models.LinkSearchResult, err error) {
+	ctx, span := startPostgresSpan(ctx, "SearchLinks")
+	defer func() { endSpan(span, err) }()
+
+	if len(q.Terms) == 0 {
+		return []*models.LinkSearchResult{}, nil
+	}
+
+	// Terms are letters and digits only, so they are safe in tsquery syntax
+	prefixes := make([]string, len(q.Terms))
+	for i, term := range q.Terms {
+		prefixes[i] = term + ":*"
+	}
+
+	query := `SELECT l.id, l.short_code, l.original_url, l.user_id, l.created_at,
+	                 COALESCE(l.title, ''), COALESCE(l.description, ''), l.tags, COALESCE(s.total_clicks, 0),
+	                 ts_rank(l.search_vector, tsq.query) + word_similarity($2, l.original_url) AS rank
+	          FROM links l
+	          CROSS JOIN (SELECT to_tsquery('simple', $3) AS query) tsq
+	          LEFT JOIN link_stats s ON s.short_code = l.short_code
+	          WHERE l.user_id = $1
+	            AND (l.search_vector @@ tsq.query OR l.original_url ILIKE $4 OR l.title ILIKE $4 OR $2 <% l.original_url)
+	          ORDER BY rank DESC, l.id DESC
+	          LIMIT $5`
+
+	return postgresLinks.scanSearchResults(p.db.QueryContext(ctx, query, q.UserID, q.Text, strings.Join(prefixes, " & "),
+		"%"+escapeLike(q.Text)+"%", q.Limit))
+}
+
+// scanSearchResults scans the rows of a search query, which selects id, short_code,
+// original_url, user_id, created_at, title, description, tags, total clicks and rank
+func (d linkDialect) scanSearchResults(rows *sql.Rows, err error) ([]*models.LinkSearchResult, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to search links: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*models.LinkSearchResult{}
+	for rows.Next() {
+		r := &models.LinkSearchResult{}
+		if err := rows.Scan(&r.ID, &r.ShortCode, &r.OriginalURL, &r.UserID, &r.CreatedAt,
+			&r.Title, &r.Description, d.scanStrings(&r.Tags), &r.TotalClicks, &r.Rank); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return results, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// rankLinkMatch scores a link for the stores without full-text indexes. It is 0
+// unless every term appears in the link; like the weights of the Postgres
+// search_vector, matches in the short code, title or tags count for more than
+// matches in the destination, and those for more than matches in the description.
+func rankLinkMatch(link *models.Link, terms []string) float64 {
+	code := strings.ToLower(link.ShortCode)
+	title := strings.ToLower(link.Title)
+	destination := strings.ToLower(link.OriginalURL)
+	description := strings.ToLower(link.Description)
+
+	var rank float64
+	for _, term := range terms {
+		switch {
+		case code == term || slices.Contains(link.Tags, term):
+			rank += 1
+		case strings.Contains(code, term) || strings.Contains(title, term) || tagsContain(link.Tags, term):
+			rank += 0.5
+		case strings.Contains(destination, term):
+			rank += 0.2
+		case strings.Contains(description, term):
+			rank += 0.1
+		default:
+			return 0
+		}
+	}
+	return rank
+}
+
+func tagsContain(tags []string, term string) bool {
+	for _, tag := range tags {
+		if strings.Contains(tag, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortSearchResults orders results best first and keeps at most limit
+func sortSearchResults(results []*models.LinkSearchResult, limit int) []*models.LinkSearchResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].ID > results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"link-analytics-service/models"
+	"time"
+)
+
+// User settings, shared by PostgresDB and SQLiteDB
+
+func getUserSettings(ctx context.Context, db *sql.DB, userID string) (*models.UserSettings, error) {
+	settings := &models.UserSettings{UserID: userID}
+	err := db.QueryRowContext(ctx, `SELECT reuse_existing_links FROM user_settings WHERE user_id = $1`, userID).
+		Scan(&settings.ReuseExistingLinks)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get user settings: %w", err)
+	}
+	return settings, nil
+}
+
+func updateUserSettings(ctx context.Context, db *sql.DB, dialect linkDialect, settings *models.UserSettings) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO user_settings (user_id, reuse_existing_links, updated_at) VALUES ($1, $2, $3)
+	                               ON CONFLICT (user_id) DO UPDATE
+	                               SET reuse_existing_links = EXCLUDED.reuse_existing_links, updated_at = EXCLUDED.updated_at`,
+		settings.UserID, settings.ReuseExistingLinks, dialect.bindTime(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to update user settings: %w", err)
+	}
+	return nil
+}
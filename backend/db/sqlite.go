@@ -0,0 +1,869 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"link-analytics-service/models"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB stores links and analytics in a single SQLite file, for single-node
+// installs that don't want to run Postgres. It has its own migrations under
+// migrations/sqlite.
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// Layout of the bucket labels produced by strftime in GetClicksOverTime
+const sqliteBucketLayout = "2006-01-02 15:04:05"
+
+// NewSQLiteDB opens the database named by a sqlite:// URL (sqlite:///var/lib/links.db
+// for an absolute path, sqlite://links.db for a relative one) or a file: DSN.
+// The file is created if it doesn't exist.
+func NewSQLiteDB(databaseURL string) (*SQLiteDB, error) {
+	dsn, err := sqliteDSN(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite allows one writer at a time; a single connection queues statements
+	// in the pool instead of failing them with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &SQLiteDB{db: db}, nil
+}
+
+// sqliteDSN turns DATABASE_URL into a driver DSN with the pragmas the store relies on
+func sqliteDSN(databaseURL string) (string, error) {
+	var path, query string
+	switch {
+	case strings.HasPrefix(databaseURL, "sqlite://"), strings.HasPrefix(databaseURL, "sqlite3://"):
+		path = databaseURL[strings.Index(databaseURL, "://")+3:]
+	case strings.HasPrefix(databaseURL, "file:"):
+		path = strings.TrimPrefix(databaseURL, "file:")
+	default:
+		return "", fmt.Errorf("not a SQLite URL: %q", databaseURL)
+	}
+	path, query, _ = strings.Cut(path, "?")
+	if path == "" {
+		return "", fmt.Errorf("SQLite URL %q has no file path", databaseURL)
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid SQLite URL parameters: %w", err)
+	}
+	// WAL lets readers continue while the workers write; timestamps are written
+	// in a format SQLite's date functions understand
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Set("_time_format", "sqlite")
+	return "file:" + path + "?" + params.Encode(), nil
+}
+
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks database connectivity
+func (s *SQLiteDB) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Migrate applies all pending migrations and returns how many were applied
+func (s *SQLiteDB) Migrate(ctx context.Context) (int, error) {
+	return migrateUp(ctx, s.db, sqliteMigrations)
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first
+func (s *SQLiteDB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	return migrateDown(ctx, s.db, sqliteMigrations, steps)
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (s *SQLiteDB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return migrationStatus(ctx, s.db, sqliteMigrations)
+}
+
+func (s *SQLiteDB) CreateLink(ctx context.Context, link *models.Link) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "CreateLink")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO links (short_code, original_url, user_id, created_at, resolved_url, redirect_chain,
+	                             title, description, tags, folder_id)
+	          VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10) RETURNING id, created_at`
+
+	var chain interface{}
+	if len(link.RedirectChain) > 0 {
+		encoded, err := json.Marshal(link.RedirectChain)
+		if err != nil {
+			return fmt.Errorf("failed to encode redirect chain: %w", err)
+		}
+		chain = string(encoded)
+	}
+	err = s.db.QueryRowContext(ctx, query, link.ShortCode, link.OriginalURL, link.UserID, time.Now().UTC(),
+		link.ResolvedURL, chain, nullString(link.Title), nullString(link.Description),
+		sqliteStrings(link.Tags), nullInt64Arg(link.FolderID)).
+		Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+	return nil
+}
+
+// CreateLinks inserts links in one transaction, retrying taken short codes with newCode
+func (s *SQLiteDB) CreateLinks(ctx context.Context, links []*models.Link, newCode func() string) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "CreateLinks")
+	defer func() { endSpan(span, err) }()
+
+	return createLinks(ctx, s.db, sqliteLinks, links, newCode)
+}
+
+func (s *SQLiteDB) GetLinkByCode(ctx context.Context, shortCode string) (_ *models.Link, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetLinkByCode")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryLink(ctx, `short_code = $1`, shortCode)
+}
+
+func (s *SQLiteDB) FindLinkByURL(ctx context.Context, userID, url string) (_ *models.Link, err error) {
+	ctx, span := startSQLiteSpan(ctx, "FindLinkByURL")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryLink(ctx, `user_id = $1 AND original_url = $2 ORDER BY id LIMIT 1`, userID, url)
+}
+
+// queryLink returns the first link matching where, or *models.NotFoundError
+func (s *SQLiteDB) queryLink(ctx context.Context, where string, args ...interface{}) (*models.Link, error) {
+	query := `SELECT id, short_code, original_url, user_id, created_at, resolved_url, redirect_chain,
+	                 COALESCE(title, ''), COALESCE(description, ''), tags, folder_id
+	          FROM links WHERE ` + where
+
+	link := &models.Link{}
+	var resolvedURL, chain sql.NullString
+	var folderID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, args...).
+		Scan(&link.ID, &link.ShortCode, &link.OriginalURL, &link.UserID, &link.CreatedAt,
+			&resolvedURL, &chain, &link.Title, &link.Description, jsonStrings{&link.Tags}, &folderID)
+	if err == sql.ErrNoRows {
+		return nil, &models.NotFoundError{Message: "link not found"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link: %w", err)
+	}
+	link.ResolvedURL = resolvedURL.String
+	link.FolderID = nullInt64Ptr(folderID)
+	if chain.Valid {
+		if err := json.Unmarshal([]byte(chain.String), &link.RedirectChain); err != nil {
+			return nil, fmt.Errorf("failed to decode redirect chain: %w", err)
+		}
+	}
+	return link, nil
+}
+
+func (s *SQLiteDB) GetLinksByUser(ctx context.Context, userID string) (_ []*models.Link, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetLinksByUser")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryLinks(ctx, `SELECT id, short_code, original_url, user_id, created_at
+	                          FROM links WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+}
+
+// SQLite has no regular expressions, so the host is the text between :// and the next /
+const sqliteURLHost = `SUBSTR(SUBSTR(l.original_url, INSTR(l.original_url, '://') + 3) || '/', 1,
+                              INSTR(SUBSTR(l.original_url, INSTR(l.original_url, '://') + 3) || '/', '/') - 1)`
+
+// Tags are stored as a JSON array of strings
+var sqliteLinks = linkDialect{
+	hostContains: func(arg string) string {
+		return `INSTR(LOWER(` + sqliteURLHost + `), LOWER(` + arg + `)) > 0`
+	},
+	hasTag: func(arg string) string { return `EXISTS (SELECT 1 FROM json_each(l.tags) WHERE value = ` + arg + `)` },
+	tagCounts: `SELECT t.value, COUNT(*) FROM links l, json_each(l.tags) t
+	            WHERE l.user_id = $1 GROUP BY t.value ORDER BY COUNT(*) DESC, t.value`,
+	bindTime:    func(t time.Time) interface{} { return t.UTC() },
+	bindStrings: sqliteStrings,
+	scanStrings: func(v *[]string) interface{} { return jsonStrings{v} },
+	// No lockFolders: the single connection already runs transactions one at a time
+}
+
+// sqliteStrings encodes a string list as a JSON array
+func sqliteStrings(v []string) interface{} {
+	if v == nil {
+		v = []string{}
+	}
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
+
+// jsonStrings scans a JSON array of strings
+type jsonStrings struct{ dest *[]string }
+
+func (j jsonStrings) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*j.dest = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T as a JSON array", src)
+	}
+	return json.Unmarshal(data, j.dest)
+}
+
+// ListLinks returns one page of a user's links, with click totals from one joined query
+func (s *SQLiteDB) ListLinks(ctx context.Context, query models.LinkListQuery) (_ *models.LinkPage, err error) {
+	ctx, span := startSQLiteSpan(ctx, "ListLinks")
+	defer func() { endSpan(span, err) }()
+
+	return listLinks(ctx, s.db, sqliteLinks, query)
+}
+
+// SearchLinks narrows the user's links to those containing every term, then ranks
+// them in Go; SQLite installs are small enough not to need a full-text index
+func (s *SQLiteDB) SearchLinks(ctx context.Context, q models.LinkSearchQuery) (_ []*models.LinkSearchResult, err error) {
+	ctx, span := startSQLiteSpan(ctx, "SearchLinks")
+	defer func() { endSpan(span, err) }()
+
+	if len(q.Terms) == 0 {
+		return []*models.LinkSearchResult{}, nil
+	}
+
+	query := `SELECT l.id, l.short_code, l.original_url, l.user_id, l.created_at,
+	                 COALESCE(l.title, ''), COALESCE(l.description, ''), l.tags, COALESCE(s.total_clicks, 0), 0
+	          FROM links l
+	          LEFT JOIN link_stats s ON s.short_code = l.short_code
+	          WHERE l.user_id = $1`
+	args := []interface{}{q.UserID}
+	for _, term := range q.Terms {
+		args = append(args, term)
+		query += fmt.Sprintf(` AND INSTR(LOWER(l.short_code || ' ' || l.original_url || ' ' || COALESCE(l.title, '') || ' ' ||
+		                                       COALESCE(l.description, '') || ' ' || l.tags), $%d) > 0`, len(args))
+	}
+
+	results, err := sqliteLinks.scanSearchResults(s.db.QueryContext(ctx, query, args...))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		r.Rank = rankLinkMatch(&r.Link, q.Terms)
+	}
+	return sortSearchResults(results, q.Limit), nil
+}
+
+// UpdateLinkMetadata changes the title, description, tags or folder of one of the user's links
+func (s *SQLiteDB) UpdateLinkMetadata(ctx context.Context, userID, shortCode string, update models.LinkMetadataUpdate) (_ *models.Link, err error) {
+	ctx, span := startSQLiteSpan(ctx, "UpdateLinkMetadata")
+	defer func() { endSpan(span, err) }()
+
+	if err := updateLinkMetadata(ctx, s.db, sqliteLinks, userID, shortCode, update); err != nil {
+		return nil, err
+	}
+	return s.GetLinkByCode(ctx, shortCode)
+}
+
+func (s *SQLiteDB) GetUserSettings(ctx context.Context, userID string) (_ *models.UserSettings, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetUserSettings")
+	defer func() { endSpan(span, err) }()
+
+	return getUserSettings(ctx, s.db, userID)
+}
+
+func (s *SQLiteDB) UpdateUserSettings(ctx context.Context, settings *models.UserSettings) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "UpdateUserSettings")
+	defer func() { endSpan(span, err) }()
+
+	return updateUserSettings(ctx, s.db, sqliteLinks, settings)
+}
+
+// GetTags lists the tags on the user's links with how many links carry each
+func (s *SQLiteDB) GetTags(ctx context.Context, userID string) (_ []models.TagCount, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetTags")
+	defer func() { endSpan(span, err) }()
+
+	return getTags(ctx, s.db, sqliteLinks, userID)
+}
+
+func (s *SQLiteDB) CreateFolder(ctx context.Context, folder *models.Folder) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "CreateFolder")
+	defer func() { endSpan(span, err) }()
+
+	return createFolder(ctx, s.db, sqliteLinks, folder)
+}
+
+func (s *SQLiteDB) GetFolder(ctx context.Context, userID string, id int64) (_ *models.Folder, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetFolder")
+	defer func() { endSpan(span, err) }()
+
+	return getFolder(ctx, s.db, userID, id)
+}
+
+func (s *SQLiteDB) GetFolders(ctx context.Context, userID string) (_ []*models.Folder, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetFolders")
+	defer func() { endSpan(span, err) }()
+
+	return getFolders(ctx, s.db, userID)
+}
+
+func (s *SQLiteDB) UpdateFolder(ctx context.Context, folder *models.Folder) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "UpdateFolder")
+	defer func() { endSpan(span, err) }()
+
+	return updateFolder(ctx, s.db, sqliteLinks, folder)
+}
+
+func (s *SQLiteDB) DeleteFolder(ctx context.Context, userID string, id int64) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "DeleteFolder")
+	defer func() { endSpan(span, err) }()
+
+	return deleteFolder(ctx, s.db, userID, id)
+}
+
+func (s *SQLiteDB) GetGroupLinkCodes(ctx context.Context, group models.LinkGroup) (_ []string, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetGroupLinkCodes")
+	defer func() { endSpan(span, err) }()
+
+	return groupLinkCodes(ctx, s.db, sqliteLinks, group)
+}
+
+// GetAllLinks retrieves all links from the database (for cache pre-population)
+func (s *SQLiteDB) GetAllLinks(ctx context.Context) (_ []*models.Link, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetAllLinks")
+	defer func() { endSpan(span, err) }()
+
+	return s.queryLinks(ctx, `SELECT id, short_code, original_url, user_id, created_at
+	                          FROM links ORDER BY created_at DESC`)
+}
+
+func (s *SQLiteDB) queryLinks(ctx context.Context, query string, args ...interface{}) ([]*models.Link, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*models.Link
+	for rows.Next() {
+		link := &models.Link{}
+		if err := rows.Scan(&link.ID, &link.ShortCode, &link.OriginalURL, &link.UserID, &link.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return links, nil
+}
+
+const sqliteInsertClick = `INSERT INTO clicks (short_code, clicked_at, ip_address, user_agent, referer, visitor_hash, request_id)
+                           VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`
+
+func (s *SQLiteDB) InsertClickEvent(ctx context.Context, event *models.ClickEvent) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "InsertClickEvent")
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.db.ExecContext(ctx, sqliteInsertClick, event.ShortCode, event.Timestamp.UTC(), event.IPAddress,
+		event.UserAgent, event.Referer, event.VisitorHash, event.RequestID)
+	if err != nil {
+		return fmt.Errorf("failed to insert click event: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) BatchInsertClickEvents(ctx context.Context, events []*models.ClickEvent) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "BatchInsertClickEvents")
+	defer func() { endSpan(span, err) }()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsertClick)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, event := range events {
+		_, err := stmt.ExecContext(ctx, event.ShortCode, event.Timestamp.UTC(), event.IPAddress,
+			event.UserAgent, event.Referer, event.VisitorHash, event.RequestID)
+		if err != nil {
+			return fmt.Errorf("failed to insert event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteDB) GetLinkStats(ctx context.Context, shortCode string) (_ *models.LinkStats, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetLinkStats")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT short_code, total_clicks, unique_visitors
+	          FROM link_stats WHERE short_code = $1`
+
+	stats := &models.LinkStats{}
+	err = s.db.QueryRowContext(ctx, query, shortCode).
+		Scan(&stats.ShortCode, &stats.TotalClicks, &stats.UniqueVisitors)
+	if err == sql.ErrNoRows {
+		return &models.LinkStats{ShortCode: shortCode}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get link stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (s *SQLiteDB) GetClicksOverTime(ctx context.Context, shortCode string, period time.Duration) (_ []models.TimePoint, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetClicksOverTime")
+	defer func() { endSpan(span, err) }()
+
+	startTime := time.Now().Add(-period).UTC()
+
+	// Same buckets as Postgres' DATE_TRUNC: hourly up to a day, daily beyond
+	bucket := `strftime('%Y-%m-%d 00:00:00', clicked_at)`
+	if period <= 24*time.Hour {
+		bucket = `strftime('%Y-%m-%d %H:00:00', clicked_at)`
+	} else {
+		startTime = firstWholeDay(startTime)
+	}
+	query := `SELECT ` + bucket + ` AS time_bucket, COUNT(*) AS count
+	          FROM clicks
+	          WHERE short_code = $1 AND clicked_at >= $2
+	          GROUP BY time_bucket
+	          ORDER BY time_bucket ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, shortCode, startTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clicks over time: %w", err)
+	}
+	defer rows.Close()
+
+	var points []models.TimePoint
+	for rows.Next() {
+		var point models.TimePoint
+		var label string
+		if err := rows.Scan(&label, &point.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan time point: %w", err)
+		}
+		if point.Timestamp, err = time.Parse(sqliteBucketLayout, label); err != nil {
+			return nil, fmt.Errorf("failed to parse time bucket: %w", err)
+		}
+		points = append(points, point)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return points, nil
+}
+
+func (s *SQLiteDB) GetClicksBetween(ctx context.Context, shortCode string, from, to time.Time) (_ []models.TimePoint, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetClicksBetween")
+	defer func() { endSpan(span, err) }()
+
+	bucket := `strftime('%Y-%m-%d 00:00:00', clicked_at)`
+	if to.Sub(from) <= 24*time.Hour {
+		bucket = `strftime('%Y-%m-%d %H:00:00', clicked_at)`
+	} else {
+		from, to = dailyRange(from, to)
+	}
+	query := `SELECT ` + bucket + ` AS time_bucket, COUNT(*) AS count
+	          FROM clicks
+	          WHERE short_code = $1 AND clicked_at >= $2 AND clicked_at < $3
+	          GROUP BY time_bucket
+	          ORDER BY time_bucket ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, shortCode, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clicks between: %w", err)
+	}
+	defer rows.Close()
+
+	var points []models.TimePoint
+	for rows.Next() {
+		var point models.TimePoint
+		var label string
+		if err := rows.Scan(&label, &point.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan time point: %w", err)
+		}
+		if point.Timestamp, err = time.Parse(sqliteBucketLayout, label); err != nil {
+			return nil, fmt.Errorf("failed to parse time bucket: %w", err)
+		}
+		points = append(points, point)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return points, nil
+}
+
+// sqliteClickPage is how many clicks ScanLinkClicks reads per query. The store has
+// a single connection, so no query stays open while fn runs.
+const sqliteClickPage = 1000
+
+// ScanLinkClicks reads the clicks in pages, continuing after the last (clicked_at, id) seen
+func (s *SQLiteDB) ScanLinkClicks(ctx context.Context, shortCode string, from, to time.Time, fn func(*models.ClickRecord) error) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "ScanLinkClicks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT id, short_code, clicked_at, COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+	                 COALESCE(referer, ''), COALESCE(visitor_hash, ''), COALESCE(request_id, '')
+	          FROM clicks
+	          WHERE short_code = $1 AND clicked_at < $2 AND (clicked_at > $3 OR (clicked_at = $3 AND id > $4))
+	          ORDER BY clicked_at, id
+	          LIMIT $5`
+
+	afterTime, afterID := from.UTC(), int64(0)
+	for {
+		page, err := s.queryClickRecords(ctx, query, shortCode, to.UTC(), afterTime, afterID, sqliteClickPage)
+		if err != nil {
+			return err
+		}
+		for _, rec := range page {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(page) < sqliteClickPage {
+			return nil
+		}
+		last := page[len(page)-1]
+		afterTime, afterID = last.ClickedAt.UTC(), last.ID
+	}
+}
+
+func (s *SQLiteDB) queryClickRecords(ctx context.Context, query string, args ...interface{}) ([]*models.ClickRecord, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query clicks: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*models.ClickRecord
+	for rows.Next() {
+		rec := &models.ClickRecord{}
+		if err := rows.Scan(&rec.ID, &rec.ShortCode, &rec.ClickedAt, &rec.IPAddress, &rec.UserAgent,
+			&rec.Referer, &rec.VisitorHash, &rec.RequestID); err != nil {
+			return nil, fmt.Errorf("failed to scan click: %w", err)
+		}
+		records = append(records, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return records, nil
+}
+
+func (s *SQLiteDB) GetTopReferrers(ctx context.Context, shortCode string, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetTopReferrers")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT referer, click_count
+	          FROM top_referrers
+	          WHERE short_code = $1
+	          ORDER BY click_count DESC
+	          LIMIT $2`
+
+	rows, err := s.db.QueryContext(ctx, query, shortCode, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	var referrers []models.Referrer
+	for rows.Next() {
+		var ref models.Referrer
+		if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+			return nil, fmt.Errorf("failed to scan referrer: %w", err)
+		}
+		referrers = append(referrers, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return referrers, nil
+}
+
+// GetGroupStats sums the links' totals and counts distinct visitors across them
+func (s *SQLiteDB) GetGroupStats(ctx context.Context, shortCodes []string) (_ *models.LinkStats, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetGroupStats")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT (SELECT COALESCE(SUM(total_clicks), 0) FROM link_stats
+	                  WHERE short_code IN (SELECT value FROM json_each($1))),
+	                 (SELECT COUNT(DISTINCT visitor_hash) FROM clicks
+	                  WHERE short_code IN (SELECT value FROM json_each($1)))`
+
+	stats := &models.LinkStats{}
+	err = s.db.QueryRowContext(ctx, query, sqliteStrings(shortCodes)).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (s *SQLiteDB) GetGroupClicksOverTime(ctx context.Context, shortCodes []string, period time.Duration) (_ []models.TimePoint, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetGroupClicksOverTime")
+	defer func() { endSpan(span, err) }()
+
+	startTime := time.Now().Add(-period).UTC()
+
+	bucket := `strftime('%Y-%m-%d 00:00:00', clicked_at)`
+	if period <= 24*time.Hour {
+		bucket = `strftime('%Y-%m-%d %H:00:00', clicked_at)`
+	} else {
+		startTime = firstWholeDay(startTime)
+	}
+	query := `SELECT ` + bucket + ` AS time_bucket, COUNT(*) AS count
+	          FROM clicks
+	          WHERE short_code IN (SELECT value FROM json_each($1)) AND clicked_at >= $2
+	          GROUP BY time_bucket
+	          ORDER BY time_bucket ASC`
+
+	rows, err := s.db.QueryContext(ctx, query, sqliteStrings(shortCodes), startTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group clicks over time: %w", err)
+	}
+	defer rows.Close()
+
+	var points []models.TimePoint
+	for rows.Next() {
+		var point models.TimePoint
+		var label string
+		if err := rows.Scan(&label, &point.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan time point: %w", err)
+		}
+		if point.Timestamp, err = time.Parse(sqliteBucketLayout, label); err != nil {
+			return nil, fmt.Errorf("failed to parse time bucket: %w", err)
+		}
+		points = append(points, point)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return points, nil
+}
+
+func (s *SQLiteDB) CountGroupClicks(ctx context.Context, shortCodes []string, from, to time.Time) (_ *models.LinkStats, err error) {
+	ctx, span := startSQLiteSpan(ctx, "CountGroupClicks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(*), COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code IN (SELECT value FROM json_each($1)) AND clicked_at >= $2 AND clicked_at < $3`
+
+	stats := &models.LinkStats{}
+	err = s.db.QueryRowContext(ctx, query, sqliteStrings(shortCodes), from.UTC(), to.UTC()).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count group clicks: %w", err)
+	}
+	return stats, nil
+}
+
+func (s *SQLiteDB) GetTopLinks(ctx context.Context, shortCodes []string, from, to time.Time, limit int) (_ []models.LinkStats, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetTopLinks")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT short_code, COUNT(*) AS clicks, COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code IN (SELECT value FROM json_each($1)) AND clicked_at >= $2 AND clicked_at < $3
+	          GROUP BY short_code
+	          ORDER BY clicks DESC, short_code
+	          LIMIT $4`
+
+	rows, err := s.db.QueryContext(ctx, query, sqliteStrings(shortCodes), from.UTC(), to.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []models.LinkStats
+	for rows.Next() {
+		var link models.LinkStats
+		if err := rows.Scan(&link.ShortCode, &link.TotalClicks, &link.UniqueVisitors); err != nil {
+			return nil, fmt.Errorf("failed to scan top link: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return links, nil
+}
+
+func (s *SQLiteDB) GetTopReferrersBetween(ctx context.Context, shortCodes []string, from, to time.Time, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetTopReferrersBetween")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT referer, COUNT(*) AS click_count
+	          FROM clicks
+	          WHERE short_code IN (SELECT value FROM json_each($1)) AND clicked_at >= $2 AND clicked_at < $3 AND referer <> ''
+	          GROUP BY referer
+	          ORDER BY click_count DESC, referer
+	          LIMIT $4`
+
+	rows, err := s.db.QueryContext(ctx, query, sqliteStrings(shortCodes), from.UTC(), to.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top referrers between: %w", err)
+	}
+	defer rows.Close()
+
+	var referrers []models.Referrer
+	for rows.Next() {
+		var ref models.Referrer
+		if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+			return nil, fmt.Errorf("failed to scan referrer: %w", err)
+		}
+		referrers = append(referrers, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return referrers, nil
+}
+
+func (s *SQLiteDB) GetGroupTopReferrers(ctx context.Context, shortCodes []string, limit int) (_ []models.Referrer, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetGroupTopReferrers")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT referer, SUM(click_count) AS click_count
+	          FROM top_referrers
+	          WHERE short_code IN (SELECT value FROM json_each($1))
+	          GROUP BY referer
+	          ORDER BY click_count DESC, referer
+	          LIMIT $2`
+
+	rows, err := s.db.QueryContext(ctx, query, sqliteStrings(shortCodes), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group top referrers: %w", err)
+	}
+	defer rows.Close()
+
+	var referrers []models.Referrer
+	for rows.Next() {
+		var ref models.Referrer
+		if err := rows.Scan(&ref.Referer, &ref.ClickCount); err != nil {
+			return nil, fmt.Errorf("failed to scan referrer: %w", err)
+		}
+		referrers = append(referrers, ref)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return referrers, nil
+}
+
+func (s *SQLiteDB) UpdateLinkStats(ctx context.Context, shortCode string, totalClicks int64, uniqueVisitors int64) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "UpdateLinkStats")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO link_stats (short_code, total_clicks, unique_visitors, last_updated)
+	          VALUES ($1, $2, $3, $4)
+	          ON CONFLICT (short_code)
+	          DO UPDATE SET
+	            total_clicks = link_stats.total_clicks + excluded.total_clicks,
+	            unique_visitors = excluded.unique_visitors,
+	            last_updated = excluded.last_updated`
+
+	_, err = s.db.ExecContext(ctx, query, shortCode, totalClicks, uniqueVisitors, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to update link stats: %w", err)
+	}
+	return nil
+}
+
+// RecalculateUniqueVisitors recalculates unique visitors count from clicks table
+func (s *SQLiteDB) RecalculateUniqueVisitors(ctx context.Context, shortCode string) (_ int64, err error) {
+	ctx, span := startSQLiteSpan(ctx, "RecalculateUniqueVisitors")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code = $1`
+
+	var count int64
+	err = s.db.QueryRowContext(ctx, query, shortCode).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recalculate unique visitors: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQLiteDB) UpdateTopReferrers(ctx context.Context, shortCode string, referer string, count int64) (err error) {
+	ctx, span := startSQLiteSpan(ctx, "UpdateTopReferrers")
+	defer func() { endSpan(span, err) }()
+
+	query := `INSERT INTO top_referrers (short_code, referer, click_count)
+	          VALUES ($1, $2, $3)
+	          ON CONFLICT (short_code, referer)
+	          DO UPDATE SET click_count = top_referrers.click_count + excluded.click_count`
+
+	_, err = s.db.ExecContext(ctx, query, shortCode, referer, count)
+	if err != nil {
+		return fmt.Errorf("failed to update top referrers: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteDB) GetUniqueVisitors(ctx context.Context, shortCode string, startTime time.Time) (_ int64, err error) {
+	ctx, span := startSQLiteSpan(ctx, "GetUniqueVisitors")
+	defer func() { endSpan(span, err) }()
+
+	query := `SELECT COUNT(DISTINCT visitor_hash)
+	          FROM clicks
+	          WHERE short_code = $1 AND clicked_at >= $2`
+
+	var count int64
+	err = s.db.QueryRowContext(ctx, query, shortCode, startTime.UTC()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get unique visitors: %w", err)
+	}
+	return count, nil
+}
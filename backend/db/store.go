@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"link-analytics-service/models"
+	"time"
+)
+
+// LinkStore persists short links
+type LinkStore interface {
+	CreateLink(ctx context.Context, link *models.Link) error
+	// CreateLinks inserts links in one transaction; either all are created or none.
+	// A link without a short code, or whose code is taken, gets one from newCode.
+	CreateLinks(ctx context.Context, links []*models.Link, newCode func() string) error
+	// GetLinkByCode returns *models.NotFoundError when the code doesn't exist
+	GetLinkByCode(ctx context.Context, shortCode string) (*models.Link, error)
+	GetLinksByUser(ctx context.Context, userID string) ([]*models.Link, error)
+	// FindLinkByURL returns the user's oldest link to url, or *models.NotFoundError
+	FindLinkByURL(ctx context.Context, userID, url string) (*models.Link, error)
+	// ListLinks returns one page of a user's links with their click totals
+	ListLinks(ctx context.Context, query models.LinkListQuery) (*models.LinkPage, error)
+	// SearchLinks returns a user's links matching query, best matches first
+	SearchLinks(ctx context.Context, query models.LinkSearchQuery) ([]*models.LinkSearchResult, error)
+	GetAllLinks(ctx context.Context) ([]*models.Link, error)
+}
+
+// MetadataStore manages link titles, descriptions, tags and folders.
+// Methods taking a userID act only on that user's links and folders and return
+// *models.NotFoundError for anything else.
+type MetadataStore interface {
+	UpdateLinkMetadata(ctx context.Context, userID, shortCode string, update models.LinkMetadataUpdate) (*models.Link, error)
+	GetTags(ctx context.Context, userID string) ([]models.TagCount, error)
+	// CreateFolder returns *models.ValidationError when the parent is not the user's
+	// or a sibling has the same name
+	CreateFolder(ctx context.Context, folder *models.Folder) error
+	GetFolder(ctx context.Context, userID string, id int64) (*models.Folder, error)
+	GetFolders(ctx context.Context, userID string) ([]*models.Folder, error)
+	// UpdateFolder renames or moves a folder; it cannot move under its own subtree
+	UpdateFolder(ctx context.Context, folder *models.Folder) error
+	// DeleteFolder deletes a folder and its subfolders; their links stay, without a folder
+	DeleteFolder(ctx context.Context, userID string, id int64) error
+	// GetGroupLinkCodes returns the short codes of the links in group
+	GetGroupLinkCodes(ctx context.Context, group models.LinkGroup) ([]string, error)
+}
+
+// SettingsStore keeps per-user preferences
+type SettingsStore interface {
+	// GetUserSettings returns the defaults for a user without saved settings
+	GetUserSettings(ctx context.Context, userID string) (*models.UserSettings, error)
+	UpdateUserSettings(ctx context.Context, settings *models.UserSettings) error
+}
+
+// ClickStore persists raw click events and answers queries over them
+type ClickStore interface {
+	InsertClickEvent(ctx context.Context, event *models.ClickEvent) error
+	BatchInsertClickEvents(ctx context.Context, events []*models.ClickEvent) error
+	// GetClicksOverTime buckets the link's clicks by hour for periods up to a day.
+	// Longer periods get daily buckets over whole UTC days, from the first day that
+	// starts within the period through today.
+	GetClicksOverTime(ctx context.Context, shortCode string, period time.Duration) ([]models.TimePoint, error)
+	// GetClicksBetween is GetClicksOverTime for from <= clicked_at < to instead of the
+	// period up to now: hourly buckets when the range is at most a day, daily beyond.
+	// Daily buckets run from the first day that starts at or after from and stop
+	// before the day holding to, so every bucket is a whole day.
+	GetClicksBetween(ctx context.Context, shortCode string, from, to time.Time) ([]models.TimePoint, error)
+	GetUniqueVisitors(ctx context.Context, shortCode string, startTime time.Time) (int64, error)
+	RecalculateUniqueVisitors(ctx context.Context, shortCode string) (int64, error)
+	// ScanLinkClicks calls fn for every click on the link with from <= clicked_at < to,
+	// in time order, without loading them all at once
+	ScanLinkClicks(ctx context.Context, shortCode string, from, to time.Time, fn func(*models.ClickRecord) error) error
+	// GetGroupClicksOverTime is GetClicksOverTime summed over several links
+	GetGroupClicksOverTime(ctx context.Context, shortCodes []string, period time.Duration) ([]models.TimePoint, error)
+	// CountGroupClicks counts the clicks on several links with from <= clicked_at < to
+	// and their distinct visitors; a visitor of several of the links counts once
+	CountGroupClicks(ctx context.Context, shortCodes []string, from, to time.Time) (*models.LinkStats, error)
+	// GetTopLinks returns up to limit of the links with the most clicks with
+	// from <= clicked_at < to, with their clicks and distinct visitors in that range
+	GetTopLinks(ctx context.Context, shortCodes []string, from, to time.Time, limit int) ([]models.LinkStats, error)
+	// GetTopReferrersBetween returns up to limit of the referrers with the most clicks
+	// on the links with from <= clicked_at < to; clicks without a referrer don't count
+	GetTopReferrersBetween(ctx context.Context, shortCodes []string, from, to time.Time, limit int) ([]models.Referrer, error)
+}
+
+// StatsStore holds the per-link aggregates maintained by the analytics workers
+type StatsStore interface {
+	// GetLinkStats returns zero stats for a link without clicks
+	GetLinkStats(ctx context.Context, shortCode string) (*models.LinkStats, error)
+	// UpdateLinkStats adds totalClicks to the running total and replaces the unique visitor count
+	UpdateLinkStats(ctx context.Context, shortCode string, totalClicks int64, uniqueVisitors int64) error
+	GetTopReferrers(ctx context.Context, shortCode string, limit int) ([]models.Referrer, error)
+	// UpdateTopReferrers adds count to the referrer's running total
+	UpdateTopReferrers(ctx context.Context, shortCode string, referer string, count int64) error
+	// GetGroupStats totals clicks over several links; a visitor of several of them counts once
+	GetGroupStats(ctx context.Context, shortCodes []string) (*models.LinkStats, error)
+	// GetGroupTopReferrers is GetTopReferrers summed over several links
+	GetGroupTopReferrers(ctx context.Context, shortCodes []string, limit int) ([]models.Referrer, error)
+}
+
+// Store is everything the service needs from its primary database
+type Store interface {
+	LinkStore
+	MetadataStore
+	SettingsStore
+	ClickStore
+	StatsStore
+	Ping(ctx context.Context) error
+}
+
+// Database is a Store that owns its connection and schema
+type Database interface {
+	Store
+	Migrate(ctx context.Context) (int, error)
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	Close() error
+}
+
+// ClickPartitioner is implemented by stores that keep clicks in monthly partitions
+type ClickPartitioner interface {
+	// EnsureClickPartitions creates partitions for the current month and monthsAhead more
+	EnsureClickPartitions(ctx context.Context, now time.Time, monthsAhead int) (int, error)
+	// ExpireClickPartitions drops, or with detach detaches, partitions entirely before cutoff
+	ExpireClickPartitions(ctx context.Context, cutoff time.Time, detach bool) ([]string, error)
+}
+
+// ClickArchiver is implemented by stores whose expired clicks can be archived and restored
+type ClickArchiver interface {
+	DetachedClickPartitions(ctx context.Context) ([]ClickPartition, error)
+	ScanClickPartition(ctx context.Context, partition string, fn func(*models.ClickRecord) error) error
+	ScanClicks(ctx context.Context, from, to time.Time, fn func(*models.ClickRecord) error) error
+	DropClickPartition(ctx context.Context, partition string) error
+	// WithPartitionLock runs fn unless another instance is maintaining partitions,
+	// reporting whether it ran
+	WithPartitionLock(ctx context.Context, fn func() error) (bool, error)
+	// RestoreClicks loads records into a new table until next returns nil
+	RestoreClicks(ctx context.Context, table string, next func() (*models.ClickRecord, error)) (int64, error)
+}
+
+// Cache is the shared key-value store used for link caching, counters,
+// rate limiting and pub/sub between instances
+type Cache interface {
+	// Get returns an error when the key doesn't exist
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetNX sets key only if it does not exist, reporting whether it did
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Incr increments a counter; a new counter expires after 60 seconds
+	Incr(ctx context.Context, key string) (int64, error)
+	// IncrWithTTL increments a counter; a new counter expires after ttl
+	IncrWithTTL(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// IncrByWithTTL adds n, which may be negative, to a counter; a new counter expires after ttl
+	IncrByWithTTL(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	Delete(ctx context.Context, key string) error
+	// GetInt returns 0 when the key doesn't exist
+	GetInt(ctx context.Context, key string) (int64, error)
+	Publish(ctx context.Context, channel string, message []byte) error
+	// Subscribe delivers messages on channel to handler until ctx is cancelled
+	Subscribe(ctx context.Context, channel string, handler func([]byte)) error
+	Ping(ctx context.Context) error
+}
+
+var (
+	_ Database         = (*PostgresDB)(nil)
+	_ Database         = (*SQLiteDB)(nil)
+	_ ClickPartitioner = (*PostgresDB)(nil)
+	_ ClickArchiver    = (*PostgresDB)(nil)
+	_ Cache            = (*RedisDB)(nil)
+	_ Store            = (*MemoryStore)(nil)
+	_ Cache            = (*MemoryCache)(nil)
+)
+
+// firstWholeDay returns the first UTC midnight at or after t, where a daily click
+// series starting at t begins
+func firstWholeDay(t time.Time) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	if day.Before(t) {
+		day = day.Add(24 * time.Hour)
+	}
+	return day
+}
+
+// dailyRange narrows from and to to the whole UTC days between them
+func dailyRange(from, to time.Time) (time.Time, time.Time) {
+	return firstWholeDay(from), to.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"link-analytics-service/models"
+	"link-analytics-service/tracing"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startPostgresSpan starts a client span for a PostgresDB method.
+// Query text and arguments are not recorded since they contain visitor data.
+func startPostgresSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.Tracer.Start(ctx, "postgres."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation)))
+}
+
+// startSQLiteSpan is startPostgresSpan for a SQLiteDB method
+func startSQLiteSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracing.Tracer.Start(ctx, "sqlite."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBOperationName(operation)))
+}
+
+// endSpan ends a database span, treating "not found" as a normal outcome
+func endSpan(span trace.Span, err error) {
+	var notFound *models.NotFoundError
+	if errors.As(err, &notFound) || errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// redisTracingHook creates a client span for every Redis command and pipeline.
+// Keys are not recorded since rate limit keys contain client IPs.
+type redisTracingHook struct{}
+
+func (redisTracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (redisTracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracing.Tracer.Start(ctx, "redis."+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd.Name())))
+		err := next(ctx, cmd)
+		endSpan(span, err)
+		return err
+	}
+}
+
+func (redisTracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := tracing.Tracer.Start(ctx, "redis.pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, attribute.Int("db.redis.pipeline_length", len(cmds))))
+		err := next(ctx, cmds)
+		endSpan(span, err)
+		return err
+	}
+}
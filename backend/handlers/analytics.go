@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"link-analytics-service/db"
 	"link-analytics-service/models"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -20,62 +24,44 @@ type AnalyticsResponse struct {
 	TopReferrers   []models.Referrer     `json:"top_referrers"`
 	ClickRate      float64               `json:"click_rate"`      // Clicks per hour/day based on period
 	PeakHour       *models.TimePoint     `json:"peak_hour"`      // Hour/day with most clicks
+	// Comparison with the period of the same length before this one
+	Comparison *PeriodComparison `json:"comparison"`
+	// The previous period's buckets, moved forward by the period to line up with ClicksOverTime
+	PreviousClicksOverTime []models.TimePoint `json:"previous_clicks_over_time"`
+	Anomaly                bool               `json:"anomaly"` // set when Spikes is not empty
+	Spikes                 []models.TimePoint `json:"spikes"`  // buckets far above the previous period's
+	SpikeCheck             string             `json:"spike_check"`
 }
 
-// SSEBroker manages Server-Sent Events connections
-type SSEBroker struct {
-	clients map[string]map[chan []byte]bool
-	mu      sync.RWMutex
-}
-
-func NewSSEBroker() *SSEBroker {
-	return &SSEBroker{
-		clients: make(map[string]map[chan []byte]bool),
-	}
-}
-
-func (b *SSEBroker) AddClient(shortCode string, ch chan []byte) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	if b.clients[shortCode] == nil {
-		b.clients[shortCode] = make(map[chan []byte]bool)
-	}
-	b.clients[shortCode][ch] = true
-}
-
-func (b *SSEBroker) RemoveClient(shortCode string, ch chan []byte) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	if clients, ok := b.clients[shortCode]; ok {
-		delete(clients, ch)
-		if len(clients) == 0 {
-			delete(b.clients, shortCode)
-		}
-	}
-}
+// Outcomes of the spike check. Spikes are only looked for against a previous
+// period that could be read and had clicks; otherwise Spikes stays empty.
+const (
+	SpikeCheckDone        = "checked"
+	SpikeCheckNoBaseline  = "no_baseline" // no clicks in the previous period
+	SpikeCheckUnavailable = "unavailable" // the previous period could not be read
+)
 
-func (b *SSEBroker) Broadcast(shortCode string, data []byte) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
+// Trends of a period's clicks against the previous period
+const (
+	TrendUp   = "up"
+	TrendDown = "down"
+	TrendFlat = "flat"
+)
 
-	if clients, ok := b.clients[shortCode]; ok {
-		for ch := range clients {
-			select {
-			case ch <- data:
-			default:
-				// Channel is full, skip this client
-			}
-		}
-	}
-}
+const (
+	// Percent change in clicks beyond which a period trends up or down
+	trendThreshold = 10
+	// A bucket is a spike when it has at least spikeMinClicks clicks and more than
+	// spikeDeviations standard deviations above the previous period's mean bucket
+	spikeMinClicks  = 10
+	spikeDeviations = 3
+)
 
 // GetAnalytics handles GET /api/analytics/{short_code}?period=24h|7d|30d
-func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
+func GetAnalytics(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
@@ -88,32 +74,20 @@ func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
 		} else if len(pathParts) >= 2 && pathParts[0] == "analytics" {
 			shortCode = pathParts[1]
 		} else {
-			http.Error(w, "Short code required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Short code required", nil)
 			return
 		}
 
-		periodStr := r.URL.Query().Get("period")
-		if periodStr == "" {
-			periodStr = "24h"
-		}
-
-		var period time.Duration
-		switch periodStr {
-		case "24h":
-			period = 24 * time.Hour
-		case "7d":
-			period = 7 * 24 * time.Hour
-		case "30d":
-			period = 30 * 24 * time.Hour
-		default:
-			http.Error(w, "Invalid period. Use 24h, 7d, or 30d", http.StatusBadRequest)
+		period, err := parseAnalyticsPeriod(r.URL.Query().Get("period"))
+		if err != nil {
+			writeErrorFrom(w, r, err)
 			return
 		}
 
 		// Get overall stats
-		stats, err := pgDB.GetLinkStats(r.Context(), shortCode)
+		stats, err := store.GetLinkStats(r.Context(), shortCode)
 		if err != nil {
-			log.Printf("Error getting stats: %v", err)
+			slog.ErrorContext(r.Context(), "failed to get link stats", "short_code", shortCode, "error", err)
 			stats = &models.LinkStats{
 				ShortCode:      shortCode,
 				TotalClicks:    0,
@@ -123,23 +97,44 @@ func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
 
 		// Recalculate unique visitors for accuracy (in case worker hasn't updated yet)
 		if stats.TotalClicks > 0 {
-			uniqueVisitors, err := pgDB.RecalculateUniqueVisitors(r.Context(), shortCode)
+			uniqueVisitors, err := store.RecalculateUniqueVisitors(r.Context(), shortCode)
 			if err == nil {
 				stats.UniqueVisitors = uniqueVisitors
 			}
 		}
 
 		// Get clicks over time
-		clicksOverTime, err := pgDB.GetClicksOverTime(r.Context(), shortCode, period)
+		clicksOverTime, err := store.GetClicksOverTime(r.Context(), shortCode, period)
 		if err != nil {
-			log.Printf("Error getting clicks over time: %v", err)
+			slog.ErrorContext(r.Context(), "failed to get clicks over time", "short_code", shortCode, "error", err)
 			clicksOverTime = []models.TimePoint{}
 		}
 
+		// Compare with the previous period
+		now := time.Now()
+		comparison, err := comparePeriods(r.Context(), store, []string{shortCode}, period, now)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to compare with previous period", "short_code", shortCode, "error", err)
+		}
+		spikes, spikeCheck := []models.TimePoint{}, SpikeCheckDone
+		previousClicks, err := store.GetClicksBetween(r.Context(), shortCode, now.Add(-2*period), now.Add(-period))
+		switch {
+		case err != nil:
+			slog.ErrorContext(r.Context(), "failed to get previous clicks over time", "short_code", shortCode, "error", err)
+			spikeCheck = SpikeCheckUnavailable
+		case len(previousClicks) == 0:
+			spikeCheck = SpikeCheckNoBaseline
+		default:
+			spikes = findSpikes(clicksOverTime, previousClicks, period)
+		}
+		for i := range previousClicks {
+			previousClicks[i].Timestamp = previousClicks[i].Timestamp.Add(period)
+		}
+
 		// Get top referrers
-		topReferrers, err := pgDB.GetTopReferrers(r.Context(), shortCode, 10)
+		topReferrers, err := store.GetTopReferrers(r.Context(), shortCode, 10)
 		if err != nil {
-			log.Printf("Error getting top referrers: %v", err)
+			slog.ErrorContext(r.Context(), "failed to get top referrers", "short_code", shortCode, "error", err)
 			topReferrers = []models.Referrer{}
 		}
 
@@ -150,35 +145,8 @@ func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
 		if topReferrers == nil {
 			topReferrers = []models.Referrer{}
 		}
-
-		// Calculate click rate (clicks per hour or per day based on period)
-		var clickRate float64
-		if len(clicksOverTime) > 0 {
-			if period <= 24*time.Hour {
-				// Clicks per hour
-				hours := float64(period.Hours())
-				if hours > 0 {
-					clickRate = float64(stats.TotalClicks) / hours
-				}
-			} else {
-				// Clicks per day
-				days := float64(period.Hours()) / 24.0
-				if days > 0 {
-					clickRate = float64(stats.TotalClicks) / days
-				}
-			}
-		}
-
-		// Find peak hour/day (time bucket with most clicks)
-		var peakHour *models.TimePoint
-		if len(clicksOverTime) > 0 {
-			maxCount := int64(0)
-			for i := range clicksOverTime {
-				if clicksOverTime[i].Count > maxCount {
-					maxCount = clicksOverTime[i].Count
-					peakHour = &clicksOverTime[i]
-				}
-			}
+		if previousClicks == nil {
+			previousClicks = []models.TimePoint{}
 		}
 
 		response := AnalyticsResponse{
@@ -187,8 +155,240 @@ func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
 			UniqueVisitors: stats.UniqueVisitors,
 			ClicksOverTime: clicksOverTime,
 			TopReferrers:   topReferrers,
-			ClickRate:      clickRate,
-			PeakHour:       peakHour,
+			ClickRate:      clickRate(stats.TotalClicks, period, clicksOverTime),
+			PeakHour:       peakBucket(clicksOverTime),
+
+			Comparison:             comparison,
+			PreviousClicksOverTime: previousClicks,
+			Anomaly:                len(spikes) > 0,
+			Spikes:                 spikes,
+			SpikeCheck:             spikeCheck,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// GroupAnalyticsResponse aggregates the analytics of a group of links
+type GroupAnalyticsResponse struct {
+	Tag            string             `json:"tag,omitempty"`
+	FolderID       int64              `json:"folder_id,omitempty"`
+	Links          int                `json:"links"` // links in the group
+	TotalClicks    int64              `json:"total_clicks"`
+	UniqueVisitors int64              `json:"unique_visitors"` // a visitor of several links counts once
+	ClicksOverTime []models.TimePoint `json:"clicks_over_time"`
+	TopReferrers   []models.Referrer  `json:"top_referrers"`
+	ClickRate      float64            `json:"click_rate"`
+	PeakHour       *models.TimePoint  `json:"peak_hour"`
+}
+
+// GetGroupAnalytics handles GET /api/analytics?user_id=...&tag=...|folder_id=...&period=24h|7d|30d,
+// the analytics of all the user's links with a tag, or in a folder and its subfolders
+func GetGroupAnalytics(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		group := models.LinkGroup{UserID: params.Get("user_id")}
+		if group.UserID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+		tag, folder := params.Get("tag"), params.Get("folder_id")
+		var err error
+		switch {
+		case tag != "" && folder != "", tag == "" && folder == "":
+			err = &models.ValidationError{Message: "exactly one of tag or folder_id is required"}
+		case tag != "":
+			group.Tag, err = normalizeTag(tag)
+		default:
+			if group.FolderID, err = strconv.ParseInt(folder, 10, 64); err != nil || group.FolderID < 1 {
+				err = &models.ValidationError{Message: "folder_id must be a folder id"}
+			}
+		}
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		period, err := parseAnalyticsPeriod(params.Get("period"))
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		codes, err := store.GetGroupLinkCodes(ctx, group)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		response := GroupAnalyticsResponse{
+			Tag:            group.Tag,
+			FolderID:       group.FolderID,
+			Links:          len(codes),
+			ClicksOverTime: []models.TimePoint{},
+			TopReferrers:   []models.Referrer{},
+		}
+		if len(codes) > 0 {
+			stats, err := store.GetGroupStats(ctx, codes)
+			if err != nil {
+				writeErrorFrom(w, r, err)
+				return
+			}
+			response.TotalClicks, response.UniqueVisitors = stats.TotalClicks, stats.UniqueVisitors
+
+			if points, err := store.GetGroupClicksOverTime(ctx, codes, period); err != nil {
+				slog.ErrorContext(ctx, "failed to get group clicks over time", "links", len(codes), "error", err)
+			} else if points != nil {
+				response.ClicksOverTime = points
+			}
+			if referrers, err := store.GetGroupTopReferrers(ctx, codes, 10); err != nil {
+				slog.ErrorContext(ctx, "failed to get group top referrers", "links", len(codes), "error", err)
+			} else if referrers != nil {
+				response.TopReferrers = referrers
+			}
+			response.ClickRate = clickRate(response.TotalClicks, period, response.ClicksOverTime)
+			response.PeakHour = peakBucket(response.ClicksOverTime)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Links listed in an analytics overview
+const overviewTopLinks = 10
+
+// OverviewResponse is the analytics of all of a user's links
+type OverviewResponse struct {
+	UserID         string             `json:"user_id"`
+	Period         string             `json:"period"`
+	Links          int                `json:"links"`
+	TotalClicks    int64              `json:"total_clicks"`    // all time
+	UniqueVisitors int64              `json:"unique_visitors"` // all time; a visitor of several links counts once
+	ClicksOverTime []models.TimePoint `json:"clicks_over_time"`
+	TopLinks       []OverviewLink     `json:"top_links"`     // most clicked in the period
+	TopReferrers   []models.Referrer  `json:"top_referrers"` // most clicks in the period
+	ClickRate      float64            `json:"click_rate"`    // of the clicks in the period
+	PeakHour       *models.TimePoint  `json:"peak_hour"`
+	Change         *PeriodComparison  `json:"change"`
+}
+
+// OverviewLink is one of the most clicked links of an overview
+type OverviewLink struct {
+	ShortCode      string `json:"short_code"`
+	OriginalURL    string `json:"original_url"`
+	Clicks         int64  `json:"clicks"`          // in the period
+	UniqueVisitors int64  `json:"unique_visitors"` // in the period
+}
+
+// PeriodComparison compares the clicks in a period with those in the period of
+// the same length just before it
+type PeriodComparison struct {
+	Clicks                 int64    `json:"clicks"`
+	UniqueVisitors         int64    `json:"unique_visitors"`
+	PreviousClicks         int64    `json:"previous_clicks"`
+	PreviousUniqueVisitors int64    `json:"previous_unique_visitors"`
+	ClicksDelta            int64    `json:"clicks_delta"`
+	UniqueVisitorsDelta    int64    `json:"unique_visitors_delta"`
+	ClicksChange           *float64 `json:"clicks_change"` // percent, null when the previous period had none
+	UniqueVisitorsChange   *float64 `json:"unique_visitors_change"`
+	Trend                  string   `json:"trend"` // TrendUp, TrendDown or TrendFlat, by clicks
+}
+
+// GetAnalyticsOverview handles GET /api/analytics/overview?user_id=...&period=24h|7d|30d,
+// the analytics of all the user's links in one response
+func GetAnalyticsOverview(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		userID := params.Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+		periodName := params.Get("period")
+		period, err := parseAnalyticsPeriod(periodName)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		if periodName == "" {
+			periodName = "24h"
+		}
+
+		ctx := r.Context()
+		links, err := store.GetLinksByUser(ctx, userID)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		byCode := make(map[string]*models.Link, len(links))
+		codes := make([]string, 0, len(links))
+		for _, link := range links {
+			byCode[link.ShortCode] = link
+			codes = append(codes, link.ShortCode)
+		}
+
+		response := OverviewResponse{
+			UserID:         userID,
+			Period:         periodName,
+			Links:          len(codes),
+			ClicksOverTime: []models.TimePoint{},
+			TopLinks:       []OverviewLink{},
+			TopReferrers:   []models.Referrer{},
+			Change:         &PeriodComparison{Trend: TrendFlat},
+		}
+		if len(codes) > 0 {
+			stats, err := store.GetGroupStats(ctx, codes)
+			if err != nil {
+				writeErrorFrom(w, r, err)
+				return
+			}
+			response.TotalClicks, response.UniqueVisitors = stats.TotalClicks, stats.UniqueVisitors
+
+			now := time.Now()
+			if response.Change, err = comparePeriods(ctx, store, codes, period, now); err != nil {
+				writeErrorFrom(w, r, err)
+				return
+			}
+			top, err := store.GetTopLinks(ctx, codes, now.Add(-period), now, overviewTopLinks)
+			if err != nil {
+				writeErrorFrom(w, r, err)
+				return
+			}
+			for _, t := range top {
+				link := byCode[t.ShortCode]
+				response.TopLinks = append(response.TopLinks, OverviewLink{
+					ShortCode:      t.ShortCode,
+					OriginalURL:    link.OriginalURL,
+					Clicks:         t.TotalClicks,
+					UniqueVisitors: t.UniqueVisitors,
+				})
+			}
+
+			if points, err := store.GetGroupClicksOverTime(ctx, codes, period); err != nil {
+				slog.ErrorContext(ctx, "failed to get overview clicks over time", "user_id", userID, "error", err)
+			} else if points != nil {
+				response.ClicksOverTime = points
+			}
+			if referrers, err := store.GetTopReferrersBetween(ctx, codes, now.Add(-period), now, 10); err != nil {
+				slog.ErrorContext(ctx, "failed to get overview top referrers", "user_id", userID, "error", err)
+			} else if referrers != nil {
+				response.TopReferrers = referrers
+			}
+			response.ClickRate = clickRate(response.Change.Clicks, period, response.ClicksOverTime)
+			response.PeakHour = peakBucket(response.ClicksOverTime)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -196,8 +396,130 @@ func GetAnalytics(pgDB *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
-// StreamAnalytics handles GET /api/analytics/{short_code}/stream (SSE)
-func StreamAnalytics(pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *SSEBroker) http.HandlerFunc {
+// comparePeriods compares the clicks on the links in the period up to now with
+// the period before it
+func comparePeriods(ctx context.Context, store db.Store, shortCodes []string, period time.Duration, now time.Time) (*PeriodComparison, error) {
+	current, err := store.CountGroupClicks(ctx, shortCodes, now.Add(-period), now)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := store.CountGroupClicks(ctx, shortCodes, now.Add(-2*period), now.Add(-period))
+	if err != nil {
+		return nil, err
+	}
+	comparison := &PeriodComparison{
+		Clicks:                 current.TotalClicks,
+		UniqueVisitors:         current.UniqueVisitors,
+		PreviousClicks:         previous.TotalClicks,
+		PreviousUniqueVisitors: previous.UniqueVisitors,
+		ClicksDelta:            current.TotalClicks - previous.TotalClicks,
+		UniqueVisitorsDelta:    current.UniqueVisitors - previous.UniqueVisitors,
+		ClicksChange:           percentChange(current.TotalClicks, previous.TotalClicks),
+		UniqueVisitorsChange:   percentChange(current.UniqueVisitors, previous.UniqueVisitors),
+		Trend:                  TrendFlat,
+	}
+	switch change := comparison.ClicksChange; {
+	case change == nil && current.TotalClicks > 0, change != nil && *change >= trendThreshold:
+		comparison.Trend = TrendUp
+	case change != nil && *change <= -trendThreshold:
+		comparison.Trend = TrendDown
+	}
+	return comparison, nil
+}
+
+// percentChange is the change from previous to current in percent, rounded to
+// one decimal, or nil when previous is 0
+func percentChange(current, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &change
+}
+
+// parseAnalyticsPeriod parses the period parameter, 24h when empty
+func parseAnalyticsPeriod(v string) (time.Duration, error) {
+	switch v {
+	case "", "24h":
+		return 24 * time.Hour, nil
+	case "7d":
+		return 7 * 24 * time.Hour, nil
+	case "30d":
+		return 30 * 24 * time.Hour, nil
+	default:
+		return 0, &models.ValidationError{Message: "Invalid period. Use 24h, 7d, or 30d"}
+	}
+}
+
+// clickRate is clicks per hour for periods up to a day and clicks per day beyond,
+// or 0 when there were no clicks in the period
+func clickRate(totalClicks int64, period time.Duration, clicksOverTime []models.TimePoint) float64 {
+	if len(clicksOverTime) == 0 || period <= 0 {
+		return 0
+	}
+	if period <= 24*time.Hour {
+		return float64(totalClicks) / period.Hours()
+	}
+	return float64(totalClicks) / (period.Hours() / 24.0)
+}
+
+// peakBucket returns the hour or day with the most clicks, nil without clicks
+func peakBucket(clicksOverTime []models.TimePoint) *models.TimePoint {
+	var peak *models.TimePoint
+	maxCount := int64(0)
+	for i := range clicksOverTime {
+		if clicksOverTime[i].Count > maxCount {
+			maxCount = clicksOverTime[i].Count
+			peak = &clicksOverTime[i]
+		}
+	}
+	return peak
+}
+
+// findSpikes returns the buckets of current far above those of previous, the
+// series of the period before. Buckets missing from previous count as empty;
+// previous should have at least one, or every busy bucket is a spike.
+func findSpikes(current, previous []models.TimePoint, period time.Duration) []models.TimePoint {
+	// A daily previous series holds the whole days of its period, one fewer than it spans
+	buckets := period.Hours()/24 - 1
+	if period <= 24*time.Hour {
+		buckets = period.Hours()
+	}
+	var sum, sumSquares float64
+	for _, point := range previous {
+		count := float64(point.Count)
+		sum += count
+		sumSquares += count * count
+	}
+	mean := sum / buckets
+	stddev := math.Sqrt(max(sumSquares/buckets-mean*mean, 0))
+
+	spikes := []models.TimePoint{}
+	for _, point := range current {
+		if point.Count >= spikeMinClicks && float64(point.Count) > mean+spikeDeviations*stddev {
+			spikes = append(spikes, point)
+		}
+	}
+	return spikes
+}
+
+// maxStreamCodes caps how many short codes one stream connection can follow
+const maxStreamCodes = 500
+
+// streamWriteTimeout bounds each write to an analytics stream. It replaces the
+// server's WriteTimeout, which would otherwise end every stream after a few seconds.
+const streamWriteTimeout = 10 * time.Second
+
+// StreamAnalytics handles the analytics SSE streams:
+//
+//	GET /api/analytics/{short_code}/stream
+//	GET /api/analytics/stream?codes=abc123,def456
+//	GET /api/analytics/stream?user_id=user123 (all of the user's links)
+//
+// Events carry an id: field; reconnecting clients send it back as Last-Event-ID
+// (or ?last_event_id=) to receive buffered events they missed.
+// Adding ?click_feed=true also streams per-click events when the feed is enabled.
+func StreamAnalytics(store db.Store, cache db.Cache, broker *SSEBroker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Handle OPTIONS for CORS preflight
 		if r.Method == http.MethodOptions {
@@ -206,30 +528,26 @@ func StreamAnalytics(pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *SSEBroker
 		}
 
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
-		// Extract short code from path like /api/analytics/{shortCode}/stream
-		// The router strips /api, so the path is /analytics/{shortCode}/stream
-		path := strings.TrimPrefix(r.URL.Path, "/api")
-		path = strings.TrimPrefix(path, "/analytics/")
-		path = strings.TrimSuffix(path, "/stream")
-		path = strings.Trim(path, "/")
-		
-		shortCode := path
-		if shortCode == "" {
-			log.Printf("StreamAnalytics: empty short code from path: %s", r.URL.Path)
-			http.Error(w, "Short code required", http.StatusBadRequest)
+		ctx := r.Context()
+
+		shortCodes, err := streamShortCodes(ctx, store, r)
+		if err != nil {
+			writeErrorFrom(w, r, err)
 			return
 		}
-		
-		// Remove any query parameters
-		if idx := strings.Index(shortCode, "?"); idx != -1 {
-			shortCode = shortCode[:idx]
+
+		var lastEventID uint64
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			lastEventID, _ = strconv.ParseUint(v, 10, 64)
+		} else if v := r.URL.Query().Get("last_event_id"); v != "" {
+			lastEventID, _ = strconv.ParseUint(v, 10, 64)
 		}
 
-		log.Printf("StreamAnalytics: starting stream for short code: %s", shortCode)
+		slog.InfoContext(ctx, "starting analytics stream", "codes", len(shortCodes), "last_event_id", lastEventID)
 
 		// Set SSE headers
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -247,38 +565,47 @@ func StreamAnalytics(pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *SSEBroker
 		}
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-		// Create client channel
-		clientChan := make(chan []byte, 10)
-		broker.AddClient(shortCode, clientChan)
-		defer broker.RemoveClient(shortCode, clientChan)
-
 		// Verify we can flush (required for SSE)
 		flusher, ok := w.(http.Flusher)
 		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Streaming not supported", nil)
 			return
 		}
 
-		// Send initial count
-		ctx := r.Context()
-		counterKey := "clicks:realtime:" + shortCode
-		count, _ := redisDB.GetInt(ctx, counterKey)
-		stats, _ := pgDB.GetLinkStats(ctx, shortCode)
-		if stats != nil {
-			count = stats.TotalClicks
-		}
+		rc := http.NewResponseController(w)
+		extendDeadline := func() { rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout)) }
 
-		initialData := map[string]interface{}{
-			"short_code":   shortCode,
-			"timestamp":    time.Now().UTC().Format(time.RFC3339),
-			"total_clicks": count,
+		// Subscribe before reading snapshots so no update falls in between
+		sub := broker.NewSubscriber()
+		if r.URL.Query().Get("click_feed") == "true" {
+			broker.SetClickFeed(sub, true)
 		}
-		if data, err := json.Marshal(initialData); err == nil {
-			fmt.Fprintf(w, "data: %s\n\n", string(data))
-			flusher.Flush()
-		} else {
-			log.Printf("Error marshaling initial data: %v", err)
+		missed, incomplete := broker.Subscribe(sub, shortCodes, lastEventID)
+		defer broker.Unsubscribe(sub)
+
+		// Fresh connections get a snapshot of every code; resumed connections only
+		// for codes whose replay buffer no longer covers the gap
+		snapshotCodes := shortCodes
+		if lastEventID != 0 {
+			snapshotCodes = incomplete
+		}
+		extendDeadline()
+		for _, shortCode := range snapshotCodes {
+			data, err := json.Marshal(currentTotals(ctx, store, cache, shortCode))
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to marshal snapshot", "short_code", shortCode, "error", err)
+				continue
+			}
+			if err := writeSSEEvent(w, SSEEvent{Type: EventSnapshot, ShortCode: shortCode, Data: data}); err != nil {
+				return
+			}
+		}
+		for _, ev := range missed {
+			if err := writeSSEEvent(w, ev); err != nil {
+				return
+			}
 		}
+		flusher.Flush()
 
 		// Heartbeat ticker
 		ticker := time.NewTicker(30 * time.Second)
@@ -286,23 +613,107 @@ func StreamAnalytics(pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *SSEBroker
 
 		for {
 			select {
-			case msg := <-clientChan:
-				if _, err := fmt.Fprintf(w, "data: %s\n\n", string(msg)); err != nil {
-					log.Printf("Error writing SSE message: %v", err)
+			case ev := <-sub.Events:
+				extendDeadline()
+				if err := writeSSEEvent(w, ev); err != nil {
+					slog.DebugContext(ctx, "analytics stream closed", "error", err)
 					return
 				}
 				flusher.Flush()
 			case <-ticker.C:
 				// Send heartbeat
+				extendDeadline()
 				if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
-					log.Printf("Error writing heartbeat: %v", err)
+					slog.DebugContext(ctx, "analytics stream closed", "error", err)
 					return
 				}
 				flusher.Flush()
-			case <-r.Context().Done():
+			case <-sub.Done():
+				// Fell too far behind; the client reconnects and resumes via Last-Event-ID
+				return
+			case <-ctx.Done():
 				return
 			}
 		}
 	}
 }
 
+// streamShortCodes determines which short codes a stream request subscribes to
+func streamShortCodes(ctx context.Context, store db.Store, r *http.Request) ([]string, error) {
+	// The router strips /api, so the path is /analytics/{shortCode}/stream or /analytics/stream
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	path = strings.TrimPrefix(path, "/analytics")
+	path = strings.TrimSuffix(path, "/stream")
+	path = strings.Trim(path, "/")
+
+	if path != "" {
+		return []string{path}, nil
+	}
+
+	codes, err := queryShortCodes(ctx, store, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, &models.ValidationError{Message: "short code required"}
+	}
+	return codes, nil
+}
+
+// queryShortCodes collects short codes from the codes= and user_id= query parameters
+func queryShortCodes(ctx context.Context, store db.Store, query url.Values) ([]string, error) {
+	var codes []string
+	if userID := query.Get("user_id"); userID != "" {
+		links, err := store.GetLinksByUser(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load links for user: %w", err)
+		}
+		for _, link := range links {
+			codes = append(codes, link.ShortCode)
+		}
+	}
+
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		seen[code] = true
+	}
+	for _, code := range strings.Split(query.Get("codes"), ",") {
+		code = strings.TrimSpace(code)
+		if code != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	if len(codes) > maxStreamCodes {
+		return nil, &models.ValidationError{Message: fmt.Sprintf("too many short codes (max %d)", maxStreamCodes)}
+	}
+	return codes, nil
+}
+
+// currentTotals builds the snapshot payload for a short code
+func currentTotals(ctx context.Context, store db.Store, cache db.Cache, shortCode string) map[string]interface{} {
+	counterKey := "clicks:realtime:" + shortCode
+	count, _ := cache.GetInt(ctx, counterKey)
+	stats, _ := store.GetLinkStats(ctx, shortCode)
+	if stats != nil {
+		count = stats.TotalClicks
+	}
+
+	return map[string]interface{}{
+		"short_code":   shortCode,
+		"timestamp":    time.Now().UTC().Format(time.RFC3339),
+		"total_clicks": count,
+	}
+}
+
+// writeSSEEvent writes one event in text/event-stream format (without flushing)
+func writeSSEEvent(w io.Writer, ev SSEEvent) error {
+	if ev.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, ev.Data)
+	return err
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyticsOverview(t *testing.T) {
+	api := newTestAPI(t)
+	a := api.createLink(t, "user1", testDestA).ShortCode
+	b := api.createLink(t, "user1", testDestB).ShortCode
+	other := api.createLink(t, "user2", testDestA).ShortCode
+	now := time.Now()
+	api.click(t, a, now.Add(-time.Hour), "v1", "https://twitter.com", 2)
+	api.click(t, b, now.Add(-time.Hour), "v1", "", 5)
+	api.click(t, b, now.Add(-2*24*time.Hour), "v2", "https://news.ycombinator.com", 1)
+	api.click(t, other, now.Add(-time.Hour), "v9", "", 50)
+
+	rec := api.do(t, http.MethodGet, "/analytics/overview?user_id=user1", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("overview: status %d: %s", rec.Code, rec.Body)
+	}
+	resp := decode[OverviewResponse](t, rec)
+	if resp.Links != 2 || resp.TotalClicks != 8 || resp.UniqueVisitors != 2 {
+		t.Errorf("links %d, total clicks %d, visitors %d, want 2, 8 and 2", resp.Links, resp.TotalClicks, resp.UniqueVisitors)
+	}
+	if len(resp.TopLinks) != 2 || resp.TopLinks[0].ShortCode != b || resp.TopLinks[0].Clicks != 5 || resp.TopLinks[1].ShortCode != a {
+		t.Errorf("top links = %+v", resp.TopLinks)
+	}
+	if c := resp.Change; c.Clicks != 7 || c.UniqueVisitors != 1 || c.PreviousClicks != 0 || c.ClicksChange != nil || c.Trend != TrendUp {
+		t.Errorf("change = %+v", c)
+	}
+	// Referrers count only the period's clicks
+	if refs := resp.TopReferrers; len(refs) != 1 || refs[0].Referer != "https://twitter.com" || refs[0].ClickCount != 2 {
+		t.Errorf("top referrers = %+v", refs)
+	}
+
+	empty := decode[OverviewResponse](t, api.do(t, http.MethodGet, "/analytics/overview?user_id=nobody", "", nil))
+	if empty.Links != 0 || empty.TopLinks == nil || empty.Change == nil || empty.Change.Trend != TrendFlat {
+		t.Errorf("overview without links = %+v", empty)
+	}
+}
+
+func TestGetAnalytics(t *testing.T) {
+	api := newTestAPI(t)
+	code := api.createLink(t, "user1", testDestA).ShortCode
+	now := time.Now()
+	api.click(t, code, now.Add(-2*time.Hour), "v1", "https://twitter.com", 3)
+	api.click(t, code, now.Add(-90*time.Minute), "v2", "", 1)
+	api.click(t, code, now.Add(-30*time.Hour), "v3", "https://twitter.com", 2) // previous period
+
+	rec := api.do(t, http.MethodGet, "/analytics/"+code+"?period=24h", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get analytics: status %d: %s", rec.Code, rec.Body)
+	}
+	resp := decode[AnalyticsResponse](t, rec)
+
+	if resp.TotalClicks != 6 || resp.UniqueVisitors != 3 {
+		t.Errorf("totals = %d clicks, %d visitors, want 6 and 3", resp.TotalClicks, resp.UniqueVisitors)
+	}
+	var inPeriod int64
+	for _, point := range resp.ClicksOverTime {
+		inPeriod += point.Count
+	}
+	if inPeriod != 4 {
+		t.Errorf("clicks over time sum to %d, want 4", inPeriod)
+	}
+	if len(resp.TopReferrers) != 1 || resp.TopReferrers[0].ClickCount != 5 {
+		t.Errorf("top referrers = %+v", resp.TopReferrers)
+	}
+
+	c := resp.Comparison
+	if c == nil || c.Clicks != 4 || c.PreviousClicks != 2 || c.ClicksDelta != 2 || c.ClicksChange == nil || *c.ClicksChange != 100 || c.Trend != TrendUp {
+		t.Errorf("comparison = %+v", c)
+	}
+	if len(resp.PreviousClicksOverTime) != 1 {
+		t.Fatalf("previous series = %+v", resp.PreviousClicksOverTime)
+	}
+	// The previous series is shifted to line up with the current one
+	if got, want := resp.PreviousClicksOverTime[0].Timestamp, now.Add(-6*time.Hour).UTC().Truncate(time.Hour); !got.Equal(want) {
+		t.Errorf("previous bucket at %v, want %v", got, want)
+	}
+	if resp.Anomaly || len(resp.Spikes) != 0 {
+		t.Errorf("unexpected spikes %+v", resp.Spikes)
+	}
+
+	if rec := api.do(t, http.MethodGet, "/analytics/"+code+"?period=1y", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid period: status %d, want 400", rec.Code)
+	}
+}
+
+func TestGetAnalyticsSpike(t *testing.T) {
+	api := newTestAPI(t)
+	code := api.createLink(t, "user1", testDestA).ShortCode
+	now := time.Now()
+	for h := 25; h < 48; h += 2 {
+		api.click(t, code, now.Add(-time.Duration(h)*time.Hour), "v", "", 1)
+	}
+	api.click(t, code, now.Add(-3*time.Hour), "v", "", 40)
+
+	resp := decode[AnalyticsResponse](t, api.do(t, http.MethodGet, "/analytics/"+code, "", nil))
+	if !resp.Anomaly || len(resp.Spikes) != 1 || resp.Spikes[0].Count != 40 || resp.SpikeCheck != SpikeCheckDone {
+		t.Errorf("anomaly %v, spikes %+v, check %q, want the 40-click hour", resp.Anomaly, resp.Spikes, resp.SpikeCheck)
+	}
+}
+
+func TestGetAnalyticsSpikeNeedsBaseline(t *testing.T) {
+	api := newTestAPI(t)
+	code := api.createLink(t, "user1", testDestA).ShortCode
+	api.click(t, code, time.Now().Add(-3*time.Hour), "v", "", 40)
+
+	resp := decode[AnalyticsResponse](t, api.do(t, http.MethodGet, "/analytics/"+code, "", nil))
+	if resp.Anomaly || len(resp.Spikes) != 0 || resp.SpikeCheck != SpikeCheckNoBaseline {
+		t.Errorf("without a previous period: anomaly %v, spikes %+v, check %q", resp.Anomaly, resp.Spikes, resp.SpikeCheck)
+	}
+}
+
+// failingHistoryStore cannot read clicks between two times
+type failingHistoryStore struct {
+	*db.MemoryStore
+}
+
+func (s failingHistoryStore) GetClicksBetween(ctx context.Context, shortCode string, from, to time.Time) ([]models.TimePoint, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestGetAnalyticsSpikeUnavailable(t *testing.T) {
+	api := newTestAPI(t)
+	code := api.createLink(t, "user1", testDestA).ShortCode
+	api.click(t, code, time.Now().Add(-3*time.Hour), "v", "", 40)
+
+	rec := httptest.NewRecorder()
+	GetAnalytics(failingHistoryStore{api.store})(rec, httptest.NewRequest(http.MethodGet, "/analytics/"+code, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	resp := decode[AnalyticsResponse](t, rec)
+	if resp.Anomaly || len(resp.Spikes) != 0 || resp.SpikeCheck != SpikeCheckUnavailable {
+		t.Errorf("with the previous period unreadable: anomaly %v, spikes %+v, check %q", resp.Anomaly, resp.Spikes, resp.SpikeCheck)
+	}
+	if resp.TotalClicks != 40 {
+		t.Errorf("total clicks = %d, want the rest of the response intact", resp.TotalClicks)
+	}
+}
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	broker := NewSSEBroker()
+	srv := httptest.NewUnstartedServer(StreamAnalytics(db.NewMemoryStore(), db.NewMemoryCache(), broker))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/analytics/abc123/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		for lines.Scan() {
+			if event, ok := strings.CutPrefix(lines.Text(), "event: "); ok {
+				return event
+			}
+		}
+		return ""
+	}
+	if event := next(); event != EventSnapshot {
+		t.Fatalf("first event %q, want a snapshot", event)
+	}
+
+	// Events published well after the server's WriteTimeout still arrive
+	time.Sleep(300 * time.Millisecond)
+	broker.Publish(context.Background(), "abc123", EventClicks, []byte(`{}`))
+	if event := next(); event != EventClicks {
+		t.Fatalf("event after the write timeout = %q (%v), want %q", event, lines.Err(), EventClicks)
+	}
+}
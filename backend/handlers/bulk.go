@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"link-analytics-service/policy"
+	"link-analytics-service/utils"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	maxBulkLinks     = 1000
+	maxBulkBodyBytes = 5 << 20
+	// Rows whose destinations are checked at once
+	bulkCheckWorkers = 8
+	// Read and write deadline of a bulk request, which outlasts the server's
+	// timeouts when many destinations have to be resolved. Nothing is created
+	// after it passes, and it must stay below idempotencyPendingTTL so a retry
+	// cannot start while the first request is still running.
+	bulkTimeout = 2 * time.Minute
+	// How long destinations are checked for. Rows not checked by then fail, so
+	// quota checks and the insert still fit in bulkTimeout.
+	bulkCheckTimeout = time.Minute
+)
+
+// Bulk row outcomes
+const (
+	BulkCreated = "created"
+	BulkFailed  = "failed"
+	BulkSkipped = "skipped" // valid, but not created because another row failed in all_or_nothing mode
+)
+
+// BulkLinkRow is one link of a JSON bulk upload
+type BulkLinkRow struct {
+	URL         string   `json:"url"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	FolderID    *int64   `json:"folder_id,omitempty"`
+}
+
+type BulkCreateResponse struct {
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []BulkResult `json:"results"` // one per row, in upload order
+}
+
+type BulkResult struct {
+	Row    int                   `json:"row"` // 1-based, not counting a CSV header
+	Status string                `json:"status"`
+	Link   *CreateLinkResponse   `json:"link,omitempty"`
+	Error  *models.ErrorResponse `json:"error,omitempty"`
+}
+
+// bulkRow is a parsed upload row; err is set when a value could not be parsed
+type bulkRow struct {
+	req CreateLinkRequest
+	err error
+}
+
+// BulkCreateLinks handles POST /api/links/bulk?user_id=...[&all_or_nothing=true]
+// The body is a JSON array of BulkLinkRow, a CSV file with a header row, or a
+// multipart form with the JSON or CSV in a "file" field. Every row is checked as
+// POST /api/links would, then the valid rows are created in one transaction.
+// With all_or_nothing, nothing is created unless every row is valid.
+func BulkCreateLinks(store db.Store, cache db.Cache, baseURL string, policyEngine *policy.Engine, resolver *policy.RedirectResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		userID := params.Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+		allOrNothing := false
+		if v := params.Get("all_or_nothing"); v != "" {
+			var err error
+			if allOrNothing, err = strconv.ParseBool(v); err != nil {
+				writeErrorFrom(w, r, &models.ValidationError{Message: "all_or_nothing must be true or false"})
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		deadline := time.Now().Add(bulkTimeout)
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline)
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, models.ErrCodeInvalidRequest,
+					fmt.Sprintf("Request body must be at most %d bytes", maxBulkBodyBytes), nil)
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body", nil)
+			return
+		}
+
+		rows, err := parseBulkRows(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, err.Error(), nil)
+			return
+		}
+		if len(rows) == 0 {
+			writeErrorFrom(w, r, &models.ValidationError{Message: "no links to create"})
+			return
+		}
+		if len(rows) > maxBulkLinks {
+			writeErrorFrom(w, r, &models.ValidationError{Message: fmt.Sprintf("at most %d links can be created at once", maxBulkLinks)})
+			return
+		}
+		for i := range rows {
+			rows[i].req.UserID = userID
+		}
+
+		// The same rows are the same request, whether uploaded as JSON or CSV
+		fingerprint, _ := json.Marshal(struct {
+			AllOrNothing bool
+			Rows         []bulkFingerprintRow
+		}{allOrNothing, bulkFingerprintRows(rows)})
+
+		idempotent(w, r, cache, "links/bulk:"+userID, fingerprint, func(w http.ResponseWriter) {
+			createBulkLinks(w, r, store, baseURL, policyEngine, resolver, rows, allOrNothing)
+		})
+	}
+}
+
+func createBulkLinks(w http.ResponseWriter, r *http.Request, store db.Store, baseURL string, policyEngine *policy.Engine,
+	resolver *policy.RedirectResolver, rows []bulkRow, allOrNothing bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), bulkTimeout)
+	defer cancel()
+	response := BulkCreateResponse{Results: make([]BulkResult, len(rows))}
+	links := make([]*models.Link, len(rows))
+	fail := func(i int, err error) {
+		_, e := errorFrom(r, err)
+		response.Results[i].Status = BulkFailed
+		response.Results[i].Error = e
+	}
+
+	// Resolving destinations makes network calls, so rows are checked concurrently
+	checkCtx, cancelChecks := context.WithTimeout(ctx, bulkCheckTimeout)
+	defer cancelChecks()
+	timedOut := &requestError{Status: http.StatusGatewayTimeout, Code: models.ErrCodeTimeout,
+		Message: "Destination checks ran out of time before this row; retry it"}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < min(bulkCheckWorkers, len(rows)); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				err := rows[i].err
+				if err == nil && checkCtx.Err() == nil {
+					links[i], err = prepareLink(checkCtx, store, policyEngine, resolver, rows[i].req)
+				}
+				// A resolver cut off by the deadline lets the URL through unchecked
+				if err == nil && checkCtx.Err() != nil {
+					err = timedOut
+				}
+				if err != nil {
+					links[i] = nil
+					fail(i, err)
+				}
+			}
+		}()
+	}
+	for i := range rows {
+		response.Results[i].Row = i + 1
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var valid []int
+	for i, link := range links {
+		if link != nil {
+			valid = append(valid, i)
+		}
+	}
+
+	// Quota is only spent on links that are created: every one counts, as if
+	// created one at a time, and is given back if the insert does not happen
+	granted := 0
+	release := func(context.Context) {}
+	if len(valid) > 0 && (!allOrNothing || len(valid) == len(links)) {
+		var reason *policy.Reason
+		// Every row has the uploader's user ID
+		granted, reason, release = policyEngine.ReserveQuota(ctx, linkCreator(r, rows[0].req.UserID), len(valid))
+		for _, i := range valid[granted:] {
+			links[i] = nil
+			fail(i, quotaError(*reason))
+		}
+		valid = valid[:granted]
+	}
+
+	if allOrNothing && len(valid) < len(links) {
+		release(context.WithoutCancel(r.Context()))
+		for _, i := range valid {
+			response.Results[i].Status = BulkSkipped
+		}
+		valid = nil
+	}
+
+	if len(valid) > 0 {
+		create := make([]*models.Link, len(valid))
+		for n, i := range valid {
+			create[n] = links[i]
+		}
+		if err := store.CreateLinks(ctx, create, utils.GenerateShortCode); err != nil {
+			release(context.WithoutCancel(r.Context()))
+			writeErrorFrom(w, r, err)
+			return
+		}
+	}
+
+	for i, link := range links {
+		switch response.Results[i].Status {
+		case "":
+			SetL1Cache(link.ShortCode, link.OriginalURL, 24*time.Hour)
+			created := createLinkResponse(link, baseURL)
+			response.Results[i].Status = BulkCreated
+			response.Results[i].Link = &created
+			response.Created++
+		case BulkFailed:
+			response.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseBulkRows reads the rows of a bulk upload: CSV or multipart by
+// Content-Type, and JSON otherwise, as for POST /api/links
+func parseBulkRows(contentType string, body []byte) ([]bulkRow, error) {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return parseBulkCSV(body)
+	case "multipart/form-data":
+		form := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := form.NextPart()
+			if err == io.EOF {
+				return nil, fmt.Errorf("Multipart upload needs a file field")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid multipart body")
+			}
+			if part.FormName() != "file" {
+				continue
+			}
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid multipart body")
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "application/json" || strings.EqualFold(path.Ext(part.FileName()), ".json") {
+				return parseBulkJSON(data)
+			}
+			return parseBulkCSV(data)
+		}
+	default:
+		return parseBulkJSON(body)
+	}
+}
+
+// parseBulkJSON reads a JSON array of BulkLinkRow. A row of the wrong shape
+// fails on its own rather than failing the upload.
+func parseBulkJSON(data []byte) ([]bulkRow, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("Request body must be a JSON array of links")
+	}
+
+	rows := make([]bulkRow, len(raw))
+	for i, msg := range raw {
+		var link BulkLinkRow
+		if err := json.Unmarshal(msg, &link); err != nil {
+			rows[i].err = &models.ValidationError{Message: "row must be an object with a url and optional title, description, tags and folder_id"}
+			continue
+		}
+		rows[i].req = CreateLinkRequest{
+			URL:         link.URL,
+			Title:       link.Title,
+			Description: link.Description,
+			Tags:        link.Tags,
+			FolderID:    link.FolderID,
+		}
+	}
+	return rows, nil
+}
+
+// parseBulkCSV reads a CSV upload. The header row names the columns: url, and
+// optionally title, description, tags (separated by commas, semicolons or
+// spaces) and folder_id.
+func parseBulkCSV(data []byte) ([]bulkRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1 // missing trailing columns are empty
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CSV: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "url", "title", "description", "tags", "folder_id":
+		default:
+			return nil, fmt.Errorf("Unknown CSV column %q", name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("Duplicate CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, fmt.Errorf("CSV header must have a url column")
+	}
+
+	var rows []bulkRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV: %v", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var row bulkRow
+		row.req = CreateLinkRequest{
+			URL:         field("url"),
+			Title:       field("title"),
+			Description: field("description"),
+			Tags: strings.FieldsFunc(field("tags"), func(r rune) bool {
+				return r == ',' || r == ';' || unicode.IsSpace(r)
+			}),
+		}
+		if v := field("folder_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id < 1 {
+				row.err = &models.ValidationError{Message: fmt.Sprintf("invalid folder_id %q", v)}
+			}
+			row.req.FolderID = &id
+		}
+		rows = append(rows, row)
+	}
+}
+
+type bulkFingerprintRow struct {
+	Request CreateLinkRequest
+	Error   string
+}
+
+func bulkFingerprintRows(rows []bulkRow) []bulkFingerprintRow {
+	out := make([]bulkFingerprintRow, len(rows))
+	for i, row := range rows {
+		out[i].Request = row.req
+		if row.err != nil {
+			out[i].Error = row.err.Error()
+		}
+	}
+	return out
+}
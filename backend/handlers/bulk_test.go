@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/policy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func bulkUpload(t *testing.T, handler http.Handler, query, body string) BulkCreateResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/links/bulk?user_id=user1"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk upload: status %d: %s", rec.Code, rec.Body)
+	}
+	return decode[BulkCreateResponse](t, rec)
+}
+
+func TestBulkCreateQuota(t *testing.T) {
+	cache := db.NewMemoryCache()
+	engine, err := policy.NewEngine(cache, "", 3, "https://sho.rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := BulkCreateLinks(db.NewMemoryStore(), cache, "https://sho.rt", engine, nil)
+
+	// A skipped all-or-nothing upload spends no quota
+	resp := bulkUpload(t, handler, "&all_or_nothing=true",
+		fmt.Sprintf(`[{"url":%q},{"url":%q},{"url":"not a url"}]`, testDestA, testDestB))
+	if resp.Created != 0 || resp.Results[0].Status != BulkSkipped || resp.Results[2].Status != BulkFailed {
+		t.Fatalf("all_or_nothing upload = %+v", resp)
+	}
+
+	// So all three of the quota are left: two rows fit, the rest fail per row
+	resp = bulkUpload(t, handler, "", fmt.Sprintf(`[{"url":%q},{"url":%q},{"url":%q},{"url":%q}]`,
+		testDestA, testDestB, testDestA+"?x", testDestB+"?x"))
+	if resp.Created != 3 || resp.Failed != 1 {
+		t.Fatalf("created %d, failed %d, want 3 and 1: %+v", resp.Created, resp.Failed, resp.Results)
+	}
+	if e := resp.Results[3].Error; e == nil || e.Code != "quota_exceeded" {
+		t.Errorf("last row error = %+v, want quota_exceeded", e)
+	}
+
+	// An all-or-nothing upload over the quota creates nothing and gives the quota back
+	engine, _ = policy.NewEngine(db.NewMemoryCache(), "", 1, "https://sho.rt")
+	handler = BulkCreateLinks(db.NewMemoryStore(), db.NewMemoryCache(), "https://sho.rt", engine, nil)
+	resp = bulkUpload(t, handler, "&all_or_nothing=true", fmt.Sprintf(`[{"url":%q},{"url":%q}]`, testDestA, testDestB))
+	if resp.Created != 0 || resp.Results[0].Status != BulkSkipped || resp.Results[1].Status != BulkFailed {
+		t.Fatalf("all_or_nothing over quota = %+v", resp)
+	}
+	resp = bulkUpload(t, handler, "", fmt.Sprintf(`[{"url":%q}]`, testDestA))
+	if resp.Created != 1 {
+		t.Errorf("quota was not given back: %+v", resp.Results)
+	}
+}
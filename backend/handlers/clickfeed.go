@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"link-analytics-service/utils"
+	"log/slog"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	clickFeedQueueSize     = 4096
+	clickFeedFlushInterval = 500 * time.Millisecond
+	// Above this many clicks per code per flush, clicks are coalesced into a summary
+	clickFeedMaxPerCode = 10
+	// Number of individual clicks included in a summary as a sample
+	clickFeedSummarySamples = 5
+)
+
+// LiveClick is the privacy-safe view of a single click sent to live feed subscribers.
+// It deliberately carries no IP address, user agent or visitor hash.
+type LiveClick struct {
+	ShortCode      string    `json:"short_code"`
+	Time           time.Time `json:"time"`
+	Country        string    `json:"country,omitempty"`
+	Device         string    `json:"device"`
+	ReferrerDomain string    `json:"referrer_domain,omitempty"`
+}
+
+// LiveClickSummary replaces individual click events for a code under heavy load
+type LiveClickSummary struct {
+	ShortCode       string         `json:"short_code"`
+	From            time.Time      `json:"from"`
+	To              time.Time      `json:"to"`
+	Count           int            `json:"count"`
+	Countries       map[string]int `json:"countries"`
+	Devices         map[string]int `json:"devices"`
+	ReferrerDomains map[string]int `json:"referrer_domains"`
+	Samples         []LiveClick    `json:"samples"`
+}
+
+// ClickFeed turns clicks from the redirect path into live click events.
+// Recording never blocks: under load clicks are sampled or dropped from the
+// live feed (they are still counted by the analytics pipeline).
+type ClickFeed struct {
+	broker  *SSEBroker
+	queue   chan LiveClick
+	dropped atomic.Int64
+}
+
+// NewLiveClick reduces click details to the fields that are safe to share live
+func NewLiveClick(shortCode string, at time.Time, country, userAgent, referer string) LiveClick {
+	return LiveClick{
+		ShortCode:      shortCode,
+		Time:           at.UTC().Truncate(time.Second),
+		Country:        country,
+		Device:         utils.DeviceClass(userAgent),
+		ReferrerDomain: utils.ReferrerDomain(referer),
+	}
+}
+
+func NewClickFeed(broker *SSEBroker) *ClickFeed {
+	return &ClickFeed{
+		broker: broker,
+		queue:  make(chan LiveClick, clickFeedQueueSize),
+	}
+}
+
+// Record queues a click for the live feed. Safe to call on a nil feed (feed disabled).
+// Once the queue is half full, only one in four clicks is kept.
+func (f *ClickFeed) Record(click LiveClick) {
+	if f == nil {
+		return
+	}
+	if len(f.queue) > clickFeedQueueSize/2 && rand.Intn(4) != 0 {
+		f.dropped.Add(1)
+		return
+	}
+	select {
+	case f.queue <- click:
+	default:
+		f.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many clicks were sampled out of or dropped from the live feed
+func (f *ClickFeed) Dropped() int64 {
+	if f == nil {
+		return 0
+	}
+	return f.dropped.Load()
+}
+
+// Run publishes queued clicks every flush interval until ctx is cancelled
+func (f *ClickFeed) Run(ctx context.Context) {
+	ticker := time.NewTicker(clickFeedFlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string][]LiveClick)
+	for {
+		select {
+		case click := <-f.queue:
+			pending[click.ShortCode] = append(pending[click.ShortCode], click)
+		case <-ticker.C:
+			for shortCode, clicks := range pending {
+				f.publish(ctx, shortCode, clicks)
+			}
+			pending = make(map[string][]LiveClick)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish emits one event per click, or a single summary when a code is busy
+func (f *ClickFeed) publish(ctx context.Context, shortCode string, clicks []LiveClick) {
+	if len(clicks) <= clickFeedMaxPerCode {
+		for _, click := range clicks {
+			data, err := json.Marshal(click)
+			if err != nil {
+				slog.Error("failed to marshal live click", "short_code", shortCode, "error", err)
+				continue
+			}
+			f.broker.Publish(ctx, shortCode, EventClick, data)
+		}
+		return
+	}
+
+	summary := LiveClickSummary{
+		ShortCode:       shortCode,
+		From:            clicks[0].Time,
+		To:              clicks[0].Time,
+		Count:           len(clicks),
+		Countries:       make(map[string]int),
+		Devices:         make(map[string]int),
+		ReferrerDomains: make(map[string]int),
+	}
+	for _, click := range clicks {
+		if click.Time.Before(summary.From) {
+			summary.From = click.Time
+		}
+		if click.Time.After(summary.To) {
+			summary.To = click.Time
+		}
+		if click.Country != "" {
+			summary.Countries[click.Country]++
+		}
+		summary.Devices[click.Device]++
+		if click.ReferrerDomain != "" {
+			summary.ReferrerDomains[click.ReferrerDomain]++
+		}
+	}
+
+	// Uniform random sample of individual clicks
+	perm := rand.Perm(len(clicks))[:clickFeedSummarySamples]
+	for _, i := range perm {
+		summary.Samples = append(summary.Samples, clicks[i])
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error("failed to marshal live click summary", "short_code", shortCode, "error", err)
+		return
+	}
+	f.broker.Publish(ctx, shortCode, EventClickSummary, data)
+}
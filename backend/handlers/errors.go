@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"link-analytics-service/middleware"
+	"link-analytics-service/models"
+	"log/slog"
+	"net/http"
+)
+
+// requestError is a rejected request with its own status and error code, for
+// checks shared by handlers that report errors differently
+type requestError struct {
+	Status  int
+	Code    string
+	Message string
+	Details interface{}
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+// writeError writes the standard JSON error envelope. Server errors (5xx)
+// are counted in the error metrics; client errors are not.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	if status >= http.StatusInternalServerError {
+		IncrementErrorCount()
+	}
+	middleware.WriteError(w, r, status, code, message, details)
+}
+
+// writeErrorFrom maps an error to a response: ValidationError becomes 400,
+// NotFoundError 404, and anything else is logged and hidden behind a 500
+func writeErrorFrom(w http.ResponseWriter, r *http.Request, err error) {
+	status, e := errorFrom(r, err)
+	writeError(w, r, status, e.Code, e.Message, e.Details)
+}
+
+// errorFrom maps an error to its status and envelope as writeErrorFrom does,
+// without writing it
+func errorFrom(r *http.Request, err error) (int, *models.ErrorResponse) {
+	var reqErr *requestError
+	var validationErr *models.ValidationError
+	var notFoundErr *models.NotFoundError
+	switch {
+	case errors.As(err, &reqErr):
+		return reqErr.Status, &models.ErrorResponse{Code: reqErr.Code, Message: reqErr.Message, Details: reqErr.Details}
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest, &models.ErrorResponse{Code: models.ErrCodeValidation, Message: validationErr.Message}
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound, &models.ErrorResponse{Code: models.ErrCodeNotFound, Message: notFoundErr.Message}
+	default:
+		slog.ErrorContext(r.Context(), "request failed", "path", r.URL.Path, "error", err)
+		return http.StatusInternalServerError, &models.ErrorResponse{Code: models.ErrCodeInternal, Message: "Internal server error"}
+	}
+}
+
+// writeMethodNotAllowed rejects a request made with the wrong method
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, models.ErrCodeMethodNotAllowed, "Method not allowed", nil)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"link-analytics-service/utils"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultExportPeriod = 30 * 24 * time.Hour
+	// Rows written between flushes
+	exportChunkRows = 1000
+	// Each chunk must reach the client within this long. It replaces the server's
+	// WriteTimeout, so a download can take as long as it keeps moving.
+	exportWriteTimeout = 30 * time.Second
+)
+
+// Export record types
+const (
+	ExportSeries = "series" // clicks in one bucket of the aggregated series
+	ExportClick  = "click"  // a single click
+)
+
+// ExportRecord is one row of an analytics export
+type ExportRecord struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count,omitempty"`      // series only
+	IPAddress string    `json:"ip_address,omitempty"` // clicks only, anonymized
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+}
+
+// ExportAnalytics handles GET /api/analytics/{short_code}/export?format=csv|ndjson&from=...&to=...
+// It writes the link's aggregated series, hourly up to a day and daily beyond as in
+// GetAnalytics, followed by every click from from (inclusive) to to (exclusive).
+// Rows are streamed in chunks rather than built up in memory.
+func ExportAnalytics(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		shortCode := pathID(r.URL.Path, "analytics")
+		params := r.URL.Query()
+		format := params.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "ndjson" {
+			writeErrorFrom(w, r, &models.ValidationError{Message: "format must be csv or ndjson"})
+			return
+		}
+		from, to, err := parseExportRange(params.Get("from"), params.Get("to"))
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+		if _, err := store.GetLinkByCode(ctx, shortCode); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		series, err := store.GetClicksOverTime(ctx, shortCode, time.Since(from))
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		var out exportEncoder
+		if format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			out = newCSVExport(w)
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			out = newNDJSONExport(w)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%s.%s"`,
+			shortCode, from.UTC().Format("20060102"), to.UTC().Format("20060102"), format))
+
+		rc := http.NewResponseController(w)
+		rows := 0
+		flush := func() error {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+		emit := func(rec ExportRecord) error {
+			if rows%exportChunkRows == 0 {
+				rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+			}
+			if err := out.Encode(rec); err != nil {
+				return err
+			}
+			rows++
+			if rows%exportChunkRows == 0 {
+				return flush()
+			}
+			return nil
+		}
+
+		for _, point := range series {
+			if point.Timestamp.Before(to) {
+				if err = emit(ExportRecord{Type: ExportSeries, Timestamp: point.Timestamp.UTC(), Count: point.Count}); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = store.ScanLinkClicks(ctx, shortCode, from, to, func(click *models.ClickRecord) error {
+				return emit(ExportRecord{
+					Type:      ExportClick,
+					Timestamp: click.ClickedAt.UTC(),
+					IPAddress: utils.AnonymizeIP(click.IPAddress),
+					UserAgent: click.UserAgent,
+					Referer:   click.Referer,
+				})
+			})
+		}
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "analytics export aborted", "short_code", shortCode, "rows", rows, "error", err)
+			// The status line is long gone; cutting the connection is how the client
+			// learns the export is incomplete
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// parseExportRange reads from and to (RFC 3339 or YYYY-MM-DD, where a to date
+// includes that whole day). The range defaults to the 30 days up to now.
+func parseExportRange(fromParam, toParam string) (from, to time.Time, err error) {
+	if from, err = parseDateParam(fromParam, false); err != nil {
+		return from, to, &models.ValidationError{Message: "from must be a date (YYYY-MM-DD) or RFC 3339 time"}
+	}
+	if to, err = parseDateParam(toParam, true); err != nil {
+		return from, to, &models.ValidationError{Message: "to must be a date (YYYY-MM-DD) or RFC 3339 time"}
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultExportPeriod)
+	}
+	if !from.Before(to) {
+		return from, to, &models.ValidationError{Message: "from must be before to"}
+	}
+	return from, to, nil
+}
+
+type exportEncoder interface {
+	Encode(rec ExportRecord) error
+	// Flush writes out buffered records
+	Flush() error
+}
+
+type csvExport struct {
+	w *csv.Writer
+}
+
+func newCSVExport(w io.Writer) *csvExport {
+	e := &csvExport{w: csv.NewWriter(w)}
+	e.w.Write([]string{"type", "timestamp", "count", "ip_address", "user_agent", "referer"})
+	return e
+}
+
+func (e *csvExport) Encode(rec ExportRecord) error {
+	count := ""
+	if rec.Type == ExportSeries {
+		count = strconv.FormatInt(rec.Count, 10)
+	}
+	return e.w.Write([]string{rec.Type, rec.Timestamp.Format(time.RFC3339), count,
+		rec.IPAddress, csvText(rec.UserAgent), csvText(rec.Referer)})
+}
+
+func (e *csvExport) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText keeps spreadsheets from evaluating client-supplied text as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type ndjsonExport struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONExport(w io.Writer) *ndjsonExport {
+	buf := bufio.NewWriter(w)
+	return &ndjsonExport{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (e *ndjsonExport) Encode(rec ExportRecord) error {
+	return e.enc.Encode(rec)
+}
+
+func (e *ndjsonExport) Flush() error {
+	return e.buf.Flush()
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxFolderNameLength = 100
+
+type FolderRequest struct {
+	UserID   string     `json:"user_id"`
+	Name     *string    `json:"name"`
+	ParentID nullableID `json:"parent_id"` // null or absent on create for a top-level folder
+}
+
+type ListFoldersResponse struct {
+	// Folders in name order; nest them by parent_id
+	Folders []*models.Folder `json:"folders"`
+}
+
+type ListTagsResponse struct {
+	Tags []models.TagCount `json:"tags"`
+}
+
+// ListTags handles GET /api/tags?user_id=..., the user's tags, most used first
+func ListTags(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+
+		tags, err := store.GetTags(r.Context(), userID)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ListTagsResponse{Tags: tags})
+	}
+}
+
+// ListFolders handles GET /api/folders?user_id=...
+func ListFolders(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+
+		folders, err := store.GetFolders(r.Context(), userID)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ListFoldersResponse{Folders: folders})
+	}
+}
+
+// CreateFolder handles POST /api/folders
+func CreateFolder(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		req, ok := decodeFolderRequest(w, r)
+		if !ok {
+			return
+		}
+		if req.Name == nil {
+			writeErrorFrom(w, r, &models.ValidationError{Message: "name required"})
+			return
+		}
+
+		folder := &models.Folder{UserID: req.UserID, ParentID: req.ParentID.ID}
+		if err := setFolderName(folder, *req.Name); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		if err := store.CreateFolder(r.Context(), folder); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(folder)
+	}
+}
+
+// UpdateFolder handles PATCH /api/folders/{id}, renaming the folder or moving it
+// under another parent (null for the top level)
+func UpdateFolder(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id, ok := folderID(w, r)
+		if !ok {
+			return
+		}
+		req, ok := decodeFolderRequest(w, r)
+		if !ok {
+			return
+		}
+
+		folder, err := store.GetFolder(r.Context(), req.UserID, id)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		if req.Name != nil {
+			if err := setFolderName(folder, *req.Name); err != nil {
+				writeErrorFrom(w, r, err)
+				return
+			}
+		}
+		if req.ParentID.Set {
+			folder.ParentID = req.ParentID.ID
+		}
+		if err := store.UpdateFolder(r.Context(), folder); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(folder)
+	}
+}
+
+// DeleteFolder handles DELETE /api/folders/{id}?user_id=..., deleting the folder
+// and its subfolders. Their links are kept without a folder.
+func DeleteFolder(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		id, ok := folderID(w, r)
+		if !ok {
+			return
+		}
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+
+		if err := store.DeleteFolder(r.Context(), userID, id); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func decodeFolderRequest(w http.ResponseWriter, r *http.Request) (FolderRequest, bool) {
+	var req FolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body", nil)
+		return req, false
+	}
+	if req.UserID == "" {
+		writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id required", nil)
+		return req, false
+	}
+	return req, true
+}
+
+func folderID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(pathID(r.URL.Path, "folders"), 10, 64)
+	if err != nil || id < 1 {
+		writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Folder id required", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+func setFolderName(folder *models.Folder, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxFolderNameLength {
+		return &models.ValidationError{Message: fmt.Sprintf("name must be 1 to %d characters", maxFolderNameLength)}
+	}
+	folder.Name = name
+	return nil
+}
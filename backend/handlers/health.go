@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"link-analytics-service/db"
+	"link-analytics-service/middleware"
 	"net/http"
 	"runtime"
 	"sync/atomic"
@@ -30,39 +31,61 @@ func Health() http.HandlerFunc {
 }
 
 // Readiness handles GET /ready - readiness check with dependencies
-func Readiness(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
+func Readiness(store db.Store, cache db.Cache) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		
 		// Check database
 		dbHealthy := false
-		if err := pgDB.Ping(ctx); err == nil {
+		if err := store.Ping(ctx); err == nil {
 			dbHealthy = true
 		}
 		
-		// Check Redis
-		redisHealthy := false
-		if err := redisDB.Ping(ctx); err == nil {
-			redisHealthy = true
+		// Check Redis, or the in-process cache on a single-node install
+		cacheName := "redis"
+		if _, ok := cache.(*db.MemoryCache); ok {
+			cacheName = "cache"
+		}
+		cacheHealthy := false
+		if err := cache.Ping(ctx); err == nil {
+			cacheHealthy = true
 		}
 		
 		status := http.StatusOK
-		if !dbHealthy || !redisHealthy {
+		if !dbHealthy || !cacheHealthy {
 			status = http.StatusServiceUnavailable
 		}
 		
+		body := map[string]interface{}{
+			"status":          map[string]bool{"database": dbHealthy, cacheName: cacheHealthy},
+			"ready":           dbHealthy && cacheHealthy,
+			"rate_limit_mode": middleware.RateLimitMode(),
+			"timestamp":       time.Now().UTC().Format(time.RFC3339),
+		}
+
+		// An unusable replica does not affect readiness; analytics reads fall back to the primary
+		if replicated, ok := store.(interface {
+			ReplicaStatus() (db.ReplicaStatus, bool)
+		}); ok {
+			if replica, ok := replicated.ReplicaStatus(); ok {
+				body["status"].(map[string]bool)["database_replica"] = replica.Healthy
+				body["replica"] = map[string]interface{}{
+					"healthy":     replica.Healthy,
+					"lag_seconds": replica.Lag.Seconds(),
+					"checked_at":  replica.CheckedAt.UTC().Format(time.RFC3339),
+					"error":       replica.Error,
+				}
+			}
+		}
+		
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(status)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status":   map[string]bool{"database": dbHealthy, "redis": redisHealthy},
-			"ready":    dbHealthy && redisHealthy,
-			"timestamp": time.Now().UTC().Format(time.RFC3339),
-		})
+		json.NewEncoder(w).Encode(body)
 	}
 }
 
 // Metrics handles GET /metrics - application metrics
-func Metrics() http.HandlerFunc {
+func Metrics(broker *SSEBroker, clickFeed *ClickFeed) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
@@ -101,6 +124,16 @@ func Metrics() http.HandlerFunc {
 			"cache": map[string]interface{}{
 				"l1_size": getL1CacheSize(),
 			},
+			"sse": map[string]interface{}{
+				"subscribers":              broker.SubscriberCount(),
+				"slow_subscribers_dropped": broker.SlowSubscribersDropped(),
+				"cluster_fanout":           broker.ClusterFanoutActive(),
+				"live_clicks_dropped":      clickFeed.Dropped(),
+			},
+			"rate_limit": map[string]interface{}{
+				"mode":                 middleware.RateLimitMode(),
+				"fallback_activations": middleware.RateLimitFallbackCount(),
+			},
 		})
 	}
 }
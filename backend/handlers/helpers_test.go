@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"link-analytics-service/policy"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Destinations are public IP literals, so the policy engine does no DNS lookups
+const (
+	testDestA = "https://203.0.113.10/spring-sale"
+	testDestB = "https://203.0.113.20/launch"
+)
+
+type testAPI struct {
+	store  *db.MemoryStore
+	cache  *db.MemoryCache
+	router http.Handler
+}
+
+// newTestAPI serves the link and analytics handlers on the in-memory store and
+// cache, under the paths the router passes them (without /api)
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	store := db.NewMemoryStore()
+	cache := db.NewMemoryCache()
+	engine, err := policy.NewEngine(cache, "", 0, "https://sho.rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := CreateLink(store, cache, "https://sho.rt", engine, nil)
+	list, get := ListLinks(store), GetLink(store)
+	overview, analytics := GetAnalyticsOverview(store), GetAnalytics(store)
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case r.Method == http.MethodPost && path == "/links":
+			links(w, r)
+		case r.Method == http.MethodGet && path == "/links":
+			list(w, r)
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/links/"):
+			get(w, r)
+		case r.Method == http.MethodGet && path == "/analytics/overview":
+			overview(w, r)
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/analytics/"):
+			analytics(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	return &testAPI{store: store, cache: cache, router: router}
+}
+
+func (api *testAPI) do(t *testing.T, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	api.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+func (api *testAPI) createLink(t *testing.T, userID, url string) CreateLinkResponse {
+	t.Helper()
+	rec := api.do(t, http.MethodPost, "/links", fmt.Sprintf(`{"url":%q,"user_id":%q}`, url, userID), nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create link: status %d: %s", rec.Code, rec.Body)
+	}
+	return decode[CreateLinkResponse](t, rec)
+}
+
+// click records clicks as the analytics workers would
+func (api *testAPI) click(t *testing.T, shortCode string, at time.Time, visitor, referer string, n int) {
+	t.Helper()
+	ctx := context.Background()
+	events := make([]*models.ClickEvent, n)
+	for i := range events {
+		events[i] = &models.ClickEvent{ShortCode: shortCode, Timestamp: at, VisitorHash: visitor, Referer: referer}
+	}
+	if err := api.store.BatchInsertClickEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.store.UpdateLinkStats(ctx, shortCode, int64(n), 0); err != nil {
+		t.Fatal(err)
+	}
+	if referer != "" {
+		if err := api.store.UpdateTopReferrers(ctx, shortCode, referer, int64(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
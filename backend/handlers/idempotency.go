@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// How long a response is kept for replay
+	idempotencyTTL = 24 * time.Hour
+	// How long a key stays reserved by a request that has not finished, in case
+	// its server dies before storing the response. It must outlast the longest
+	// idempotent request (bulkTimeout), or a retry could run alongside it.
+	idempotencyPendingTTL   = 5 * time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotentResponse is what the cache holds for an Idempotency-Key: the
+// fingerprint of the first request and, once it has finished, its response
+type idempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status,omitempty"` // 0 while the first request is in progress
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// idempotent runs handle at most once per Idempotency-Key header within scope
+// (the route and user), replaying the stored response to retries with the same
+// fingerprint (a hash of the request body and anything else that changes its
+// meaning). A retry with a different fingerprint gets 422, one made while the
+// first is still running gets 409. Server errors and 429s are not stored, so they
+// can be retried once the cause has passed. Without the header, or when the cache
+// fails, handle just runs.
+func idempotent(w http.ResponseWriter, r *http.Request, cache db.Cache, scope string, fingerprint []byte, handle func(http.ResponseWriter)) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handle(w)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest,
+			fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), nil)
+		return
+	}
+
+	ctx := r.Context()
+	sum := sha256.Sum256(fingerprint)
+	stored := idempotentResponse{Fingerprint: hex.EncodeToString(sum[:])}
+	cacheKey := "idempotency:" + scope + ":" + key
+
+	pending, _ := json.Marshal(stored)
+	reserved, err := cache.SetNX(ctx, cacheKey, string(pending), idempotencyPendingTTL)
+	if err != nil {
+		slog.WarnContext(ctx, "idempotency key check failed, handling request without it", "error", err)
+		handle(w)
+		return
+	}
+	if !reserved {
+		replayIdempotent(w, r, cache, cacheKey, stored.Fingerprint)
+		return
+	}
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	handle(rec)
+
+	if rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+		if err := cache.Delete(ctx, cacheKey); err != nil {
+			slog.WarnContext(ctx, "failed to release idempotency key", "error", err)
+		}
+	} else {
+		stored.Status = rec.status
+		stored.ContentType = rec.header.Get("Content-Type")
+		stored.Body = rec.body.Bytes()
+		data, _ := json.Marshal(stored)
+		if err := cache.Set(ctx, cacheKey, string(data), idempotencyTTL); err != nil {
+			slog.WarnContext(ctx, "failed to store idempotent response", "error", err)
+		}
+	}
+
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// replayIdempotent answers a request whose Idempotency-Key is already taken
+func replayIdempotent(w http.ResponseWriter, r *http.Request, cache db.Cache, cacheKey, fingerprint string) {
+	var stored idempotentResponse
+	data, err := cache.Get(r.Context(), cacheKey)
+	if err == nil {
+		err = json.Unmarshal([]byte(data), &stored)
+	}
+	switch {
+	case err != nil:
+		// Most likely the first request failed and released the key just now
+		writeError(w, r, http.StatusConflict, models.ErrCodeIdempotencyPending, "A request with this Idempotency-Key is in progress; retry later", nil)
+	case stored.Fingerprint != fingerprint:
+		writeError(w, r, http.StatusUnprocessableEntity, models.ErrCodeIdempotencyKeyReuse, "Idempotency-Key was already used for a different request", nil)
+	case stored.Status == 0:
+		writeError(w, r, http.StatusConflict, models.ErrCodeIdempotencyPending, "A request with this Idempotency-Key is in progress; retry later", nil)
+	default:
+		if stored.ContentType != "" {
+			w.Header().Set("Content-Type", stored.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
+
+// responseRecorder buffers a response so it can be stored before it is sent
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
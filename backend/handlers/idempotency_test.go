@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/policy"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateLinkIdempotencyKey(t *testing.T) {
+	api := newTestAPI(t)
+	key := http.Header{"Idempotency-Key": {"retry-1"}}
+	body := fmt.Sprintf(`{"url":%q,"user_id":"user1"}`, testDestA)
+
+	first := api.do(t, http.MethodPost, "/links", body, key)
+	retry := api.do(t, http.MethodPost, "/links", body, key)
+	if first.Code != http.StatusCreated || retry.Code != http.StatusCreated {
+		t.Fatalf("statuses %d and %d, want 201", first.Code, retry.Code)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry was not marked as replayed")
+	}
+	if decode[CreateLinkResponse](t, first).ShortCode != decode[CreateLinkResponse](t, retry).ShortCode {
+		t.Error("retry created another link")
+	}
+
+	other := api.do(t, http.MethodPost, "/links", fmt.Sprintf(`{"url":%q,"user_id":"user1"}`, testDestB), key)
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body: status %d, want 422", other.Code)
+	}
+}
+
+func TestIdempotencyKeyReleasedOnQuota(t *testing.T) {
+	store, cache := db.NewMemoryStore(), db.NewMemoryCache()
+	engine, err := policy.NewEngine(cache, "", 1, "https://sho.rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &testAPI{store: store, cache: cache, router: CreateLink(store, cache, "https://sho.rt", engine, nil)}
+	api.createLink(t, "user1", testDestA)
+
+	key := http.Header{"Idempotency-Key": {"over-quota"}}
+	body := fmt.Sprintf(`{"url":%q,"user_id":"user1"}`, testDestB)
+	for i := 0; i < 2; i++ {
+		rec := api.do(t, http.MethodPost, "/links", body, key)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("attempt %d: status %d, want 429", i+1, rec.Code)
+		}
+		if rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("attempt %d: 429 was replayed instead of retried", i+1)
+		}
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	cache := db.NewMemoryCache()
+	started, finish := make(chan struct{}), make(chan struct{})
+	var runs int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotent(w, r, cache, "test", []byte("body"), func(w http.ResponseWriter) {
+			runs++
+			if runs == 1 {
+				close(started)
+				<-finish
+			}
+			w.WriteHeader(http.StatusCreated)
+		})
+	})
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Idempotency-Key", "slow")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- request() }()
+	<-started
+	if rec := request(); rec.Code != http.StatusConflict {
+		t.Errorf("retry while running: status %d, want 409", rec.Code)
+	}
+	close(finish)
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Errorf("first request: status %d", rec.Code)
+	}
+	if rec := request(); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after it finished: status %d, replayed %q", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+	if runs != 1 {
+		t.Errorf("handler ran %d times", runs)
+	}
+}
@@ -1,113 +1,269 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"link-analytics-service/db"
 	"link-analytics-service/models"
+	"link-analytics-service/policy"
 	"link-analytics-service/utils"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type CreateLinkRequest struct {
-	URL    string `json:"url"`
-	UserID string `json:"user_id"`
+	URL         string   `json:"url"`
+	UserID      string   `json:"user_id"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	FolderID    *int64   `json:"folder_id,omitempty"`
+}
+
+// UpdateLinkRequest is the body of PATCH /api/links/{short_code}; absent fields are left as they are
+type UpdateLinkRequest struct {
+	UserID      string     `json:"user_id"`
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	Tags        *[]string  `json:"tags"`
+	FolderID    nullableID `json:"folder_id"` // null takes the link out of its folder
 }
 
 type CreateLinkResponse struct {
-	ShortCode   string    `json:"short_code"`
-	ShortURL    string    `json:"short_url"`
-	OriginalURL string    `json:"original_url"`
-	CreatedAt   time.Time `json:"created_at"`
+	ShortCode     string    `json:"short_code"`
+	ShortURL      string    `json:"short_url"`
+	OriginalURL   string    `json:"original_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	ResolvedURL   string    `json:"resolved_url,omitempty"`
+	RedirectChain []string  `json:"redirect_chain,omitempty"`
+	Title         string    `json:"title,omitempty"`
+	Description   string    `json:"description,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+	FolderID      *int64    `json:"folder_id,omitempty"`
+	// Reused is set when the user's existing link to the URL was returned instead of a new one
+	Reused bool `json:"reused,omitempty"`
 }
 
 type LinkResponse struct {
-	ShortCode   string         `json:"short_code"`
-	OriginalURL string         `json:"original_url"`
-	CreatedAt   time.Time      `json:"created_at"`
-	Stats       *models.LinkStats `json:"stats"`
+	ShortCode     string            `json:"short_code"`
+	OriginalURL   string            `json:"original_url"`
+	CreatedAt     time.Time         `json:"created_at"`
+	ResolvedURL   string            `json:"resolved_url,omitempty"`
+	RedirectChain []string          `json:"redirect_chain,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	FolderID      *int64            `json:"folder_id,omitempty"`
+	Stats         *models.LinkStats `json:"stats"`
 }
 
 type ListLinksResponse struct {
-	Links []LinkInfo `json:"links"`
+	Links      []LinkInfo `json:"links"`
+	Total      int64      `json:"total"`       // links matching the filters on all pages
+	NextCursor *string    `json:"next_cursor"` // null on the last page
 }
 
 type LinkInfo struct {
 	ShortCode   string    `json:"short_code"`
 	OriginalURL string    `json:"original_url"`
 	CreatedAt   time.Time `json:"created_at"`
+	Title       string    `json:"title,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	FolderID    *int64    `json:"folder_id,omitempty"`
 	TotalClicks int64     `json:"total_clicks"`
 }
 
 // CreateLink handles POST /api/links
-func CreateLink(pgDB *db.PostgresDB, baseURL string) http.HandlerFunc {
+// resolver is optional; when set, the destination's redirect chain is followed and stored.
+// With an Idempotency-Key header, retries of the same request get the first response.
+func CreateLink(store db.Store, cache db.Cache, baseURL string, policyEngine *policy.Engine, resolver *policy.RedirectResolver) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
 		var req CreateLinkRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body", nil)
 			return
 		}
 
-		// Validate URL
-		if !utils.IsValidURL(req.URL) {
-			http.Error(w, "Invalid URL format", http.StatusBadRequest)
+		// Re-encoded, so formatting and field order do not make a retry a different request
+		fingerprint, _ := json.Marshal(req)
+		idempotent(w, r, cache, "links:"+linkCreator(r, req.UserID), fingerprint, func(w http.ResponseWriter) {
+			createLink(w, r, store, baseURL, policyEngine, resolver, req)
+		})
+	}
+}
+
+func createLink(w http.ResponseWriter, r *http.Request, store db.Store, baseURL string, policyEngine *policy.Engine,
+	resolver *policy.RedirectResolver, req CreateLinkRequest) {
+	link, err := prepareLink(r.Context(), store, policyEngine, resolver, req)
+	if err != nil {
+		writeErrorFrom(w, r, err)
+		return
+	}
+
+	// Users who opted in get their existing link to the same destination back
+	if req.UserID != "" {
+		existing, err := reusableLink(r.Context(), store, req.UserID, req.URL)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		if existing != nil {
+			response := createLinkResponse(existing, baseURL)
+			response.Reused = true
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
 			return
 		}
+	}
 
-		// Generate short code (retry on collision)
-		var link *models.Link
-		maxRetries := 5
-		for i := 0; i < maxRetries; i++ {
-			shortCode := utils.GenerateShortCode()
-			link = &models.Link{
-				ShortCode:   shortCode,
-				OriginalURL: req.URL,
-				UserID:      req.UserID,
-			}
+	// Count against the creator's quota only once the destination is acceptable,
+	// and give it back if the link is not created after all
+	granted, reason, release := policyEngine.ReserveQuota(r.Context(), linkCreator(r, req.UserID), 1)
+	if granted == 0 {
+		writeErrorFrom(w, r, quotaError(*reason))
+		return
+	}
 
-			err := pgDB.CreateLink(r.Context(), link)
-			if err == nil {
-				break
-			}
+	// Generate short code (retry on collision)
+	maxRetries := 5
+	for i := 0; i < maxRetries; i++ {
+		link.ShortCode = utils.GenerateShortCode()
+		err := store.CreateLink(r.Context(), link)
+		if err == nil {
+			break
+		}
 
-			// Check if it's a unique constraint violation
-			if i == maxRetries-1 {
-				log.Printf("Failed to create link after %d retries: %v", maxRetries, err)
-				http.Error(w, "Failed to create link", http.StatusInternalServerError)
-				return
+		// Check if it's a unique constraint violation
+		if i == maxRetries-1 {
+			release(context.WithoutCancel(r.Context()))
+			slog.ErrorContext(r.Context(), "failed to create link", "retries", maxRetries, "error", err)
+			writeError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Failed to create link", nil)
+			return
+		}
+	}
+
+	// Immediately add to L1 cache for fast redirects
+	SetL1Cache(link.ShortCode, link.OriginalURL, 24*time.Hour)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createLinkResponse(link, baseURL))
+}
+
+// reusableLink returns the user's existing link to url when their settings ask
+// for links to be reused, and nil otherwise
+func reusableLink(ctx context.Context, store db.Store, userID, url string) (*models.Link, error) {
+	settings, err := store.GetUserSettings(ctx, userID)
+	if err != nil || !settings.ReuseExistingLinks {
+		return nil, err
+	}
+	link, err := store.FindLinkByURL(ctx, userID, url)
+	var notFound *models.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	return link, err
+}
+
+// prepareLink validates a create request and checks its destination, returning
+// the link to store without a short code. It does not touch the quota.
+func prepareLink(ctx context.Context, store db.Store, policyEngine *policy.Engine, resolver *policy.RedirectResolver, req CreateLinkRequest) (*models.Link, error) {
+	if !utils.IsValidURL(req.URL) {
+		return nil, &models.ValidationError{Message: "Invalid URL format"}
+	}
+
+	metadata := models.LinkMetadataUpdate{Title: &req.Title, Description: &req.Description, Tags: &req.Tags}
+	if err := validateLinkMetadata(&metadata); err != nil {
+		return nil, err
+	}
+	if req.FolderID != nil {
+		if _, err := store.GetFolder(ctx, req.UserID, *req.FolderID); err != nil {
+			var notFound *models.NotFoundError
+			if errors.As(err, &notFound) {
+				err = &models.ValidationError{Message: fmt.Sprintf("folder %d not found", *req.FolderID)}
 			}
+			return nil, err
 		}
+	}
 
-		// Immediately add to L1 cache for fast redirects
-		SetL1Cache(link.ShortCode, link.OriginalURL, 24*time.Hour)
+	// Apply destination policy (blocklists, self-reference, private networks)
+	if reasons := policyEngine.CheckDestination(ctx, req.URL); len(reasons) > 0 {
+		return nil, &requestError{Status: http.StatusUnprocessableEntity, Code: models.ErrCodeDestinationRejected, Message: "Destination rejected", Details: reasons}
+	}
 
-		// Use provided baseURL (frontend URL) for short links
-		// The frontend will handle the redirect
-		response := CreateLinkResponse{
-			ShortCode:   link.ShortCode,
-			ShortURL:    baseURL + "/" + link.ShortCode,
-			OriginalURL: link.OriginalURL,
-			CreatedAt:   link.CreatedAt,
+	link := &models.Link{
+		OriginalURL: req.URL,
+		UserID:      req.UserID,
+		Title:       *metadata.Title,
+		Description: *metadata.Description,
+		Tags:        *metadata.Tags,
+		FolderID:    req.FolderID,
+	}
+
+	// Follow the destination's redirects to catch loops and record where it really goes
+	if resolver != nil {
+		chain, reasons := resolver.Resolve(ctx, req.URL)
+		if len(reasons) > 0 {
+			return nil, &requestError{Status: http.StatusUnprocessableEntity, Code: models.ErrCodeDestinationRejected, Message: "Destination rejected", Details: reasons}
+		}
+		if chain != nil && len(chain.Hops) > 0 {
+			link.ResolvedURL = chain.FinalURL
+			link.RedirectChain = chain.Hops
 		}
+	}
+	return link, nil
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(response)
+// linkCreator identifies who creates a link: the user, or the client's IP for
+// anonymous links
+func linkCreator(r *http.Request, userID string) string {
+	if userID == "" {
+		return "ip:" + utils.ExtractIP(r)
+	}
+	return userID
+}
+
+func quotaError(reason policy.Reason) error {
+	return &requestError{Status: http.StatusTooManyRequests, Code: models.ErrCodeQuotaExceeded, Message: "Link creation quota exceeded", Details: []policy.Reason{reason}}
+}
+
+// createLinkResponse describes a new link. Short URLs use baseURL (the frontend
+// URL), which handles the redirect.
+func createLinkResponse(link *models.Link, baseURL string) CreateLinkResponse {
+	return CreateLinkResponse{
+		ShortCode:     link.ShortCode,
+		ShortURL:      baseURL + "/" + link.ShortCode,
+		OriginalURL:   link.OriginalURL,
+		CreatedAt:     link.CreatedAt,
+		ResolvedURL:   link.ResolvedURL,
+		RedirectChain: link.RedirectChain,
+		Title:         link.Title,
+		Description:   link.Description,
+		Tags:          link.Tags,
+		FolderID:      link.FolderID,
 	}
 }
 
 // GetLink handles GET /api/links/{short_code}
-func GetLink(pgDB *db.PostgresDB) http.HandlerFunc {
+func GetLink(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
@@ -120,25 +276,20 @@ func GetLink(pgDB *db.PostgresDB) http.HandlerFunc {
 		} else if len(pathParts) >= 2 && pathParts[0] == "links" {
 			shortCode = pathParts[1]
 		} else {
-			http.Error(w, "Short code required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Short code required", nil)
 			return
 		}
 
-		link, err := pgDB.GetLinkByCode(r.Context(), shortCode)
+		link, err := store.GetLinkByCode(r.Context(), shortCode)
 		if err != nil {
-			if _, ok := err.(*models.NotFoundError); ok {
-				http.Error(w, "Link not found", http.StatusNotFound)
-				return
-			}
-			log.Printf("Error getting link: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeErrorFrom(w, r, err)
 			return
 		}
 
 		// Get stats
-		stats, err := pgDB.GetLinkStats(r.Context(), shortCode)
+		stats, err := store.GetLinkStats(r.Context(), shortCode)
 		if err != nil {
-			log.Printf("Error getting stats: %v", err)
+			slog.ErrorContext(r.Context(), "failed to get link stats", "short_code", shortCode, "error", err)
 			stats = &models.LinkStats{
 				ShortCode:      shortCode,
 				TotalClicks:    0,
@@ -146,64 +297,328 @@ func GetLink(pgDB *db.PostgresDB) http.HandlerFunc {
 			}
 		}
 
-		response := LinkResponse{
-			ShortCode:   link.ShortCode,
-			OriginalURL: link.OriginalURL,
-			CreatedAt:   link.CreatedAt,
-			Stats:       stats,
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(linkResponse(link, stats))
+	}
+}
+
+// UpdateLink handles PATCH /api/links/{short_code}, changing the link's title,
+// description, tags or folder. Links of other users are not found.
+func UpdateLink(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		shortCode := pathID(r.URL.Path, "links")
+		if shortCode == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Short code required", nil)
+			return
+		}
+
+		var req UpdateLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body", nil)
+			return
+		}
+		if req.UserID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id required", nil)
+			return
+		}
+
+		update := models.LinkMetadataUpdate{Title: req.Title, Description: req.Description, Tags: req.Tags}
+		if req.FolderID.Set {
+			var folderID int64 // 0 takes the link out of its folder
+			if req.FolderID.ID != nil {
+				folderID = *req.FolderID.ID
+			}
+			update.FolderID = &folderID
+		}
+		if err := validateLinkMetadata(&update); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		link, err := store.UpdateLinkMetadata(r.Context(), req.UserID, shortCode, update)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		stats, err := store.GetLinkStats(r.Context(), shortCode)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get link stats", "short_code", shortCode, "error", err)
+			stats = &models.LinkStats{ShortCode: shortCode}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(linkResponse(link, stats))
 	}
 }
 
+func linkResponse(link *models.Link, stats *models.LinkStats) LinkResponse {
+	return LinkResponse{
+		ShortCode:     link.ShortCode,
+		OriginalURL:   link.OriginalURL,
+		CreatedAt:     link.CreatedAt,
+		ResolvedURL:   link.ResolvedURL,
+		RedirectChain: link.RedirectChain,
+		Title:         link.Title,
+		Description:   link.Description,
+		Tags:          link.Tags,
+		FolderID:      link.FolderID,
+		Stats:         stats,
+	}
+}
+
+// Limits on link metadata
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 50
+)
+
+// validateLinkMetadata trims the title and description and normalizes the tags of
+// update in place, rejecting values over the limits
+func validateLinkMetadata(update *models.LinkMetadataUpdate) error {
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if utf8.RuneCountInString(title) > maxTitleLength {
+			return &models.ValidationError{Message: fmt.Sprintf("title must be at most %d characters", maxTitleLength)}
+		}
+		update.Title = &title
+	}
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if utf8.RuneCountInString(description) > maxDescriptionLength {
+			return &models.ValidationError{Message: fmt.Sprintf("description must be at most %d characters", maxDescriptionLength)}
+		}
+		update.Description = &description
+	}
+	if update.Tags != nil {
+		tags, err := normalizeTags(*update.Tags)
+		if err != nil {
+			return err
+		}
+		update.Tags = &tags
+	}
+	return nil
+}
+
+// normalizeTags lower-cases and de-duplicates tags. A tag is letters, digits and
+// the separators - _ . : so tags can be listed in query strings and CSV unquoted.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, &models.ValidationError{Message: fmt.Sprintf("a link can have at most %d tags", maxTags)}
+	}
+	return normalized, nil
+}
+
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return "", &models.ValidationError{Message: fmt.Sprintf("tags must be 1 to %d characters", maxTagLength)}
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.:", r) {
+			return "", &models.ValidationError{Message: fmt.Sprintf("tag %q may only contain letters, digits and - _ . :", tag)}
+		}
+	}
+	return tag, nil
+}
+
+// nullableID is an optional JSON id where null (clear the id) differs from an
+// absent field (leave it as it is)
+type nullableID struct {
+	Set bool
+	ID  *int64
+}
+
+func (n *nullableID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.ID)
+}
+
+// pathID returns the path segment after /api/{resource}/ or /{resource}/
+func pathID(path, resource string) string {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(path, "/api"), "/"+resource+"/")
+	if !ok {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
+
+// Page sizes for ListLinks
+const (
+	defaultLinkPageSize = 50
+	maxLinkPageSize     = 200
+)
+
 // ListLinks handles GET /api/links?user_id=...
-func ListLinks(pgDB *db.PostgresDB) http.HandlerFunc {
+// Optional: sort=created_at|clicks, order=desc|asc, domain=, tag=, folder_id=, from=, to=, limit=, cursor=
+func ListLinks(store db.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
 		userID := r.URL.Query().Get("user_id")
 		if userID == "" {
-			http.Error(w, "user_id parameter required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
 			return
 		}
 
-		links, err := pgDB.GetLinksByUser(r.Context(), userID)
+		query, err := parseLinkListQuery(r.URL.Query())
 		if err != nil {
-			log.Printf("Error getting links: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
-		// Get stats for each link
-		linkInfos := make([]LinkInfo, 0, len(links))
-		for _, link := range links {
-			stats, err := pgDB.GetLinkStats(r.Context(), link.ShortCode)
-			if err != nil {
-				log.Printf("Error getting stats for %s: %v", link.ShortCode, err)
-				stats = &models.LinkStats{
-					ShortCode:      link.ShortCode,
-					TotalClicks:    0,
-					UniqueVisitors: 0,
-				}
-			}
+			writeErrorFrom(w, r, err)
+			return
+		}
+		query.UserID = userID
+
+		page, err := store.ListLinks(r.Context(), query)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
 
+		linkInfos := make([]LinkInfo, 0, len(page.Links))
+		for _, link := range page.Links {
 			linkInfos = append(linkInfos, LinkInfo{
 				ShortCode:   link.ShortCode,
 				OriginalURL: link.OriginalURL,
 				CreatedAt:   link.CreatedAt,
-				TotalClicks: stats.TotalClicks,
+				Title:       link.Title,
+				Tags:        link.Tags,
+				FolderID:    link.FolderID,
+				TotalClicks: link.TotalClicks,
 			})
 		}
 
-		response := ListLinksResponse{Links: linkInfos}
+		response := ListLinksResponse{Links: linkInfos, Total: page.Total}
+		if page.Next != nil {
+			cursor := encodeLinkCursor(page.Next)
+			response.NextCursor = &cursor
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
+// parseLinkListQuery reads the sort, filter and paging parameters of ListLinks
+func parseLinkListQuery(params url.Values) (models.LinkListQuery, error) {
+	query := models.LinkListQuery{
+		Sort:   models.LinkSortCreatedAt,
+		Domain: strings.TrimSpace(params.Get("domain")),
+		Limit:  defaultLinkPageSize,
+	}
+
+	switch sort := params.Get("sort"); sort {
+	case "", models.LinkSortCreatedAt:
+	case models.LinkSortClicks:
+		query.Sort = sort
+	default:
+		return query, &models.ValidationError{Message: "sort must be created_at or clicks"}
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Ascending = true
+	default:
+		return query, &models.ValidationError{Message: "order must be asc or desc"}
+	}
+
+	var err error
+	if v := params.Get("tag"); v != "" {
+		if query.Tag, err = normalizeTag(v); err != nil {
+			return query, err
+		}
+	}
+	if v := params.Get("folder_id"); v != "" {
+		if query.FolderID, err = strconv.ParseInt(v, 10, 64); err != nil || query.FolderID < 1 {
+			return query, &models.ValidationError{Message: "folder_id must be a folder id"}
+		}
+	}
+
+	if query.From, err = parseDateParam(params.Get("from"), false); err != nil {
+		return query, &models.ValidationError{Message: "from must be a date (2006-01-02) or RFC 3339 time"}
+	}
+	if query.To, err = parseDateParam(params.Get("to"), true); err != nil {
+		return query, &models.ValidationError{Message: "to must be a date (2006-01-02) or RFC 3339 time"}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return query, &models.ValidationError{Message: "from must be before to"}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLinkPageSize {
+			return query, &models.ValidationError{Message: fmt.Sprintf("limit must be between 1 and %d", maxLinkPageSize)}
+		}
+		query.Limit = n
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		cursor, err := decodeLinkCursor(v)
+		if err != nil {
+			return query, &models.ValidationError{Message: "invalid cursor"}
+		}
+		if cursor.Sort != query.Sort || cursor.Ascending != query.Ascending {
+			return query, &models.ValidationError{Message: "cursor belongs to a different sort order"}
+		}
+		query.After = cursor
+	}
+	return query, nil
+}
+
+// parseDateParam parses a date or RFC 3339 time. A bare date used as an upper
+// bound includes that whole day.
+func parseDateParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Cursors are opaque to clients: base64url-encoded JSON of the last link's sort position
+func encodeLinkCursor(cursor *models.LinkCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeLinkCursor(v string) (*models.LinkCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	var cursor models.LinkCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
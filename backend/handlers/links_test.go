@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"link-analytics-service/policy"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateLinkRejections(t *testing.T) {
+	api := newTestAPI(t)
+	for _, tc := range []struct {
+		body   string
+		status int
+	}{
+		{`not json`, http.StatusBadRequest},
+		{`{"url":"ftp://203.0.113.10/file","user_id":"user1"}`, http.StatusBadRequest},
+		{`{"url":"http://127.0.0.1/admin","user_id":"user1"}`, http.StatusUnprocessableEntity},
+		{`{"url":"https://sho.rt/abc123","user_id":"user1"}`, http.StatusUnprocessableEntity},
+	} {
+		rec := api.do(t, http.MethodPost, "/links", tc.body, nil)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status %d, want %d: %s", tc.body, rec.Code, tc.status, rec.Body)
+		}
+	}
+}
+
+// failingLinkStore fails every link insert while failing is set
+type failingLinkStore struct {
+	*db.MemoryStore
+	failing bool
+}
+
+func (s *failingLinkStore) CreateLink(ctx context.Context, link *models.Link) error {
+	if s.failing {
+		return errors.New("connection reset")
+	}
+	return s.MemoryStore.CreateLink(ctx, link)
+}
+
+func TestCreateLinkQuotaReleasedOnFailure(t *testing.T) {
+	store, cache := &failingLinkStore{MemoryStore: db.NewMemoryStore(), failing: true}, db.NewMemoryCache()
+	engine, err := policy.NewEngine(cache, "", 1, "https://sho.rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &testAPI{store: store.MemoryStore, cache: cache, router: CreateLink(store, cache, "https://sho.rt", engine, nil)}
+
+	body := fmt.Sprintf(`{"url":%q,"user_id":"user1"}`, testDestA)
+	if rec := api.do(t, http.MethodPost, "/links", body, nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("failed insert: status %d, want 500", rec.Code)
+	}
+
+	// The failed link gave its quota back, so the only one of the hour still fits
+	store.failing = false
+	api.createLink(t, "user1", testDestA)
+	if rec := api.do(t, http.MethodPost, "/links", body, nil); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second link: status %d, want 429", rec.Code)
+	}
+}
+
+func TestListLinks(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 3; i++ {
+		api.createLink(t, "user1", fmt.Sprintf("%s?n=%d", testDestA, i))
+	}
+	api.createLink(t, "user2", testDestB)
+
+	rec := api.do(t, http.MethodGet, "/links?user_id=user1&limit=2", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list links: status %d: %s", rec.Code, rec.Body)
+	}
+	page := decode[ListLinksResponse](t, rec)
+	if page.Total != 3 || len(page.Links) != 2 || page.NextCursor == nil {
+		t.Fatalf("first page: total %d, %d links, cursor %v", page.Total, len(page.Links), page.NextCursor)
+	}
+
+	rec = api.do(t, http.MethodGet, "/links?user_id=user1&limit=2&cursor="+*page.NextCursor, "", nil)
+	next := decode[ListLinksResponse](t, rec)
+	if len(next.Links) != 1 || next.NextCursor != nil {
+		t.Fatalf("second page: %d links, cursor %v", len(next.Links), next.NextCursor)
+	}
+	seen := map[string]bool{}
+	for _, link := range append(page.Links, next.Links...) {
+		if seen[link.ShortCode] || link.OriginalURL == testDestB {
+			t.Errorf("unexpected link %+v", link)
+		}
+		seen[link.ShortCode] = true
+	}
+
+	if rec := api.do(t, http.MethodGet, "/links", "", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("without user_id: status %d, want 400", rec.Code)
+	}
+}
+
+func TestListLinksSortAndFilter(t *testing.T) {
+	api := newTestAPI(t)
+	a := api.createLink(t, "user1", testDestA).ShortCode
+	b := api.createLink(t, "user1", testDestB).ShortCode
+	c := api.createLink(t, "user1", testDestA+"?c").ShortCode
+	api.click(t, a, time.Now(), "v1", "", 5)
+	api.click(t, c, time.Now(), "v1", "", 2)
+
+	codes := func(target string) string {
+		t.Helper()
+		rec := api.do(t, http.MethodGet, target, "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body)
+		}
+		var got []string
+		for _, link := range decode[ListLinksResponse](t, rec).Links {
+			got = append(got, link.ShortCode)
+		}
+		return strings.Join(got, ",")
+	}
+
+	if got, want := codes("/links?user_id=user1&sort=clicks"), strings.Join([]string{a, c, b}, ","); got != want {
+		t.Errorf("by clicks = %s, want %s", got, want)
+	}
+	if got, want := codes("/links?user_id=user1&sort=clicks&order=asc"), strings.Join([]string{b, c, a}, ","); got != want {
+		t.Errorf("by clicks ascending = %s, want %s", got, want)
+	}
+	if got, want := codes("/links?user_id=user1&sort=clicks&domain=203.0.113.10"), strings.Join([]string{a, c}, ","); got != want {
+		t.Errorf("by domain = %s, want %s", got, want)
+	}
+
+	// Pages sorted by clicks continue where the last one stopped
+	rec := api.do(t, http.MethodGet, "/links?user_id=user1&sort=clicks&limit=1", "", nil)
+	cursor := *decode[ListLinksResponse](t, rec).NextCursor
+	if got := codes("/links?user_id=user1&sort=clicks&limit=1&cursor=" + cursor); got != c {
+		t.Errorf("second page by clicks = %s, want %s", got, c)
+	}
+
+	for _, query := range []string{
+		"sort=title",
+		"order=up",
+		"limit=0",
+		"limit=201",
+		"cursor=!!!",
+		"cursor=" + cursor + "&order=asc", // cursor from another sort order
+		"from=2024-02-01&to=2024-01-01",
+	} {
+		if rec := api.do(t, http.MethodGet, "/links?user_id=user1&"+query, "", nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestCreateAndGetLink(t *testing.T) {
+	api := newTestAPI(t)
+	created := api.createLink(t, "user1", testDestA)
+	if created.ShortCode == "" || created.ShortURL != "https://sho.rt/"+created.ShortCode {
+		t.Fatalf("unexpected create response: %+v", created)
+	}
+
+	rec := api.do(t, http.MethodGet, "/links/"+created.ShortCode, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get link: status %d: %s", rec.Code, rec.Body)
+	}
+	link := decode[LinkResponse](t, rec)
+	if link.OriginalURL != testDestA {
+		t.Errorf("original_url = %q, want %q", link.OriginalURL, testDestA)
+	}
+
+	rec = api.do(t, http.MethodGet, "/links/nosuch", "", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown link: status %d, want 404", rec.Code)
+	}
+}
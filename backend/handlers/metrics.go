@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"link-analytics-service/db"
+	"link-analytics-service/metrics"
+	"link-analytics-service/middleware"
+	"runtime"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Prometheus metrics updated by the handlers (see also workers and middleware)
+var (
+	redirectDuration = metrics.NewHistogram("redirect_duration_seconds",
+		"Time to resolve a short code and send the redirect response", metrics.DefaultBuckets)
+	l1CacheHits = metrics.NewCounter("l1_cache_hits_total",
+		"Redirects served from the in-memory L1 cache")
+	l1CacheMisses = metrics.NewCounter("l1_cache_misses_total",
+		"Redirects that missed the L1 cache and fell back to PostgreSQL")
+
+	// AnalyticsEventsDropped counts click events lost before reaching the clicks table
+	AnalyticsEventsDropped = metrics.NewCounterVec("analytics_events_dropped_total",
+		"Click events dropped before being stored, by reason", "reason")
+)
+
+// RegisterMetrics registers gauges that read state owned by other components.
+// It must be called once at startup.
+func RegisterMetrics(store db.Store, cache db.Cache, broker *SSEBroker, clickFeed *ClickFeed) {
+	metrics.NewGaugeFunc("analytics_queue_depth", "Click events waiting for an analytics worker",
+		func() float64 { return float64(len(AnalyticsQueue)) })
+	metrics.NewGaugeFunc("analytics_queue_capacity", "Capacity of the analytics queue",
+		func() float64 { return float64(cap(AnalyticsQueue)) })
+	metrics.NewGaugeFunc("l1_cache_entries", "Links held in the in-memory L1 cache",
+		func() float64 { return float64(getL1CacheSize()) })
+
+	// Connection pool gauges only exist for backends with a pool
+	if pool, ok := store.(interface{ Stats() sql.DBStats }); ok {
+		metrics.NewGaugeFunc("db_open_connections", "Open PostgreSQL connections",
+			func() float64 { return float64(pool.Stats().OpenConnections) })
+		metrics.NewGaugeFunc("db_in_use_connections", "PostgreSQL connections currently in use",
+			func() float64 { return float64(pool.Stats().InUse) })
+		metrics.NewGaugeFunc("db_idle_connections", "Idle PostgreSQL connections",
+			func() float64 { return float64(pool.Stats().Idle) })
+		metrics.NewCounterFunc("db_wait_count_total", "Times a query waited for a PostgreSQL connection",
+			func() float64 { return float64(pool.Stats().WaitCount) })
+		metrics.NewCounterFunc("db_wait_duration_seconds_total", "Total time spent waiting for PostgreSQL connections",
+			func() float64 { return pool.Stats().WaitDuration.Seconds() })
+	}
+
+	if replicated, ok := store.(interface {
+		ReplicaStatus() (db.ReplicaStatus, bool)
+		ReplicaFallbacks() int64
+	}); ok {
+		if _, configured := replicated.ReplicaStatus(); configured {
+			metrics.NewGaugeFunc("db_replica_healthy", "1 if analytics reads go to the PostgreSQL read replica",
+				func() float64 {
+					status, _ := replicated.ReplicaStatus()
+					return boolGauge(status.Healthy)
+				})
+			metrics.NewGaugeFunc("db_replica_lag_seconds", "Replication lag of the read replica at the last check",
+				func() float64 {
+					status, _ := replicated.ReplicaStatus()
+					return status.Lag.Seconds()
+				})
+			metrics.NewCounterFunc("db_replica_fallbacks_total", "Replica queries that failed and were retried on the primary",
+				func() float64 { return float64(replicated.ReplicaFallbacks()) })
+		}
+	}
+
+	if pool, ok := cache.(interface{ PoolStats() *redis.PoolStats }); ok {
+		metrics.NewGaugeFunc("redis_pool_total_connections", "Connections in the Redis pool",
+			func() float64 { return float64(pool.PoolStats().TotalConns) })
+		metrics.NewGaugeFunc("redis_pool_idle_connections", "Idle connections in the Redis pool",
+			func() float64 { return float64(pool.PoolStats().IdleConns) })
+		metrics.NewCounterFunc("redis_pool_hits_total", "Times a free connection was found in the Redis pool",
+			func() float64 { return float64(pool.PoolStats().Hits) })
+		metrics.NewCounterFunc("redis_pool_misses_total", "Times a new Redis connection had to be created",
+			func() float64 { return float64(pool.PoolStats().Misses) })
+		metrics.NewCounterFunc("redis_pool_timeouts_total", "Times waiting for a Redis connection timed out",
+			func() float64 { return float64(pool.PoolStats().Timeouts) })
+	}
+
+	metrics.NewGaugeFunc("sse_subscribers", "Connected analytics stream subscribers on this instance",
+		func() float64 { return float64(broker.SubscriberCount()) })
+	metrics.NewCounterFunc("sse_slow_subscribers_dropped_total", "Stream subscribers disconnected for falling behind",
+		func() float64 { return float64(broker.SlowSubscribersDropped()) })
+	metrics.NewGaugeFunc("sse_cluster_fanout", "1 if stream events are shared with other instances through Redis",
+		func() float64 { return boolGauge(broker.ClusterFanoutActive()) })
+	metrics.NewCounterFunc("live_clicks_dropped_total", "Clicks sampled out of or dropped from the live click feed",
+		func() float64 { return float64(clickFeed.Dropped()) })
+
+	metrics.NewGaugeFunc("rate_limit_local_mode", "1 if rate limiting uses the in-process fallback instead of Redis",
+		func() float64 { return boolGauge(middleware.RateLimitMode() == middleware.RateLimitModeLocal) })
+	metrics.NewCounterFunc("rate_limit_fallback_activations_total", "Times the rate limiter switched to local mode",
+		func() float64 { return float64(middleware.RateLimitFallbackCount()) })
+
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+	metrics.NewGaugeFunc("process_uptime_seconds", "Seconds since the service started",
+		func() float64 { return time.Since(startTime).Seconds() })
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
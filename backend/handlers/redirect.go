@@ -3,13 +3,18 @@ package handlers
 import (
 	"context"
 	"link-analytics-service/db"
+	"link-analytics-service/logging"
+	"link-analytics-service/middleware"
 	"link-analytics-service/models"
+	"link-analytics-service/tracing"
 	"link-analytics-service/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AnalyticsQueue is the channel for async analytics processing
@@ -46,14 +51,14 @@ func setL1Cache(shortCode, url string, ttl time.Duration) {
 }
 
 // PrePopulateL1Cache loads all links from database into L1 cache at startup
-func PrePopulateL1Cache(pgDB *db.PostgresDB) {
+func PrePopulateL1Cache(store db.Store) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	log.Println("Pre-populating L1 cache with all links...")
-	links, err := pgDB.GetAllLinks(ctx)
+	slog.Info("pre-populating L1 cache with all links")
+	links, err := store.GetAllLinks(ctx)
 	if err != nil {
-		log.Printf("Warning: failed to pre-populate L1 cache: %v", err)
+		slog.Warn("failed to pre-populate L1 cache", "error", err)
 		return
 	}
 
@@ -63,17 +68,21 @@ func PrePopulateL1Cache(pgDB *db.PostgresDB) {
 		count++
 	}
 
-	log.Printf("Pre-populated L1 cache with %d links", count)
+	slog.Info("pre-populated L1 cache", "links", count)
 }
 
 // HandleRedirect handles the redirect request (critical path - optimized for performance)
-func HandleRedirect(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
+// clickFeed may be nil when the live click feed is disabled. Only the fraction of
+// redirects chosen by logSampler is logged; server errors are always logged.
+func HandleRedirect(store db.Store, cache db.Cache, clickFeed *ClickFeed, logSampler *logging.Sampler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
 		// Optimize: Extract short code first (before any other operations)
 		// Fast path extraction using strings.IndexByte (faster than loop)
 		path := r.URL.Path
 		if len(path) <= 1 {
-			http.NotFound(w, r)
+			writeError(w, r, http.StatusNotFound, models.ErrCodeNotFound, "link not found", nil)
 			return
 		}
 		
@@ -84,30 +93,40 @@ func HandleRedirect(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 		}
 		
 		if shortCode == "" {
-			http.NotFound(w, r)
+			writeError(w, r, http.StatusNotFound, models.ErrCodeNotFound, "link not found", nil)
 			return
 		}
 
+		// Span is a no-op unless tracing is enabled
+		ctx, span := tracing.StartHTTP(r, "redirect")
+		status := http.StatusFound
+		defer func() { tracing.EndHTTP(span, status) }()
+
 		// 1. Try in-memory L1 cache first (fastest, < 0.1ms)
 		// Since we pre-populate at startup, this should almost always hit
 		originalURL, found := getFromL1Cache(shortCode)
-		if !found {
-			// Only create context if we need to query database
-			ctx := r.Context()
+		if found {
+			l1CacheHits.Inc()
+		} else {
+			l1CacheMisses.Inc()
 			// L1 cache miss - fallback to PostgreSQL (skip Redis to save time)
 			// This should be rare if cache is properly pre-populated
 			queryCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond) // Fast timeout
 			defer cancel()
 			
-			link, err := pgDB.GetLinkByCode(queryCtx, shortCode)
+			link, err := store.GetLinkByCode(queryCtx, shortCode)
 			if err != nil {
 				if _, ok := err.(*models.NotFoundError); ok {
-					http.NotFound(w, r)
-					return
+					status = http.StatusNotFound
+					if logSampler.Sample() {
+						slog.InfoContext(ctx, "redirect", "short_code", shortCode, "status", status)
+					}
+				} else {
+					// writeErrorFrom logs it and counts it in the error metrics
+					status = http.StatusInternalServerError
 				}
-				log.Printf("Error getting link: %v", err)
-				IncrementErrorCount() // Track errors for metrics
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				writeErrorFrom(w, r.WithContext(ctx), err)
+				middleware.CountRequest("redirect", r.Method, status)
 				return
 			}
 
@@ -118,7 +137,7 @@ func HandleRedirect(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 			go func() {
 				bgCtx := context.Background()
 				cacheKey := "link:" + shortCode
-				if err := redisDB.Set(bgCtx, cacheKey, originalURL, 1*time.Hour); err != nil {
+				if err := cache.Set(bgCtx, cacheKey, originalURL, 1*time.Hour); err != nil {
 					// Non-critical, log but don't block
 				}
 			}()
@@ -128,6 +147,16 @@ func HandleRedirect(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 		// Using direct header write is faster than http.Redirect
 		w.Header().Set("Location", originalURL)
 		w.WriteHeader(http.StatusFound)
+		redirectDuration.Observe(time.Since(start).Seconds())
+		middleware.CountRequest("redirect", r.Method, http.StatusFound)
+		if logSampler.Sample() {
+			slog.InfoContext(ctx, "redirect",
+				"short_code", shortCode,
+				"status", status,
+				"l1_cache_hit", found,
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			)
+		}
 
 		// All operations below are async and happen after redirect response is sent
 		// This ensures the redirect happens as fast as possible
@@ -136,32 +165,47 @@ func HandleRedirect(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 		ipAddr := utils.ExtractIP(r)
 		userAgent := r.UserAgent()
 		referer := r.Referer()
+		country := utils.ExtractCountry(r)
+		// Carried with the click so the worker's flush span links back to this request
+		traceParent := tracing.TraceParent(ctx)
+		spanContext := span.SpanContext()
+		requestID := logging.RequestID(ctx)
 		
 		// Start goroutine with captured values
 		go func() {
 			// Hash visitor in goroutine (CPU-intensive operation)
 			visitorHash := utils.HashVisitor(ipAddr, userAgent)
+			clickedAt := time.Now()
+
+			clickFeed.Record(NewLiveClick(shortCode, clickedAt, country, userAgent, referer))
 
 			// Fire async analytics event (non-blocking)
 			select {
 			case AnalyticsQueue <- models.ClickEvent{
 				ShortCode:   shortCode,
-				Timestamp:   time.Now(),
+				Timestamp:   clickedAt,
 				IPAddress:   ipAddr,
 				UserAgent:   userAgent,
 				Referer:     referer,
 				VisitorHash: visitorHash,
+				RequestID:   requestID,
+				TraceParent: traceParent,
 			}:
 			default:
-				// Queue is full, log but don't block
-				log.Printf("Warning: analytics queue full, dropping event for %s", shortCode)
+				// Queue is full; the drop counter is exact, the log line is sampled
+				AnalyticsEventsDropped.Inc("queue_full")
+				if logSampler.Sample() {
+					slog.WarnContext(ctx, "analytics queue full, dropping event", "short_code", shortCode)
+				}
 			}
 
 			// Increment Redis counter for real-time updates (async to avoid blocking)
 			counterKey := "clicks:realtime:" + shortCode
-			bgCtx := context.Background()
-			if _, err := redisDB.Incr(bgCtx, counterKey); err != nil {
-				log.Printf("Warning: failed to increment counter: %v", err)
+			bgCtx := trace.ContextWithSpanContext(context.Background(), spanContext)
+			if _, err := cache.Incr(bgCtx, counterKey); err != nil {
+				if logSampler.Sample() {
+					slog.WarnContext(ctx, "failed to increment realtime counter", "short_code", shortCode, "error", err)
+				}
 			}
 		}()
 	}
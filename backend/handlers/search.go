@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultSearchResults = 20
+	maxSearchResults     = 100
+	maxSearchQueryLength = 200
+	maxSearchTerms       = 8
+	// Highlighted fields longer than this are cut to a snippet around the first match
+	maxSnippetLength = 160
+)
+
+type SearchLinksResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
+}
+
+type SearchResult struct {
+	ShortCode   string    `json:"short_code"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Title       string    `json:"title,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	TotalClicks int64     `json:"total_clicks"`
+	Rank        float64   `json:"rank"`
+	// Matched fields with the matches wrapped in <mark></mark>; the rest of the text is HTML-escaped
+	Highlights map[string]string `json:"highlights"`
+}
+
+// SearchLinks handles GET /api/links/search?user_id=...&q=...&limit=...
+func SearchLinks(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		userID := params.Get("user_id")
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id parameter required", nil)
+			return
+		}
+
+		text := strings.TrimSpace(params.Get("q"))
+		if text == "" {
+			writeErrorFrom(w, r, &models.ValidationError{Message: "q parameter required"})
+			return
+		}
+		if len(text) > maxSearchQueryLength {
+			writeErrorFrom(w, r, &models.ValidationError{Message: fmt.Sprintf("q must be at most %d characters", maxSearchQueryLength)})
+			return
+		}
+
+		limit := defaultSearchResults
+		if v := params.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchResults {
+				writeErrorFrom(w, r, &models.ValidationError{Message: fmt.Sprintf("limit must be between 1 and %d", maxSearchResults)})
+				return
+			}
+			limit = n
+		}
+
+		terms := searchTerms(text)
+		results, err := store.SearchLinks(r.Context(), models.LinkSearchQuery{
+			UserID: userID,
+			Text:   text,
+			Terms:  terms,
+			Limit:  limit,
+		})
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		response := SearchLinksResponse{Query: text, Results: make([]SearchResult, 0, len(results))}
+		for _, result := range results {
+			highlights := make(map[string]string)
+			for field, text := range map[string]string{
+				"short_code":   result.ShortCode,
+				"original_url": result.OriginalURL,
+				"title":        result.Title,
+				"description":  result.Description,
+				"tags":         strings.Join(result.Tags, ", "),
+			} {
+				if snippet, ok := highlight(text, terms); ok {
+					highlights[field] = snippet
+				}
+			}
+			response.Results = append(response.Results, SearchResult{
+				ShortCode:   result.ShortCode,
+				OriginalURL: result.OriginalURL,
+				CreatedAt:   result.CreatedAt,
+				Title:       result.Title,
+				Tags:        result.Tags,
+				TotalClicks: result.TotalClicks,
+				Rank:        result.Rank,
+				Highlights:  highlights,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// searchTerms splits a query into distinct lower-case words of letters and digits
+func searchTerms(text string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !seen[word] && len(terms) < maxSearchTerms {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// highlight wraps every occurrence of the terms in text with <mark></mark>, escaping
+// the rest for HTML. ok is false when no term occurs in text.
+func highlight(text string, terms []string) (snippet string, ok bool) {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lower-casing changed byte offsets; matching positions would be wrong
+		return "", false
+	}
+
+	type span struct{ start, end int }
+	var spans []span
+	for _, term := range terms {
+		for offset := 0; ; {
+			i := strings.Index(lower[offset:], term)
+			if i < 0 {
+				break
+			}
+			spans = append(spans, span{offset + i, offset + i + len(term)})
+			offset += i + len(term)
+		}
+	}
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	// Merge overlapping matches, e.g. "sale" and "sales"
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.start <= last.end {
+			last.end = max(last.end, s.end)
+		} else {
+			merged = append(merged, s)
+		}
+	}
+
+	// Long text is cut to a window starting a little before the first match
+	from, to := 0, len(text)
+	if len(text) > maxSnippetLength {
+		from = max(0, merged[0].start-maxSnippetLength/4)
+		to = min(len(text), from+maxSnippetLength)
+		// Do not cut through a multi-byte character
+		for from > 0 && !utf8.RuneStart(text[from]) {
+			from--
+		}
+		for to < len(text) && !utf8.RuneStart(text[to]) {
+			to--
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range merged {
+		if s.start >= to {
+			break
+		}
+		start, end := max(s.start, pos), min(s.end, to)
+		b.WriteString(html.EscapeString(text[pos:start]))
+		b.WriteString("<mark>" + html.EscapeString(text[start:end]) + "</mark>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchTerms(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{" -- !! ", nil},
+		{"Spring SALE, spring-sale!", []string{"spring", "sale"}},
+		{"Crème brûlée 2024", []string{"crème", "brûlée", "2024"}},
+		{"a b c d e f g h i j", []string{"a", "b", "c", "d", "e", "f", "g", "h"}},
+	} {
+		if got := searchTerms(tc.query); fmt.Sprint(got) != fmt.Sprint(tc.want) || len(got) != len(tc.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("a ", 100) + "Sale" + strings.Repeat(" b", 100)
+	wide := strings.Repeat("é", 100) + " sale " + strings.Repeat("ü", 100)
+
+	for _, tc := range []struct {
+		name  string
+		text  string
+		terms []string
+		want  string // empty when nothing matches
+	}{
+		{"no match", "Spring launch", []string{"sale"}, ""},
+		{"keeps case", "Big SALE today", []string{"sale"}, "Big <mark>SALE</mark> today"},
+		{"every occurrence", "sale, sale", []string{"sale"}, "<mark>sale</mark>, <mark>sale</mark>"},
+		{"overlapping terms", "Big Sales today", []string{"ale", "sales", "sale"}, "Big <mark>Sales</mark> today"},
+		{"adjacent terms", "springsale", []string{"sale", "spring"}, "<mark>springsale</mark>"},
+		{"separate terms", "spring - sale", []string{"sale", "spring"}, "<mark>spring</mark> - <mark>sale</mark>"},
+		{"escapes html", `<b>"Tom & Jerry"</b>`, []string{"tom"}, "&lt;b&gt;&#34;<mark>Tom</mark> &amp; Jerry&#34;&lt;/b&gt;"},
+		{"escapes inside marks", "a<sale>b", []string{"a<sale"}, "<mark>a&lt;sale</mark>&gt;b"},
+		{"multi-byte text", "Crème Brûlée", []string{"brûlée"}, "Crème <mark>Brûlée</mark>"},
+		{"lower-casing changes length", "İstanbul sale", []string{"sale"}, ""},
+		{"long text, match at the start", "sale" + strings.Repeat(" x", 100), []string{"sale"},
+			"<mark>sale</mark>" + strings.Repeat(" x", 78) + "…"},
+		{"long text, window around the match", long, []string{"sale"},
+			"…" + strings.Repeat("a ", 20) + "<mark>Sale</mark>" + strings.Repeat(" b", 58) + "…"},
+		{"long text, matches past the window", long + " sale", []string{"sale"},
+			"…" + strings.Repeat("a ", 20) + "<mark>Sale</mark>" + strings.Repeat(" b", 58) + "…"},
+		{"long multi-byte text", wide, []string{"sale"},
+			"…" + strings.Repeat("é", 20) + " <mark>sale</mark> " + strings.Repeat("ü", 57) + "…"},
+	} {
+		got, ok := highlight(tc.text, tc.terms)
+		if ok != (tc.want != "") || got != tc.want {
+			t.Errorf("%s: highlight = %q, %v, want %q", tc.name, got, ok, tc.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: snippet cuts through a character: %q", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"net/http"
+)
+
+// UserSettingsRequest is the body of PATCH /api/users/{user_id}/settings; absent fields are left as they are
+type UserSettingsRequest struct {
+	ReuseExistingLinks *bool `json:"reuse_existing_links"`
+}
+
+// GetUserSettings handles GET /api/users/{user_id}/settings
+func GetUserSettings(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		userID, ok := settingsUserID(w, r)
+		if !ok {
+			return
+		}
+		settings, err := store.GetUserSettings(r.Context(), userID)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}
+}
+
+// UpdateUserSettings handles PATCH /api/users/{user_id}/settings
+func UpdateUserSettings(store db.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			writeMethodNotAllowed(w, r)
+			return
+		}
+
+		userID, ok := settingsUserID(w, r)
+		if !ok {
+			return
+		}
+		var req UserSettingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Invalid request body", nil)
+			return
+		}
+
+		settings, err := store.GetUserSettings(r.Context(), userID)
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		if req.ReuseExistingLinks != nil {
+			settings.ReuseExistingLinks = *req.ReuseExistingLinks
+		}
+		if err := store.UpdateUserSettings(r.Context(), settings); err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+	}
+}
+
+// settingsUserID extracts the user_id from /api/users/{user_id}/settings
+func settingsUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := pathID(r.URL.Path, "users")
+	if userID == "" {
+		writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "user_id required", nil)
+		return "", false
+	}
+	return userID, true
+}
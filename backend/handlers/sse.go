@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"link-analytics-service/db"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SSE event types sent to analytics stream clients
+const (
+	EventSnapshot = "snapshot" // Current totals sent when a client subscribes
+	EventClicks   = "clicks"   // Updated totals after a worker flush
+
+	// Live click feed, only sent to subscribers that opted in. These events are
+	// best-effort: they are not kept for replay and are skipped for slow clients.
+	EventClick        = "click"         // A single click
+	EventClickSummary = "click_summary" // Coalesced clicks for a busy code
+)
+
+const (
+	replayBufferSize = 100              // Events kept per short code for Last-Event-ID resume
+	replayBufferTTL  = 10 * time.Minute // Buffers without new events are dropped after this
+	subscriberBuffer = 32
+
+	// Redis pub/sub channel used to fan analytics events out to every instance
+	clusterEventsChannel = "analytics:events"
+	// Backoff between attempts to subscribe to it
+	clusterRetryMin = time.Second
+	clusterRetryMax = time.Minute
+)
+
+// SSEEvent is a single message delivered to stream subscribers
+type SSEEvent struct {
+	ID        uint64 // 0 for events that can't be replayed (e.g. snapshots)
+	Type      string
+	ShortCode string
+	Data      []byte
+}
+
+// Subscriber is one streaming connection, possibly subscribed to several short codes
+type Subscriber struct {
+	Events    chan SSEEvent
+	codes     map[string]bool
+	clickFeed bool
+	done      chan struct{}
+	closed    bool
+}
+
+// Done is closed when the broker drops the subscriber because it fell too far behind.
+// The connection should be closed; the client resumes with Last-Event-ID.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// clusterMessage is the pub/sub envelope shared between instances. The ID is
+// assigned by the publishing instance, so every instance replays the event under
+// the same ID and a client can resume on any of them.
+type clusterMessage struct {
+	ID        uint64          `json:"id"`
+	Type      string          `json:"type"`
+	ShortCode string          `json:"short_code"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// replayBuffer is a bounded ring of recent events for a short code
+type replayBuffer struct {
+	events    []SSEEvent
+	evictedID uint64 // ID of the newest event dropped from the buffer
+	updatedAt time.Time
+}
+
+// SSEBroker manages Server-Sent Events connections
+type SSEBroker struct {
+	clients   map[string]map[*Subscriber]bool
+	replay    map[string]*replayBuffer
+	lastID    uint64
+	lastPrune time.Time
+	mu        sync.RWMutex
+
+	cache         db.Cache
+	clusterActive atomic.Bool
+	slowDropped   atomic.Int64
+}
+
+func NewSSEBroker() *SSEBroker {
+	return &SSEBroker{
+		clients:   make(map[string]map[*Subscriber]bool),
+		replay:    make(map[string]*replayBuffer),
+		lastPrune: time.Now(),
+	}
+}
+
+// NewSubscriber creates a subscriber that isn't listening to any short code yet
+func (b *SSEBroker) NewSubscriber() *Subscriber {
+	return &Subscriber{
+		Events: make(chan SSEEvent, subscriberBuffer),
+		codes:  make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+}
+
+// Subscribe adds short codes to a subscriber. If lastEventID is non-zero, buffered events
+// newer than it are returned in ID order, together with the codes whose buffer no longer
+// reaches back that far (those clients need a fresh snapshot instead).
+func (b *SSEBroker) Subscribe(sub *Subscriber, codes []string, lastEventID uint64) ([]SSEEvent, []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.closed {
+		return nil, nil
+	}
+
+	var missed []SSEEvent
+	var incomplete []string
+	for _, code := range codes {
+		if b.clients[code] == nil {
+			b.clients[code] = make(map[*Subscriber]bool)
+		}
+		b.clients[code][sub] = true
+		sub.codes[code] = true
+
+		if lastEventID == 0 {
+			continue
+		}
+		buf := b.replay[code]
+		if buf == nil || buf.evictedID > lastEventID {
+			incomplete = append(incomplete, code)
+			if buf == nil {
+				continue
+			}
+		}
+		for _, ev := range buf.events {
+			if ev.ID > lastEventID {
+				missed = append(missed, ev)
+			}
+		}
+	}
+
+	sort.Slice(missed, func(i, j int) bool { return missed[i].ID < missed[j].ID })
+	return missed, incomplete
+}
+
+// SetClickFeed turns the live per-click feed on or off for a subscriber
+func (b *SSEBroker) SetClickFeed(sub *Subscriber, enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub.clickFeed = enabled
+}
+
+// Unsubscribe removes short codes from a subscriber, or all of them if none are given
+func (b *SSEBroker) Unsubscribe(sub *Subscriber, codes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(codes) == 0 {
+		for code := range sub.codes {
+			codes = append(codes, code)
+		}
+	}
+
+	for _, code := range codes {
+		delete(sub.codes, code)
+		if clients, ok := b.clients[code]; ok {
+			delete(clients, sub)
+			if len(clients) == 0 {
+				delete(b.clients, code)
+			}
+		}
+	}
+}
+
+// StartClusterFanout subscribes this instance to the cluster event channel so events
+// published by any replica reach local subscribers. If the first attempt fails, its
+// error is returned and the subscription is retried in the background with backoff
+// until it succeeds or ctx ends. Until then, Publish only delivers locally.
+func (b *SSEBroker) StartClusterFanout(ctx context.Context, cache db.Cache) error {
+	err := b.subscribeCluster(ctx, cache)
+	if err == nil {
+		return nil
+	}
+
+	go func() {
+		backoff := clusterRetryMin
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			err := b.subscribeCluster(ctx, cache)
+			if err == nil {
+				slog.Info("cluster SSE fan-out enabled")
+				return
+			}
+			backoff = min(2*backoff, clusterRetryMax)
+			slog.Warn("cluster SSE fan-out still unavailable", "error", err, "retry_in", backoff)
+		}
+	}()
+	return err
+}
+
+func (b *SSEBroker) subscribeCluster(ctx context.Context, cache db.Cache) error {
+	err := cache.Subscribe(ctx, clusterEventsChannel, func(payload []byte) {
+		var msg clusterMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			slog.Warn("invalid cluster event", "error", err)
+			return
+		}
+		b.Broadcast(msg.ID, msg.ShortCode, msg.Type, msg.Data)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.cache = cache
+	b.mu.Unlock()
+	b.clusterActive.Store(true)
+	return nil
+}
+
+// Publish sends an event to subscribers on every instance. With cluster fan-out active
+// the event goes through Redis and comes back to this instance via the subscription;
+// otherwise, or if publishing fails, it is only delivered locally.
+func (b *SSEBroker) Publish(ctx context.Context, shortCode, eventType string, data []byte) {
+	b.mu.Lock()
+	id := b.nextID(time.Now())
+	cache := b.cache
+	b.mu.Unlock()
+
+	if b.clusterActive.Load() {
+		payload, err := json.Marshal(clusterMessage{ID: id, Type: eventType, ShortCode: shortCode, Data: data})
+		if err == nil {
+			if err = cache.Publish(ctx, clusterEventsChannel, payload); err == nil {
+				return
+			}
+		}
+		slog.WarnContext(ctx, "cluster publish failed, delivering locally only", "short_code", shortCode, "error", err)
+	}
+	b.Broadcast(id, shortCode, eventType, data)
+}
+
+// ClusterFanoutActive reports whether events are shared with other instances through Redis
+func (b *SSEBroker) ClusterFanoutActive() bool {
+	return b.clusterActive.Load()
+}
+
+// SlowSubscribersDropped returns how many subscribers were disconnected for falling behind
+func (b *SSEBroker) SlowSubscribersDropped() int64 {
+	return b.slowDropped.Load()
+}
+
+// SubscriberCount returns the number of connected subscribers on this instance
+func (b *SSEBroker) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	seen := make(map[*Subscriber]bool)
+	for _, clients := range b.clients {
+		for sub := range clients {
+			seen[sub] = true
+		}
+	}
+	return len(seen)
+}
+
+// Broadcast records the event with the ID its publisher gave it for replay and
+// delivers it to every local subscriber of shortCode. Subscribers whose buffer
+// is full are disconnected rather than silently missing the event; they can
+// resume from the replay buffer with Last-Event-ID.
+func (b *SSEBroker) Broadcast(id uint64, shortCode, eventType string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	// IDs this instance assigns later stay above those of events it has seen
+	b.lastID = max(b.lastID, id)
+	ev := SSEEvent{
+		ID:        id,
+		Type:      eventType,
+		ShortCode: shortCode,
+		Data:      data,
+	}
+
+	if eventType == EventClick || eventType == EventClickSummary {
+		for sub := range b.clients[shortCode] {
+			if !sub.clickFeed {
+				continue
+			}
+			select {
+			case sub.Events <- ev:
+			default:
+				// Live feed is best-effort, don't disconnect for it
+			}
+		}
+		return
+	}
+
+	buf := b.replay[shortCode]
+	if buf == nil {
+		buf = &replayBuffer{events: make([]SSEEvent, 0, replayBufferSize)}
+		b.replay[shortCode] = buf
+	}
+	if len(buf.events) == replayBufferSize {
+		// Events from other instances can arrive slightly out of ID order
+		buf.evictedID = max(buf.evictedID, buf.events[0].ID)
+		buf.events = append(buf.events[:0], buf.events[1:]...)
+	}
+	buf.events = append(buf.events, ev)
+	buf.updatedAt = now
+
+	if now.Sub(b.lastPrune) > replayBufferTTL {
+		b.pruneReplay(now)
+	}
+
+	for sub := range b.clients[shortCode] {
+		select {
+		case sub.Events <- ev:
+		default:
+			b.dropSlowSubscriber(sub)
+		}
+	}
+}
+
+// dropSlowSubscriber removes a subscriber from every code and signals Done.
+// Must be called with b.mu held.
+func (b *SSEBroker) dropSlowSubscriber(sub *Subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.done)
+
+	for code := range sub.codes {
+		if clients, ok := b.clients[code]; ok {
+			delete(clients, sub)
+			if len(clients) == 0 {
+				delete(b.clients, code)
+			}
+		}
+	}
+
+	b.slowDropped.Add(1)
+	slog.Warn("dropping slow stream subscriber, buffer full", "codes", len(sub.codes))
+}
+
+// nextID returns a strictly increasing event ID. IDs are based on the wall clock in
+// microseconds so they keep increasing across restarts and stay comparable between
+// instances. Must be called with b.mu held.
+func (b *SSEBroker) nextID(now time.Time) uint64 {
+	id := uint64(now.UnixMicro())
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	return id
+}
+
+// pruneReplay drops replay buffers for codes that haven't had events recently.
+// Must be called with b.mu held.
+func (b *SSEBroker) pruneReplay(now time.Time) {
+	for code, buf := range b.replay {
+		if now.Sub(buf.updatedAt) > replayBufferTTL {
+			delete(b.replay, code)
+		}
+	}
+	b.lastPrune = now
+}
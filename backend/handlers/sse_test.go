@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"link-analytics-service/db"
+)
+
+func receive(t *testing.T, sub *Subscriber) SSEEvent {
+	t.Helper()
+	select {
+	case ev := <-sub.Events:
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event delivered")
+		return SSEEvent{}
+	}
+}
+
+func TestClusterEventsShareIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := db.NewMemoryCache()
+
+	a, b := NewSSEBroker(), NewSSEBroker()
+	for _, broker := range []*SSEBroker{a, b} {
+		if err := broker.StartClusterFanout(ctx, cache); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b's clock is ahead of a's: its own IDs must not be reused for a's events
+	b.mu.Lock()
+	b.lastID = uint64(time.Now().Add(time.Hour).UnixMicro())
+	b.mu.Unlock()
+
+	subA, subB := a.NewSubscriber(), b.NewSubscriber()
+	a.Subscribe(subA, []string{"abc123"}, 0)
+	b.Subscribe(subB, []string{"abc123"}, 0)
+
+	a.Publish(ctx, "abc123", "stats", []byte(`{}`))
+	evA, evB := receive(t, subA), receive(t, subB)
+	if evA.ID == 0 || evA.ID != evB.ID {
+		t.Fatalf("event IDs differ between instances: %d and %d", evA.ID, evB.ID)
+	}
+
+	// A client that got the event from a can resume from b
+	subC := b.NewSubscriber()
+	missed, incomplete := b.Subscribe(subC, []string{"abc123"}, evA.ID)
+	if len(missed) != 0 || len(incomplete) != 0 {
+		t.Errorf("resuming on the other instance replayed %d events, incomplete %v", len(missed), incomplete)
+	}
+
+	// The next event b assigns still sorts after the one it received
+	b.Publish(ctx, "abc123", "stats", []byte(`{}`))
+	if next := receive(t, subB); next.ID <= evB.ID {
+		t.Errorf("next ID %d not after %d", next.ID, evB.ID)
+	}
+}
+
+// flakyCache fails the first subscription attempts
+type flakyCache struct {
+	*db.MemoryCache
+	failures atomic.Int32
+}
+
+func (c *flakyCache) Subscribe(ctx context.Context, channel string, handler func([]byte)) error {
+	if c.failures.Add(-1) >= 0 {
+		return errors.New("connection refused")
+	}
+	return c.MemoryCache.Subscribe(ctx, channel, handler)
+}
+
+func TestClusterFanoutRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache := &flakyCache{MemoryCache: db.NewMemoryCache()}
+	cache.failures.Store(1)
+
+	broker := NewSSEBroker()
+	if err := broker.StartClusterFanout(ctx, cache); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	if broker.ClusterFanoutActive() {
+		t.Fatal("fan-out active after a failed subscription")
+	}
+
+	deadline := time.Now().Add(3 * clusterRetryMin)
+	for !broker.ClusterFanoutActive() {
+		if time.Now().After(deadline) {
+			t.Fatal("fan-out not retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"link-analytics-service/db"
+	"link-analytics-service/logging"
 	"link-analytics-service/models"
+	"link-analytics-service/tracing"
 	"link-analytics-service/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -12,10 +14,10 @@ import (
 
 // TrackClick handles POST /api/track/{shortCode} - dedicated endpoint for tracking clicks
 // This is called by the frontend before redirecting
-func TrackClick(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
+func TrackClick(store db.Store, cache db.Cache, clickFeed *ClickFeed) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeMethodNotAllowed(w, r)
 			return
 		}
 
@@ -29,7 +31,7 @@ func TrackClick(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 		
 		shortCode := path
 		if shortCode == "" {
-			http.Error(w, "Short code required", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, models.ErrCodeInvalidRequest, "Short code required", nil)
 			return
 		}
 		
@@ -42,36 +44,37 @@ func TrackClick(pgDB *db.PostgresDB, redisDB *db.RedisDB) http.HandlerFunc {
 		}
 
 		// Verify link exists
-		_, err := pgDB.GetLinkByCode(ctx, shortCode)
+		_, err := store.GetLinkByCode(ctx, shortCode)
 		if err != nil {
-			if _, ok := err.(*models.NotFoundError); ok {
-				http.Error(w, "Link not found", http.StatusNotFound)
-				return
-			}
-			log.Printf("Error getting link for tracking: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeErrorFrom(w, r, err)
 			return
 		}
 
+		clickedAt := time.Now()
+		clickFeed.Record(NewLiveClick(shortCode, clickedAt, utils.ExtractCountry(r), r.UserAgent(), r.Referer()))
+
 		// Fire async analytics event (non-blocking)
 		select {
 		case AnalyticsQueue <- models.ClickEvent{
 			ShortCode:   shortCode,
-			Timestamp:   time.Now(),
+			Timestamp:   clickedAt,
 			IPAddress:   utils.ExtractIP(r),
 			UserAgent:   r.UserAgent(),
 			Referer:     r.Referer(),
 			VisitorHash: utils.HashVisitor(utils.ExtractIP(r), r.UserAgent()),
+			RequestID:   logging.RequestID(ctx),
+			TraceParent: tracing.TraceParent(ctx),
 		}:
 		default:
 			// Queue is full, log but don't block
-			log.Printf("Warning: analytics queue full, dropping event for %s", shortCode)
+			AnalyticsEventsDropped.Inc("queue_full")
+			slog.WarnContext(ctx, "analytics queue full, dropping event", "short_code", shortCode)
 		}
 
 		// Increment Redis counter for real-time updates
 		counterKey := "clicks:realtime:" + shortCode
-		if _, err := redisDB.Incr(ctx, counterKey); err != nil {
-			log.Printf("Warning: failed to increment counter: %v", err)
+		if _, err := cache.Incr(ctx, counterKey); err != nil {
+			slog.WarnContext(ctx, "failed to increment realtime counter", "short_code", shortCode, "error", err)
 		}
 
 		// Return success
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"link-analytics-service/db"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsMaxMessage   = 64 * 1024
+	wsCommandQueue = 16
+)
+
+// wsPingInterval must be shorter than wsPongTimeout. A variable so tests can shorten it.
+var wsPingInterval = 30 * time.Second
+
+// Client -> server actions
+const (
+	wsActionSubscribe   = "subscribe"
+	wsActionUnsubscribe = "unsubscribe"
+)
+
+// Server -> client events that aren't analytics events
+const (
+	wsEventSubscribed   = "subscribed"
+	wsEventUnsubscribed = "unsubscribed"
+	wsEventError        = "error"
+)
+
+// wsCommand is a message sent by the client
+type wsCommand struct {
+	Action      string   `json:"action"`
+	Codes       []string `json:"codes"`
+	LastEventID uint64   `json:"last_event_id,omitempty"`
+	ClickFeed   *bool    `json:"click_feed,omitempty"` // Optional, toggles the live per-click feed
+}
+
+// wsMessage is a message sent to the client. Analytics events use the same
+// type names and IDs as the SSE stream.
+type wsMessage struct {
+	ID        uint64          `json:"id,omitempty"`
+	Event     string          `json:"event"`
+	ShortCode string          `json:"short_code,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Codes     []string        `json:"codes,omitempty"`
+	Message   string          `json:"message,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     wsCheckOrigin,
+}
+
+// wsCheckOrigin only accepts the frontend and clients that send no Origin (not
+// browsers). Browsers don't apply CORS to WebSockets, so accepting any origin
+// would let other sites open the stream with the user's cookies.
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	allowedOrigin := os.Getenv("FRONTEND_URL")
+	if allowedOrigin == "" {
+		allowedOrigin = "http://localhost:3000"
+	}
+	return origin == "" || origin == allowedOrigin
+}
+
+// AnalyticsWebSocket handles GET /api/analytics/ws
+//
+// It offers the same subscriptions as StreamAnalytics for clients that can't use SSE.
+// Initial codes can be given with ?codes=, ?user_id= and ?last_event_id=; afterwards
+// the client sends {"action":"subscribe"|"unsubscribe","codes":[...]} messages.
+func AnalyticsWebSocket(store db.Store, cache db.Cache, broker *SSEBroker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		initialCodes, err := queryShortCodes(ctx, store, r.URL.Query())
+		if err != nil {
+			writeErrorFrom(w, r, err)
+			return
+		}
+		lastEventID, _ := strconv.ParseUint(r.URL.Query().Get("last_event_id"), 10, 64)
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already written an error response
+			slog.WarnContext(r.Context(), "websocket upgrade failed", "error", err)
+			return
+		}
+		defer conn.Close()
+
+		sub := broker.NewSubscriber()
+		defer broker.Unsubscribe(sub)
+		if r.URL.Query().Get("click_feed") == "true" {
+			broker.SetClickFeed(sub, true)
+		}
+
+		// The reader only decodes commands; all broker calls and writes happen in the
+		// loop below. When the loop is busy the command queue fills up and the reader
+		// stops reading, which pushes back on the client through TCP flow control.
+		commands := make(chan wsCommand, wsCommandQueue)
+		readerDone := make(chan struct{})
+		stop := make(chan struct{})
+		defer close(stop)
+		go wsReadCommands(conn, commands, readerDone, stop)
+
+		if len(initialCodes) > 0 {
+			// Handled here rather than queued: the reader may already have filled the queue
+			initial := wsCommand{Action: wsActionSubscribe, Codes: initialCodes, LastEventID: lastEventID}
+			if err := wsHandleCommand(ctx, conn, store, cache, broker, sub, initial); err != nil {
+				return
+			}
+		}
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case cmd := <-commands:
+				if err := wsHandleCommand(ctx, conn, store, cache, broker, sub, cmd); err != nil {
+					return
+				}
+			case ev := <-sub.Events:
+				if err := wsWrite(conn, wsMessage{ID: ev.ID, Event: ev.Type, ShortCode: ev.ShortCode, Data: ev.Data}); err != nil {
+					return
+				}
+			case <-sub.Done():
+				// Fell too far behind; the client reconnects with last_event_id
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			case <-readerDone:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// wsReadCommands reads client messages until the connection fails, stops answering
+// pings, or the handler stops
+func wsReadCommands(conn *websocket.Conn, commands chan<- wsCommand, done, stop chan struct{}) {
+	defer close(done)
+
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		var cmd wsCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					slog.Warn("websocket read error", "error", err)
+				}
+				return
+			}
+			// Malformed message: the connection is still usable, report it to the client
+			cmd = wsCommand{}
+		}
+
+		select {
+		case commands <- cmd:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// wsHandleCommand applies a client command. Only write errors are returned;
+// invalid commands are reported to the client.
+func wsHandleCommand(ctx context.Context, conn *websocket.Conn, store db.Store, cache db.Cache, broker *SSEBroker, sub *Subscriber, cmd wsCommand) error {
+	switch cmd.Action {
+	case wsActionSubscribe:
+		if len(cmd.Codes) == 0 {
+			return wsWrite(conn, wsMessage{Event: wsEventError, Message: "codes required"})
+		}
+		if len(sub.codes)+len(cmd.Codes) > maxStreamCodes {
+			return wsWrite(conn, wsMessage{Event: wsEventError, Message: "too many short codes (max " + strconv.Itoa(maxStreamCodes) + ")"})
+		}
+
+		if cmd.ClickFeed != nil {
+			broker.SetClickFeed(sub, *cmd.ClickFeed)
+		}
+		missed, incomplete := broker.Subscribe(sub, cmd.Codes, cmd.LastEventID)
+		if err := wsWrite(conn, wsMessage{Event: wsEventSubscribed, Codes: cmd.Codes}); err != nil {
+			return err
+		}
+
+		// Same snapshot rules as the SSE stream
+		snapshotCodes := cmd.Codes
+		if cmd.LastEventID != 0 {
+			snapshotCodes = incomplete
+		}
+		for _, shortCode := range snapshotCodes {
+			data, err := json.Marshal(currentTotals(ctx, store, cache, shortCode))
+			if err != nil {
+				slog.Error("failed to marshal snapshot", "short_code", shortCode, "error", err)
+				continue
+			}
+			if err := wsWrite(conn, wsMessage{Event: EventSnapshot, ShortCode: shortCode, Data: data}); err != nil {
+				return err
+			}
+		}
+		for _, ev := range missed {
+			if err := wsWrite(conn, wsMessage{ID: ev.ID, Event: ev.Type, ShortCode: ev.ShortCode, Data: ev.Data}); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case wsActionUnsubscribe:
+		if len(cmd.Codes) == 0 {
+			return wsWrite(conn, wsMessage{Event: wsEventError, Message: "codes required"})
+		}
+		broker.Unsubscribe(sub, cmd.Codes...)
+		return wsWrite(conn, wsMessage{Event: wsEventUnsubscribed, Codes: cmd.Codes})
+
+	default:
+		return wsWrite(conn, wsMessage{Event: wsEventError, Message: "unknown action, use subscribe or unsubscribe"})
+	}
+}
+
+func wsWrite(conn *websocket.Conn, msg wsMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(msg)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"link-analytics-service/db"
+	"link-analytics-service/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startWebSocket serves AnalyticsWebSocket on store and returns its ws:// URL
+func startWebSocket(t *testing.T, store db.Store, broker *SSEBroker) string {
+	t.Helper()
+	srv := httptest.NewServer(AnalyticsWebSocket(store, db.NewMemoryCache(), broker))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialWebSocket(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return msg
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	broker := NewSSEBroker()
+	conn := dialWebSocket(t, startWebSocket(t, db.NewMemoryStore(), broker)+"?codes=abc123")
+
+	if msg := readWS(t, conn); msg.Event != wsEventSubscribed || fmt.Sprint(msg.Codes) != "[abc123]" {
+		t.Fatalf("first message = %+v, want subscribed to abc123", msg)
+	}
+	snapshot := readWS(t, conn)
+	if snapshot.Event != EventSnapshot || snapshot.ShortCode != "abc123" {
+		t.Fatalf("second message = %+v, want a snapshot of abc123", snapshot)
+	}
+	var totals map[string]interface{}
+	if err := json.Unmarshal(snapshot.Data, &totals); err != nil || totals["total_clicks"] != float64(0) {
+		t.Errorf("snapshot data = %s", snapshot.Data)
+	}
+
+	broker.Publish(context.Background(), "abc123", EventClicks, []byte(`{"total_clicks":1}`))
+	if msg := readWS(t, conn); msg.Event != EventClicks || msg.ID == 0 || string(msg.Data) != `{"total_clicks":1}` {
+		t.Errorf("event = %+v", msg)
+	}
+
+	// Subscribing later gets its own confirmation and snapshot
+	conn.WriteJSON(wsCommand{Action: wsActionSubscribe, Codes: []string{"def456"}})
+	if msg := readWS(t, conn); msg.Event != wsEventSubscribed || fmt.Sprint(msg.Codes) != "[def456]" {
+		t.Fatalf("subscribe reply = %+v", msg)
+	}
+	if msg := readWS(t, conn); msg.Event != EventSnapshot || msg.ShortCode != "def456" {
+		t.Fatalf("snapshot = %+v", msg)
+	}
+}
+
+func TestWebSocketUnsubscribe(t *testing.T) {
+	broker := NewSSEBroker()
+	conn := dialWebSocket(t, startWebSocket(t, db.NewMemoryStore(), broker)+"?codes=abc123,def456")
+	for i := 0; i < 3; i++ {
+		readWS(t, conn) // subscribed and two snapshots
+	}
+
+	conn.WriteJSON(wsCommand{Action: wsActionUnsubscribe, Codes: []string{"abc123"}})
+	if msg := readWS(t, conn); msg.Event != wsEventUnsubscribed || fmt.Sprint(msg.Codes) != "[abc123]" {
+		t.Fatalf("unsubscribe reply = %+v", msg)
+	}
+
+	ctx := context.Background()
+	broker.Publish(ctx, "abc123", EventClicks, []byte(`{}`))
+	broker.Publish(ctx, "def456", EventClicks, []byte(`{}`))
+	if msg := readWS(t, conn); msg.ShortCode != "def456" {
+		t.Errorf("got an event for %s after unsubscribing from it", msg.ShortCode)
+	}
+
+	conn.WriteJSON(wsCommand{Action: wsActionUnsubscribe})
+	if msg := readWS(t, conn); msg.Event != wsEventError {
+		t.Errorf("unsubscribe without codes = %+v, want an error", msg)
+	}
+	conn.WriteJSON(wsCommand{Action: "bogus"})
+	if msg := readWS(t, conn); msg.Event != wsEventError {
+		t.Errorf("unknown action = %+v, want an error", msg)
+	}
+}
+
+func TestWebSocketCommandBurst(t *testing.T) {
+	url := startWebSocket(t, db.NewMemoryStore(), NewSSEBroker()) + "?codes=abc123"
+	conn := dialWebSocket(t, url)
+
+	// More commands than the queue holds, sent before reading anything
+	for i := 0; i < 3*wsCommandQueue; i++ {
+		if err := conn.WriteJSON(wsCommand{Action: wsActionUnsubscribe, Codes: []string{"abc123"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := readWS(t, conn); msg.Event != wsEventSubscribed {
+		t.Fatalf("first message = %+v, want the initial subscription", msg)
+	}
+	readWS(t, conn) // snapshot
+	for i := 0; i < 3*wsCommandQueue; i++ {
+		if msg := readWS(t, conn); msg.Event != wsEventUnsubscribed {
+			t.Fatalf("reply %d = %+v", i, msg)
+		}
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	interval := wsPingInterval
+	wsPingInterval = 50 * time.Millisecond
+	defer func() { wsPingInterval = interval }()
+
+	conn := dialWebSocket(t, startWebSocket(t, db.NewMemoryStore(), NewSSEBroker()))
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	// Control frames are handled while reading
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-pinged:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server sent no ping")
+	}
+}
+
+// stallingStore blocks snapshot reads until release is closed
+type stallingStore struct {
+	*db.MemoryStore
+	release chan struct{}
+}
+
+func (s *stallingStore) GetLinkStats(ctx context.Context, shortCode string) (*models.LinkStats, error) {
+	<-s.release
+	return s.MemoryStore.GetLinkStats(ctx, shortCode)
+}
+
+func TestWebSocketSlowClientClosed(t *testing.T) {
+	store := &stallingStore{MemoryStore: db.NewMemoryStore(), release: make(chan struct{})}
+	broker := NewSSEBroker()
+	conn := dialWebSocket(t, startWebSocket(t, store, broker)+"?codes=abc123")
+
+	// The handler is stuck on the snapshot, so events pile up until the broker gives up
+	if msg := readWS(t, conn); msg.Event != wsEventSubscribed {
+		t.Fatalf("first message = %+v", msg)
+	}
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.Publish(context.Background(), "abc123", EventClicks, []byte(`{}`))
+	}
+	close(store.release)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+			t.Fatalf("read error = %v, want close 1013", err)
+		}
+		return
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "https://app.example.com")
+	url := startWebSocket(t, db.NewMemoryStore(), NewSSEBroker())
+
+	for origin, allowed := range map[string]bool{
+		"":                        true,
+		"https://app.example.com": true,
+		"https://evil.example":    false,
+		"http://localhost:3000":   false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if allowed {
+			if err != nil {
+				t.Errorf("origin %q refused: %v", origin, err)
+				continue
+			}
+			conn.Close()
+		} else if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			if conn != nil {
+				conn.Close()
+			}
+			t.Errorf("origin %q accepted, want 403", origin)
+		}
+	}
+}
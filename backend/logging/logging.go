@@ -0,0 +1,96 @@
+// Package logging configures the structured logger (log/slog) used across the service.
+// Log lines written with a request context automatically carry the request ID
+// and, when tracing is enabled, the trace and span IDs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// Init installs the default slog logger. level is debug, info, warn or error;
+// format is json or text. The standard library log package is routed through it too.
+func Init(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q (use debug, info, warn or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (use json or text)", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// WithRequestID returns a context whose log lines carry requestID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID stored in ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Sampler lets through a fraction of calls, for log lines on paths
+// that are too hot to log every request
+type Sampler struct {
+	ratio float64
+}
+
+// NewSampler keeps ratio (0-1) of the calls to Sample
+func NewSampler(ratio float64) *Sampler {
+	return &Sampler{ratio: ratio}
+}
+
+// Sample reports whether this call should be logged
+func (s *Sampler) Sample() bool {
+	if s == nil || s.ratio <= 0 {
+		return false
+	}
+	return s.ratio >= 1 || rand.Float64() < s.ratio
+}
+
+// contextHandler adds request and trace identifiers from the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
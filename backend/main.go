@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"link-analytics-service/archive"
 	"link-analytics-service/config"
 	"link-analytics-service/db"
 	"link-analytics-service/handlers"
+	"link-analytics-service/logging"
+	"link-analytics-service/metrics"
 	"link-analytics-service/middleware"
+	"link-analytics-service/models"
+	"link-analytics-service/policy"
+	"link-analytics-service/tracing"
 	"link-analytics-service/workers"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,37 +31,135 @@ func main() {
 
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("failed to load configuration", err)
 	}
 
-	// Connect to PostgreSQL
-	pgDB, err := db.NewPostgresDB(cfg.DatabaseURL)
+	if err := logging.Init(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fatal("failed to configure logging", err)
+	}
+
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(cfg, os.Args[2:]); err != nil {
+				fatal("migration failed", err)
+			}
+			return
+		case "archive":
+			if err := runArchive(cfg, os.Args[2:]); err != nil {
+				fatal("archive failed", err)
+			}
+			return
+		case "restore":
+			if err := runRestore(cfg, os.Args[2:]); err != nil {
+				fatal("restore failed", err)
+			}
+			return
+		default:
+			fatal("unknown command", fmt.Errorf("%q (available: migrate, archive, restore)", os.Args[1]))
+		}
+	}
+
+	// Tracing is a no-op unless TRACING_EXPORTER is otlp or stdout
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracingExporter, cfg.TracingEndpoint, cfg.TracingSampleRatio)
 	if err != nil {
-		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
+		fatal("failed to initialize tracing", err)
 	}
-	defer pgDB.Close()
-	log.Println("Connected to PostgreSQL")
 
-	// Connect to Redis
-	redisDB, err := db.NewRedisDB(cfg.RedisURL)
+	// Connect to the database picked by the DATABASE_URL scheme
+	database, err := openDatabase(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		fatal("failed to connect to database", err)
 	}
-	defer redisDB.Close()
-	log.Println("Connected to Redis")
+	defer database.Close()
+	slog.Info("connected to database", "backend", cfg.DatabaseBackend)
 
-	// Initialize SSE broker
-	broker := handlers.NewSSEBroker()
+	// Optional read replica for analytics queries, used while it keeps up with the primary
+	if pg, ok := database.(*db.PostgresDB); ok && cfg.ReplicaURL != "" {
+		if err := pg.SetReplica(cfg.ReplicaURL, cfg.ReplicaMaxLag); err != nil {
+			fatal("failed to configure read replica", err)
+		}
+		status, _ := pg.ReplicaStatus()
+		slog.Info("read replica configured", "healthy", status.Healthy, "max_lag", cfg.ReplicaMaxLag, "reason", status.Error)
+	}
+
+	// Bring the schema up to date, or just report what is pending
+	if cfg.AutoMigrate {
+		migrateCtx, migrateCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		n, err := database.Migrate(migrateCtx)
+		migrateCancel()
+		if err != nil {
+			fatal("failed to migrate database", err)
+		}
+		slog.Info("database schema is up to date", "applied", n)
+	} else {
+		warnPendingMigrations(database)
+	}
+
+	// Connect to Redis; a SQLite install is single-node and keeps its cache,
+	// counters, rate limits and event fan-out in process instead
+	var cache db.Cache
+	if cfg.DatabaseBackend == config.DatabaseSQLite {
+		cache = db.NewMemoryCache()
+		slog.Info("using in-process cache instead of Redis")
+	} else {
+		redisDB, err := db.NewRedisDB(cfg.RedisURL)
+		if err != nil {
+			fatal("failed to connect to Redis", err)
+		}
+		defer redisDB.Close()
+		cache = redisDB
+		slog.Info("connected to Redis")
+	}
 
 	// Pre-populate L1 cache with all links for maximum performance
-	handlers.PrePopulateL1Cache(pgDB)
+	handlers.PrePopulateL1Cache(database)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Initialize SSE broker and share events with other instances through the cache's pub/sub
+	broker := handlers.NewSSEBroker()
+	if err := broker.StartClusterFanout(ctx, cache); err != nil {
+		slog.Warn("cluster SSE fan-out unavailable, events stay on this instance while it retries", "error", err)
+	}
+
+	// Optional live per-click feed (nil when disabled)
+	var clickFeed *handlers.ClickFeed
+	if cfg.LiveClickFeed {
+		clickFeed = handlers.NewClickFeed(broker)
+		go clickFeed.Run(ctx)
+	}
+
 	// Start analytics workers
-	go workers.StartWorkers(ctx, pgDB, redisDB, broker)
+	go workers.StartWorkers(ctx, database, cache, broker)
+
+	// Keep monthly clicks partitions ahead of time and apply the retention policy
+	// and, when ARCHIVE_URL is set, archive detached partitions
+	if partitioner, ok := database.(db.ClickPartitioner); ok {
+		var archiver *archive.Archiver
+		if cfg.ArchiveURL != "" && cfg.DetachExpiredClicks {
+			if archiver, err = newArchiver(cfg, database); err != nil {
+				fatal("failed to configure click archiving", err)
+			}
+		}
+		go workers.StartPartitionMaintenance(ctx, partitioner, archiver, cfg.ClickRetentionMonths, cfg.DetachExpiredClicks)
+	}
+
+	// Destination policy for link creation (blocklist is reloaded when the file changes)
+	policyEngine, err := policy.NewEngine(cache, cfg.BlocklistFile, cfg.LinkQuotaPerHour, cfg.BaseURL, cfg.FrontendURL)
+	if err != nil {
+		fatal("failed to initialize destination policy", err)
+	}
+	go policyEngine.WatchBlocklist(ctx, 10*time.Second)
+
+	// Optional redirect chain check; bounded well below the server's WriteTimeout
+	var redirectResolver *policy.RedirectResolver
+	if cfg.ResolveRedirects {
+		redirectResolver = policy.NewRedirectResolver(policyEngine, 5, 3*time.Second)
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -62,34 +167,151 @@ func main() {
 	// API endpoints - wrap handlers with middleware chain
 	// Register API routes FIRST so they take precedence
 	createLinkHandler := middleware.Chain(
-		handlers.CreateLink(pgDB, cfg.FrontendURL),
-		middleware.RateLimit(redisDB, 100, time.Minute),
+		handlers.CreateLink(database, cache, cfg.FrontendURL, policyEngine, redirectResolver),
+		middleware.Trace("create_link"),
+		middleware.Instrument("create_link"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	bulkCreateLinksHandler := middleware.Chain(
+		handlers.BulkCreateLinks(database, cache, cfg.FrontendURL, policyEngine, redirectResolver),
+		middleware.Trace("bulk_create_links"),
+		middleware.Instrument("bulk_create_links"),
+		middleware.RateLimit(cache, 100, time.Minute),
 		middleware.Logger,
 	)
 	getLinkHandler := middleware.Chain(
-		handlers.GetLink(pgDB),
-		middleware.RateLimit(redisDB, 100, time.Minute),
+		handlers.GetLink(database),
+		middleware.Trace("get_link"),
+		middleware.Instrument("get_link"),
+		middleware.RateLimit(cache, 100, time.Minute),
 		middleware.Logger,
 	)
 	listLinksHandler := middleware.Chain(
-		handlers.ListLinks(pgDB),
-		middleware.RateLimit(redisDB, 100, time.Minute),
+		handlers.ListLinks(database),
+		middleware.Trace("list_links"),
+		middleware.Instrument("list_links"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	searchLinksHandler := middleware.Chain(
+		handlers.SearchLinks(database),
+		middleware.Trace("search_links"),
+		middleware.Instrument("search_links"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	updateLinkHandler := middleware.Chain(
+		handlers.UpdateLink(database),
+		middleware.Trace("update_link"),
+		middleware.Instrument("update_link"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	listTagsHandler := middleware.Chain(
+		handlers.ListTags(database),
+		middleware.Trace("list_tags"),
+		middleware.Instrument("list_tags"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	getUserSettingsHandler := middleware.Chain(
+		handlers.GetUserSettings(database),
+		middleware.Trace("get_user_settings"),
+		middleware.Instrument("get_user_settings"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	updateUserSettingsHandler := middleware.Chain(
+		handlers.UpdateUserSettings(database),
+		middleware.Trace("update_user_settings"),
+		middleware.Instrument("update_user_settings"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	listFoldersHandler := middleware.Chain(
+		handlers.ListFolders(database),
+		middleware.Trace("list_folders"),
+		middleware.Instrument("list_folders"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	createFolderHandler := middleware.Chain(
+		handlers.CreateFolder(database),
+		middleware.Trace("create_folder"),
+		middleware.Instrument("create_folder"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	updateFolderHandler := middleware.Chain(
+		handlers.UpdateFolder(database),
+		middleware.Trace("update_folder"),
+		middleware.Instrument("update_folder"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	deleteFolderHandler := middleware.Chain(
+		handlers.DeleteFolder(database),
+		middleware.Trace("delete_folder"),
+		middleware.Instrument("delete_folder"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	groupAnalyticsHandler := middleware.Chain(
+		handlers.GetGroupAnalytics(database),
+		middleware.Trace("group_analytics"),
+		middleware.Instrument("group_analytics"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	analyticsOverviewHandler := middleware.Chain(
+		handlers.GetAnalyticsOverview(database),
+		middleware.Trace("analytics_overview"),
+		middleware.Instrument("analytics_overview"),
+		middleware.RateLimit(cache, 100, time.Minute),
 		middleware.Logger,
 	)
 	getAnalyticsHandler := middleware.Chain(
-		handlers.GetAnalytics(pgDB),
-		middleware.RateLimit(redisDB, 100, time.Minute),
+		handlers.GetAnalytics(database),
+		middleware.Trace("analytics"),
+		middleware.Instrument("analytics"),
+		middleware.RateLimit(cache, 100, time.Minute),
+		middleware.Logger,
+	)
+	// Exports are long and heavy, so fewer are allowed
+	exportAnalyticsHandler := middleware.Chain(
+		handlers.ExportAnalytics(database),
+		middleware.Trace("export_analytics"),
+		middleware.Instrument("export_analytics"),
+		middleware.RateLimit(cache, 10, time.Minute),
 		middleware.Logger,
 	)
 	// Stream handler - no logger middleware (SSE streams need immediate response)
-	streamAnalyticsHandler := handlers.StreamAnalytics(pgDB, redisDB, broker)
+	streamAnalyticsHandler := middleware.Chain(
+		handlers.StreamAnalytics(database, cache, broker),
+		middleware.Trace("stream"),
+		middleware.Instrument("stream"),
+	)
+	// WebSocket handler - no logger middleware (the connection is hijacked)
+	analyticsWebSocketHandler := middleware.Chain(
+		handlers.AnalyticsWebSocket(database, cache, broker),
+		middleware.Trace("websocket"),
+		middleware.Instrument("websocket"),
+	)
 	trackClickHandler := middleware.Chain(
-		handlers.TrackClick(pgDB, redisDB),
+		handlers.TrackClick(database, cache, clickFeed),
+		middleware.Trace("track_click"),
+		middleware.Instrument("track_click"),
 		middleware.Logger,
 	)
 
 	// Health and metrics endpoints (no middleware for performance)
 	// Register these directly on mux before the catch-all handler
+	handlers.RegisterMetrics(database, cache, broker, clickFeed)
+	mux.HandleFunc("/health", handlers.Health())
+	mux.HandleFunc("/ready", handlers.Readiness(database, cache))
+	mux.HandleFunc("/metrics", metrics.Handler())                        // Prometheus text format
+	mux.HandleFunc("/metrics/json", handlers.Metrics(broker, clickFeed)) // Human-readable summary
 
 	// Create a custom API router that manually handles routing
 	// This gives us full control over path matching and CORS
@@ -104,20 +326,48 @@ func main() {
 		switch {
 		case r.Method == http.MethodPost && path == "/links":
 			createLinkHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodPost && path == "/links/bulk":
+			bulkCreateLinksHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/links/search":
+			searchLinksHandler.ServeHTTP(w, r)
 		case r.Method == http.MethodGet && strings.HasPrefix(path, "/links/") && path != "/links":
 			// Extract shortCode from /links/{shortCode}
 			getLinkHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodPatch && strings.HasPrefix(path, "/links/"):
+			updateLinkHandler.ServeHTTP(w, r)
 		case r.Method == http.MethodGet && path == "/links":
 			listLinksHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/tags":
+			listTagsHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/settings"):
+			getUserSettingsHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodPatch && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/settings"):
+			updateUserSettingsHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/folders":
+			listFoldersHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodPost && path == "/folders":
+			createFolderHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodPatch && strings.HasPrefix(path, "/folders/"):
+			updateFolderHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/folders/"):
+			deleteFolderHandler.ServeHTTP(w, r)
 		case r.Method == http.MethodPost && strings.HasPrefix(path, "/track/"):
 			// Track click endpoint
 			trackClickHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/analytics":
+			groupAnalyticsHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/analytics/overview":
+			analyticsOverviewHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && path == "/analytics/ws":
+			analyticsWebSocketHandler.ServeHTTP(w, r)
 		case r.Method == http.MethodGet && strings.HasSuffix(path, "/stream") && strings.HasPrefix(path, "/analytics/"):
 			streamAnalyticsHandler.ServeHTTP(w, r)
+		case r.Method == http.MethodGet && strings.HasSuffix(path, "/export") && strings.HasPrefix(path, "/analytics/"):
+			exportAnalyticsHandler.ServeHTTP(w, r)
 		case r.Method == http.MethodGet && strings.HasPrefix(path, "/analytics/"):
 			getAnalyticsHandler.ServeHTTP(w, r)
 		default:
-			http.NotFound(w, r)
+			middleware.WriteError(w, r, http.StatusNotFound, models.ErrCodeNotFound, "Not found", nil)
 		}
 	})
 	
@@ -129,21 +379,23 @@ func main() {
 	
 	// Redirect endpoint (no middleware for performance)
 	// Register AFTER API routes as catch-all for short codes
-	redirectHandler := handlers.HandleRedirect(pgDB, redisDB)
+	redirectHandler := handlers.HandleRedirect(database, cache, clickFeed, logging.NewSampler(cfg.LogRedirectSampleRatio))
 
 	// Optimized routing: Check path prefix first to avoid mux.Handler overhead for redirects
 	// This is critical for performance - most requests are redirects
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
 		
+		opsPath := isOpsPath(path)
+
 		// Track request count for metrics (skip for health endpoints)
-		if path != "/health" && path != "/ready" && path != "/metrics" {
+		if !opsPath {
 			handlers.IncrementRequestCount()
 		}
 		
 		// Fast path: Most requests are redirects (not /api/ routes)
 		// Check prefix first to avoid expensive mux.Handler call
-		if !strings.HasPrefix(path, "/api") && path != "/health" && path != "/ready" && path != "/metrics" {
+		if !strings.HasPrefix(path, "/api") && !opsPath {
 			// This is likely a redirect request
 			if r.Method == http.MethodGet && path != "/" && len(path) > 1 {
 				redirectHandler(w, r)
@@ -158,19 +410,21 @@ func main() {
 	// Create server with optimized settings for high performance
 	server := &http.Server{
 		Addr:           ":" + cfg.Port,
-		Handler:        handler,
+		Handler:        middleware.RequestID(middleware.Recover(handlers.IncrementErrorCount)(handler)),
 		ReadTimeout:    5 * time.Second,   // Reduced for faster connection recycling
 		WriteTimeout:   5 * time.Second,   // Reduced for faster response
 		IdleTimeout:    120 * time.Second, // Increased for connection reuse
 		MaxHeaderBytes: 1 << 20,          // 1MB max header size
 		// Note: No ReadHeaderTimeout needed for redirects (simple requests)
+		// Long routes (bulk creation, analytics export) move their own deadlines
+		// with http.ResponseController rather than raising these for everyone
 	}
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("Server starting on port %s", cfg.Port)
+		slog.Info("server starting", "port", cfg.Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+			fatal("server failed to start", err)
 		}
 	}()
 
@@ -179,7 +433,7 @@ func main() {
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
 
 	// Cancel worker context
 	cancel()
@@ -192,8 +446,50 @@ func main() {
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		slog.Error("server shutdown error", "error", err)
+	}
+
+	// Flush spans still buffered by the exporter
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("tracing shutdown error", "error", err)
+	}
+
+	slog.Info("server stopped")
+}
+
+// openDatabase connects to the storage backend selected in cfg
+func openDatabase(cfg *config.Config) (db.Database, error) {
+	if cfg.DatabaseBackend == config.DatabaseSQLite {
+		return db.NewSQLiteDB(cfg.DatabaseURL)
 	}
+	return db.NewPostgresDB(cfg.DatabaseURL)
+}
+
+// warnPendingMigrations logs migrations that have not been applied yet
+func warnPendingMigrations(database db.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := database.MigrationStatus(ctx)
+	if err != nil {
+		slog.Warn("failed to check migration status", "error", err)
+		return
+	}
+	for _, s := range status {
+		if !s.Applied {
+			slog.Warn("pending database migration, run `migrate up` or set AUTO_MIGRATE=true",
+				"version", s.Version, "name", s.Name)
+		}
+	}
+}
+
+// fatal logs a startup error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
 
-	log.Println("Server stopped")
+// isOpsPath reports whether path is a health or metrics endpoint rather than a short code
+func isOpsPath(path string) bool {
+	return path == "/health" || path == "/ready" || path == "/metrics" || path == "/metrics/json"
 }
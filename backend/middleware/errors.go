@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"encoding/json"
+	"link-analytics-service/logging"
+	"link-analytics-service/models"
+	"net/http"
+)
+
+// WriteError writes the standard JSON error envelope. details is optional
+// extra context (e.g. policy reasons) and is omitted when nil.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: logging.RequestID(r.Context()),
+	})
+}
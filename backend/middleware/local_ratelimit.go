@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	localLimiterShards = 32
+	// Buckets untouched for this long are evicted on the next sweep of their shard
+	localLimiterIdleTTL = 5 * time.Minute
+)
+
+// LocalLimiter is an in-process token bucket rate limiter.
+// It is used as a fallback when Redis is unavailable, so each instance
+// enforces the limit on its own traffic instead of failing open.
+// Keys are spread over shards to keep lock contention low under load.
+type LocalLimiter struct {
+	shards   [localLimiterShards]limiterShard
+	capacity float64
+	rate     float64 // tokens per second
+	idleTTL  time.Duration
+}
+
+type limiterShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// NewLocalLimiter creates a limiter allowing limit requests per window for each key
+func NewLocalLimiter(limit int, window time.Duration) *LocalLimiter {
+	l := &LocalLimiter{
+		capacity: float64(limit),
+		rate:     float64(limit) / window.Seconds(),
+		idleTTL:  localLimiterIdleTTL,
+	}
+	if window > l.idleTTL {
+		l.idleTTL = window
+	}
+	now := time.Now()
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[string]*tokenBucket)
+		l.shards[i].lastSweep = now
+	}
+	return l
+}
+
+// Allow consumes a token for key. When the bucket is empty it returns false
+// and how long the caller should wait before the next token is available.
+func (l *LocalLimiter) Allow(key string) (bool, time.Duration) {
+	shard := l.shardFor(key)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if now.Sub(shard.lastSweep) > l.idleTTL {
+		shard.sweep(now, l.idleTTL)
+	}
+
+	b, ok := shard.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.capacity, lastSeen: now}
+		shard.buckets[key] = b
+	} else {
+		// Refill based on elapsed time, capped at capacity
+		b.tokens += now.Sub(b.lastSeen).Seconds() * l.rate
+		if b.tokens > l.capacity {
+			b.tokens = l.capacity
+		}
+		b.lastSeen = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Len returns the number of tracked buckets across all shards
+func (l *LocalLimiter) Len() int {
+	total := 0
+	for i := range l.shards {
+		l.shards[i].mu.Lock()
+		total += len(l.shards[i].buckets)
+		l.shards[i].mu.Unlock()
+	}
+	return total
+}
+
+func (l *LocalLimiter) shardFor(key string) *limiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l.shards[h.Sum32()%localLimiterShards]
+}
+
+// sweep removes idle buckets. Must be called with the shard lock held.
+func (s *limiterShard) sweep(now time.Time, idleTTL time.Duration) {
+	for key, b := range s.buckets {
+		if now.Sub(b.lastSeen) > idleTTL {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"link-analytics-service/db"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// age moves a bucket's last refill back by d, as if that much time had passed
+func (l *LocalLimiter) age(key string, d time.Duration) {
+	shard := l.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if b, ok := shard.buckets[key]; ok {
+		b.lastSeen = b.lastSeen.Add(-d)
+	}
+}
+
+func TestLocalLimiterBurstAndRefill(t *testing.T) {
+	l := NewLocalLimiter(3, 3*time.Second) // one token a second
+
+	// A full bucket allows a burst of the whole limit
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("after the burst: allowed %v, wait %v, want refused within a second", ok, wait)
+	}
+
+	// Tokens come back at the window's rate
+	l.age("a", time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refused after a token refilled")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("allowed more than the refilled token")
+	}
+
+	// but never beyond the limit, however long the key was idle
+	l.age("a", time.Minute)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d after idling refused", i+1)
+		}
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("idle bucket refilled beyond the limit")
+	}
+}
+
+func TestLocalLimiterKeysAreIndependent(t *testing.T) {
+	l := NewLocalLimiter(1, time.Minute)
+	if ok, _ := l.Allow("ratelimit:10.0.0.1:/api/links"); !ok {
+		t.Fatal("first request refused")
+	}
+	if ok, _ := l.Allow("ratelimit:10.0.0.1:/api/links"); ok {
+		t.Error("second request on the same key allowed")
+	}
+	for _, key := range []string{"ratelimit:10.0.0.2:/api/links", "ratelimit:10.0.0.1:/api/links/bulk"} {
+		if ok, _ := l.Allow(key); !ok {
+			t.Errorf("%s limited by another key", key)
+		}
+	}
+	if n := l.Len(); n != 3 {
+		t.Errorf("%d buckets, want 3", n)
+	}
+
+	// Idle buckets are dropped on the next sweep of their shard
+	for _, key := range []string{"ratelimit:10.0.0.1:/api/links", "ratelimit:10.0.0.2:/api/links", "ratelimit:10.0.0.1:/api/links/bulk"} {
+		l.age(key, 2*l.idleTTL)
+		shard := l.shardFor(key)
+		shard.mu.Lock()
+		shard.sweep(time.Now(), l.idleTTL)
+		shard.mu.Unlock()
+	}
+	if n := l.Len(); n != 0 {
+		t.Errorf("%d buckets left after the sweep, want 0", n)
+	}
+}
+
+// outageCache is a MemoryCache whose counters fail while down is set, as Redis does in an outage
+type outageCache struct {
+	*db.MemoryCache
+	down atomic.Bool
+}
+
+func (c *outageCache) Incr(ctx context.Context, key string) (int64, error) {
+	if c.down.Load() {
+		return 0, errors.New("connection refused")
+	}
+	return c.MemoryCache.Incr(ctx, key)
+}
+
+func TestRateLimitFallsBackToLocal(t *testing.T) {
+	localFallbackActive.Store(false)
+	lastRedisProbe.Store(0)
+	inProcessCache.Store(false)
+	defer localFallbackActive.Store(false)
+
+	cache := &outageCache{MemoryCache: db.NewMemoryCache()}
+	handler := RateLimit(cache, 2, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+	expect := func(path string, want ...int) {
+		t.Helper()
+		for i, code := range want {
+			if got := status(path); got != code {
+				t.Fatalf("%s request %d: status %d, want %d", path, i+1, got, code)
+			}
+		}
+	}
+
+	expect("/api/links", http.StatusOK, http.StatusOK, http.StatusTooManyRequests)
+	if mode := RateLimitMode(); mode != RateLimitModeRedis {
+		t.Fatalf("mode %q with Redis up", mode)
+	}
+
+	// Redis goes down: the local limiter takes over and still enforces the limit
+	activations := RateLimitFallbackCount()
+	cache.down.Store(true)
+	expect("/api/links/bulk", http.StatusOK, http.StatusOK, http.StatusTooManyRequests)
+	if mode := RateLimitMode(); mode != RateLimitModeLocal {
+		t.Errorf("mode %q during the outage, want %q", mode, RateLimitModeLocal)
+	}
+	if n := RateLimitFallbackCount() - activations; n != 1 {
+		t.Errorf("%d fallback activations, want 1", n)
+	}
+
+	// Once Redis is back, the next probe switches over and Redis counts again
+	cache.down.Store(false)
+	expect("/api/folders", http.StatusOK)
+	if mode := RateLimitMode(); mode != RateLimitModeLocal {
+		t.Errorf("switched back before the probe interval passed")
+	}
+	lastRedisProbe.Store(0)
+	expect("/api/folders", http.StatusOK, http.StatusOK, http.StatusTooManyRequests)
+	if mode := RateLimitMode(); mode != RateLimitModeRedis {
+		t.Errorf("mode %q after Redis recovered, want %q", mode, RateLimitModeRedis)
+	}
+}
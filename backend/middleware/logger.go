@@ -1,20 +1,14 @@
 package middleware
 
 import (
-	"encoding/json"
-	"log"
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"time"
 )
 
-type logEntry struct {
-	Method     string        `json:"method"`
-	Path       string        `json:"path"`
-	Status     int           `json:"status"`
-	Duration   time.Duration `json:"duration_ms"`
-	RemoteAddr string        `json:"remote_addr"`
-}
-
 // Logger middleware logs HTTP requests
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,16 +29,17 @@ func Logger(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		entry := logEntry{
-			Method:     r.Method,
-			Path:       r.URL.Path,
-			Status:     wrapped.statusCode,
-			Duration:   duration,
-			RemoteAddr: r.RemoteAddr,
+		level := slog.LevelInfo
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
 		}
-
-		jsonData, _ := json.Marshal(entry)
-		log.Println(string(jsonData))
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", wrapped.statusCode,
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+		)
 	})
 }
 
@@ -58,3 +53,25 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers work through the wrapper
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades work through the wrapper
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.statusCode = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"link-analytics-service/metrics"
+	"net/http"
+	"strconv"
+)
+
+var httpRequests = metrics.NewCounterVec("http_requests_total",
+	"HTTP requests by route, method and status code", "route", "method", "status")
+
+// Instrument counts requests for a named route by method and status code
+func Instrument(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(wrapped, r)
+			CountRequest(route, r.Method, wrapped.statusCode)
+		})
+	}
+}
+
+// CountRequest records a request for handlers that don't go through Instrument
+// (the redirect hot path counts itself to avoid wrapping the ResponseWriter)
+func CountRequest(route, method string, status int) {
+	httpRequests.Inc(route, method, statusLabel(status))
+}
+
+// statusLabel avoids an allocation for the most common status codes
+func statusLabel(status int) string {
+	switch status {
+	case http.StatusOK:
+		return "200"
+	case http.StatusCreated:
+		return "201"
+	case http.StatusFound:
+		return "302"
+	case http.StatusBadRequest:
+		return "400"
+	case http.StatusNotFound:
+		return "404"
+	case http.StatusTooManyRequests:
+		return "429"
+	case http.StatusInternalServerError:
+		return "500"
+	default:
+		return strconv.Itoa(status)
+	}
+}
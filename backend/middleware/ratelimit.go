@@ -3,15 +3,59 @@ package middleware
 import (
 	"fmt"
 	"link-analytics-service/db"
+	"link-analytics-service/models"
 	"link-analytics-service/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-// RateLimit middleware implements rate limiting using Redis
-func RateLimit(redisDB *db.RedisDB, limit int, window time.Duration) func(http.Handler) http.Handler {
+// Rate limiter modes reported by RateLimitMode
+const (
+	RateLimitModeRedis  = "redis"
+	RateLimitModeLocal  = "local"
+	RateLimitModeMemory = "memory" // single-node install counting in db.MemoryCache
+)
+
+// How often a request is allowed to probe Redis while running on the local limiter.
+// Other requests skip Redis entirely so an outage doesn't add latency to every call.
+const redisProbeInterval = 5 * time.Second
+
+// Fallback state is shared by every RateLimit instance since they all use the same Redis
+var (
+	localFallbackActive atomic.Bool
+	lastRedisProbe      atomic.Int64 // unix nanoseconds
+	fallbackActivations atomic.Int64
+	inProcessCache      atomic.Bool
+)
+
+// RateLimitMode returns which limiter is currently enforcing limits ("redis", "local" or "memory")
+func RateLimitMode() string {
+	if inProcessCache.Load() {
+		return RateLimitModeMemory
+	}
+	if localFallbackActive.Load() {
+		return RateLimitModeLocal
+	}
+	return RateLimitModeRedis
+}
+
+// RateLimitFallbackCount returns how many times the limiter has switched to local mode
+func RateLimitFallbackCount() int64 {
+	return fallbackActivations.Load()
+}
+
+// RateLimit middleware implements rate limiting using Redis.
+// If Redis is unavailable it falls back to an in-process limiter and
+// switches back once a probe request succeeds against Redis again.
+func RateLimit(cache db.Cache, limit int, window time.Duration) func(http.Handler) http.Handler {
+	local := NewLocalLimiter(limit, window)
+	if _, ok := cache.(*db.MemoryCache); ok {
+		inProcessCache.Store(true)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Skip rate limiting for redirect endpoint (performance)
@@ -31,25 +75,34 @@ func RateLimit(redisDB *db.RedisDB, limit int, window time.Duration) func(http.H
 
 			key := fmt.Sprintf("ratelimit:%s:%s", ip, r.URL.Path)
 
+			if localFallbackActive.Load() && !shouldProbeRedis() {
+				allowLocal(local, key, w, r, next)
+				return
+			}
+
 			ctx := r.Context()
-			count, err := redisDB.Incr(ctx, key)
+			count, err := cache.Incr(ctx, key)
 			if err != nil {
-				// If Redis fails, allow the request (fail open)
-				log.Printf("Rate limit check failed: %v", err)
-				next.ServeHTTP(w, r)
+				if localFallbackActive.CompareAndSwap(false, true) {
+					fallbackActivations.Add(1)
+					lastRedisProbe.Store(time.Now().UnixNano())
+					slog.WarnContext(r.Context(), "rate limit check failed, switching to local limiter", "error", err)
+				}
+				allowLocal(local, key, w, r, next)
 				return
 			}
 
+			if localFallbackActive.CompareAndSwap(true, false) {
+				slog.InfoContext(r.Context(), "redis is reachable again, switching rate limiter back to redis")
+			}
+
 			// Set TTL on first request
 			if count == 1 {
-				redisDB.Set(ctx, key, strconv.FormatInt(count, 10), window)
+				cache.Set(ctx, key, strconv.FormatInt(count, 10), window)
 			}
 
 			if count > int64(limit) {
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
-				w.WriteHeader(http.StatusTooManyRequests)
-				fmt.Fprintf(w, `{"error":"Rate limit exceeded"}`)
+				writeRateLimited(w, r, window)
 				return
 			}
 
@@ -58,3 +111,30 @@ func RateLimit(redisDB *db.RedisDB, limit int, window time.Duration) func(http.H
 	}
 }
 
+// shouldProbeRedis lets one request through to Redis per probe interval
+func shouldProbeRedis() bool {
+	now := time.Now().UnixNano()
+	last := lastRedisProbe.Load()
+	if now-last < int64(redisProbeInterval) {
+		return false
+	}
+	return lastRedisProbe.CompareAndSwap(last, now)
+}
+
+func allowLocal(local *LocalLimiter, key string, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	allowed, retryAfter := local.Allow(key)
+	if !allowed {
+		writeRateLimited(w, r, retryAfter)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+func writeRateLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	WriteError(w, r, http.StatusTooManyRequests, models.ErrCodeRateLimited, "Rate limit exceeded", nil)
+}
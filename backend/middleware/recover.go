@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"link-analytics-service/models"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic in a handler into a logged 500 response instead of a dropped
+// connection. onPanic is called for each recovered panic (e.g. to count it as an error).
+func Recover(onPanic func()) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &recoverWriter{ResponseWriter: w}
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					// Deliberate abort; let net/http close the connection quietly
+					panic(rec)
+				}
+
+				slog.ErrorContext(r.Context(), "panic serving request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"panic", fmt.Sprint(rec),
+					"stack", string(debug.Stack()),
+				)
+				if onPanic != nil {
+					onPanic()
+				}
+				// Too late for an error body if the handler already started responding
+				if !wrapped.wroteHeader {
+					WriteError(w, r, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error", nil)
+				}
+			}()
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
+
+// recoverWriter records whether the response has started
+type recoverWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *recoverWriter) WriteHeader(code int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recoverWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers work through the wrapper
+func (rw *recoverWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		rw.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades work through the wrapper
+func (rw *recoverWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	rw.wroteHeader = true
+	return h.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *recoverWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
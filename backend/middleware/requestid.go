@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"link-analytics-service/logging"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+var (
+	// requestIDPrefix makes generated IDs unique across instances and restarts;
+	// the counter makes them unique within the process without a syscall per request
+	requestIDPrefix  = newRequestIDPrefix()
+	requestIDCounter atomic.Uint64
+)
+
+// RequestID reads X-Request-ID from the request, or generates one, then stores it
+// in the request context (for log lines and click events) and echoes it in the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = requestIDPrefix + strconv.FormatUint(requestIDCounter.Add(1), 36)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts client IDs that are safe to log: short, printable ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestIDPrefix() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic("middleware: failed to seed request IDs: " + err.Error())
+	}
+	return hex.EncodeToString(b) + "-"
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"link-analytics-service/tracing"
+	"net/http"
+)
+
+// Trace wraps a named route in a server span that continues any incoming trace context
+func Trace(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracing.StartHTTP(r, route)
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(wrapped, r.WithContext(ctx))
+			tracing.EndHTTP(span, wrapped.statusCode)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"link-analytics-service/config"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `usage: main migrate [up | down [N] | status]
+
+  up        apply all pending migrations (default)
+  down [N]  revert the last N applied migrations (default 1)
+  status    list migrations and whether they are applied`
+
+// runMigrate implements the migrate subcommand
+func runMigrate(cfg *config.Config, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	steps := 1
+	switch action {
+	case "up", "status":
+		if len(args) > 1 {
+			return fmt.Errorf("unexpected arguments\n%s", migrateUsage)
+		}
+	case "down":
+		if len(args) > 2 {
+			return fmt.Errorf("unexpected arguments\n%s", migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down needs a positive number of steps\n%s", migrateUsage)
+			}
+			steps = n
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %q\n%s", action, migrateUsage)
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch action {
+	case "up":
+		n, err := database.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "applied %d migration(s)\n", n)
+	case "down":
+		n, err := database.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "reverted %d migration(s)\n", n)
+	case "status":
+		status, err := database.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(os.Stdout, "%04d  %-30s %s\n", s.Version, s.Name, state)
+		}
+	}
+	return nil
+}
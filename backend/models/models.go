@@ -9,6 +9,108 @@ type Link struct {
 	OriginalURL string    `json:"original_url"`
 	UserID      string    `json:"user_id"`
 	CreatedAt   time.Time `json:"created_at"`
+	// Set when redirect resolution is enabled and the destination redirects
+	ResolvedURL   string   `json:"resolved_url,omitempty"`
+	RedirectChain []string `json:"redirect_chain,omitempty"`
+	// Optional metadata for finding and grouping links
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	FolderID    *int64   `json:"folder_id,omitempty"`
+}
+
+// LinkMetadataUpdate changes a link's metadata; nil fields are left as they are
+type LinkMetadataUpdate struct {
+	Title       *string
+	Description *string
+	Tags        *[]string
+	FolderID    *int64 // 0 takes the link out of its folder
+}
+
+// Folder groups a user's links; folders nest through ParentID
+type Folder struct {
+	ID        int64     `json:"id"`
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	ParentID  *int64    `json:"parent_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserSettings are a user's preferences; users who never saved any have the zero value
+type UserSettings struct {
+	UserID string `json:"user_id"`
+	// ReuseExistingLinks makes POST /api/links return the user's existing link to
+	// the same URL instead of creating another
+	ReuseExistingLinks bool `json:"reuse_existing_links"`
+}
+
+// TagCount is a tag and how many of a user's links carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// LinkGroup selects a user's links by tag, or by folder including its subfolders
+type LinkGroup struct {
+	UserID   string
+	Tag      string
+	FolderID int64
+}
+
+// Sort keys for listing links
+const (
+	LinkSortCreatedAt = "created_at"
+	LinkSortClicks    = "clicks"
+)
+
+// LinkListQuery selects one page of a user's links
+type LinkListQuery struct {
+	UserID    string
+	Sort      string // LinkSortCreatedAt or LinkSortClicks
+	Ascending bool
+	Domain    string    // case-insensitive substring of the destination host
+	From      time.Time // created_at lower bound (inclusive), zero for none
+	To        time.Time // created_at upper bound (exclusive), zero for none
+	Tag       string    // only links with this tag
+	FolderID  int64     // only links directly in this folder, 0 for any
+	Limit     int
+	After     *LinkCursor // last link of the previous page, nil for the first page
+}
+
+// LinkCursor is the sort position of a listed link, handed to clients as an opaque cursor
+type LinkCursor struct {
+	Sort        string    `json:"s"`
+	Ascending   bool      `json:"a,omitempty"`
+	CreatedAt   time.Time `json:"c"`
+	TotalClicks int64     `json:"n"`
+	ID          int       `json:"i"`
+}
+
+// LinkSummary is a listed link with its click total
+type LinkSummary struct {
+	Link
+	TotalClicks int64
+}
+
+// LinkPage is one page of listed links
+type LinkPage struct {
+	Links []*LinkSummary
+	Total int64       // links matching the filters on all pages
+	Next  *LinkCursor // nil on the last page
+}
+
+// LinkSearchQuery searches one user's links
+type LinkSearchQuery struct {
+	UserID string
+	Text   string   // the query as typed
+	Terms  []string // lower-case words of Text; a link must match every term
+	Limit  int
+}
+
+// LinkSearchResult is a link matching a search; higher Rank is a better match
+type LinkSearchResult struct {
+	LinkSummary
+	Rank float64
 }
 
 // ClickEvent represents a click analytics event
@@ -19,6 +121,20 @@ type ClickEvent struct {
 	UserAgent   string    `json:"user_agent"`
 	Referer     string    `json:"referer"`
 	VisitorHash string    `json:"visitor_hash"`
+	RequestID   string    `json:"request_id,omitempty"`
+	TraceParent string    `json:"-"` // W3C traceparent of the request that produced the click
+}
+
+// ClickRecord is a stored row of the clicks table, as written to and read from archives
+type ClickRecord struct {
+	ID          int64     `json:"id"`
+	ShortCode   string    `json:"short_code"`
+	ClickedAt   time.Time `json:"clicked_at"`
+	IPAddress   string    `json:"ip_address,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Referer     string    `json:"referer,omitempty"`
+	VisitorHash string    `json:"visitor_hash,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
 }
 
 // LinkStats represents aggregated statistics for a link
@@ -40,6 +156,29 @@ type Referrer struct {
 	ClickCount int64  `json:"count"`
 }
 
+// ErrorResponse is the body of every API error response
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Error codes used in ErrorResponse
+const (
+	ErrCodeInvalidRequest      = "invalid_request"
+	ErrCodeValidation          = "validation_failed"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeMethodNotAllowed    = "method_not_allowed"
+	ErrCodeDestinationRejected = "destination_rejected"
+	ErrCodeQuotaExceeded       = "quota_exceeded"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeIdempotencyKeyReuse = "idempotency_key_reused"
+	ErrCodeIdempotencyPending  = "idempotency_request_in_progress"
+	ErrCodeTimeout             = "timeout"
+	ErrCodeInternal            = "internal_error"
+)
+
 // Error types
 type ValidationError struct {
 	Message string
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// blocklist holds the parsed contents of the blocklist file.
+//
+// File format, one entry per line:
+//
+//	# comment
+//	bad-domain.com            blocks the domain and all of its subdomains
+//	regex:^https?://[^/]*\.tk/ blocks any URL matching the expression
+type blocklist struct {
+	domains  map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+func parseBlocklist(path string) (*blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	bl := &blocklist{domains: make(map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if expr, ok := strings.CutPrefix(line, "regex:"); ok {
+			re, err := regexp.Compile(strings.TrimSpace(expr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex on line %d: %w", lineNum, err)
+			}
+			bl.patterns = append(bl.patterns, re)
+			continue
+		}
+
+		domain := strings.TrimPrefix(line, "domain:")
+		bl.domains[normalizeHost(domain)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+
+	return bl, nil
+}
+
+// matchDomain reports whether host or any of its parent domains is blocked
+func (bl *blocklist) matchDomain(host string) (string, bool) {
+	for h := host; h != ""; {
+		if _, ok := bl.domains[h]; ok {
+			return h, true
+		}
+		idx := strings.IndexByte(h, '.')
+		if idx < 0 {
+			break
+		}
+		h = h[idx+1:]
+	}
+	return "", false
+}
+
+func (bl *blocklist) matchPattern(rawURL string) (string, bool) {
+	for _, re := range bl.patterns {
+		if re.MatchString(rawURL) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// loadBlocklist (re)reads the blocklist file if it changed since the last load
+func (e *Engine) loadBlocklist() error {
+	info, err := os.Stat(e.blocklistPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat blocklist: %w", err)
+	}
+
+	e.mu.RLock()
+	unchanged := info.ModTime().Equal(e.blocklistModTime)
+	e.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	bl, err := parseBlocklist(e.blocklistPath)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.blocklist = bl
+	e.blocklistModTime = info.ModTime()
+	e.mu.Unlock()
+
+	slog.Info("loaded destination blocklist", "domains", len(bl.domains), "patterns", len(bl.patterns))
+	return nil
+}
+
+// WatchBlocklist polls the blocklist file and reloads it when it changes.
+// A file that fails to parse is logged and the previous blocklist stays active.
+func (e *Engine) WatchBlocklist(ctx context.Context, interval time.Duration) {
+	if e.blocklistPath == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.loadBlocklist(); err != nil {
+				slog.Warn("failed to reload blocklist", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
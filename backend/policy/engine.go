@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"link-analytics-service/db"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reason codes returned when a destination is rejected
+const (
+	ReasonInvalidURL          = "invalid_url"
+	ReasonURLTooLong          = "url_too_long"
+	ReasonEmbeddedCredentials = "embedded_credentials"
+	ReasonSelfReference       = "self_reference"
+	ReasonPrivateNetwork      = "private_network"
+	ReasonBlockedDomain       = "blocked_domain"
+	ReasonBlockedPattern      = "blocked_pattern"
+	ReasonQuotaExceeded       = "quota_exceeded"
+)
+
+const (
+	maxURLLength   = 2048
+	resolveTimeout = 2 * time.Second
+	quotaWindow    = time.Hour
+)
+
+// Reason describes a single policy violation
+type Reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Engine decides whether a destination URL may be shortened
+type Engine struct {
+	selfHosts  map[string]struct{}
+	cache      db.Cache
+	quotaLimit int // links per user per hour, 0 disables the quota
+
+	blocklistPath    string
+	blocklistModTime time.Time
+	blocklist        *blocklist
+	mu               sync.RWMutex
+
+	resolver *net.Resolver
+}
+
+// NewEngine creates a policy engine. selfURLs are the URLs this service is reachable
+// under; destinations pointing at them are rejected to prevent redirect loops.
+// An empty blocklistPath disables the blocklist.
+func NewEngine(cache db.Cache, blocklistPath string, quotaLimit int, selfURLs ...string) (*Engine, error) {
+	e := &Engine{
+		selfHosts:     make(map[string]struct{}),
+		cache:         cache,
+		quotaLimit:    quotaLimit,
+		blocklistPath: blocklistPath,
+		blocklist:     &blocklist{domains: make(map[string]struct{})},
+		resolver:      net.DefaultResolver,
+	}
+
+	for _, raw := range selfURLs {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		e.selfHosts[normalizeHost(u.Hostname())] = struct{}{}
+	}
+
+	if blocklistPath != "" {
+		if err := e.loadBlocklist(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// CheckDestination validates a destination URL against all policies
+// and returns every violation found (nil when the URL is allowed)
+func (e *Engine) CheckDestination(ctx context.Context, rawURL string) []Reason {
+	if len(rawURL) > maxURLLength {
+		return []Reason{{
+			Code:    ReasonURLTooLong,
+			Message: fmt.Sprintf("URL exceeds %d characters", maxURLLength),
+		}}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return []Reason{{Code: ReasonInvalidURL, Message: "URL must be an absolute http or https URL"}}
+	}
+
+	var reasons []Reason
+
+	if u.User != nil {
+		reasons = append(reasons, Reason{
+			Code:    ReasonEmbeddedCredentials,
+			Message: "URLs with embedded credentials are not allowed",
+		})
+	}
+
+	host := normalizeHost(u.Hostname())
+
+	if _, ok := e.selfHosts[host]; ok {
+		reasons = append(reasons, Reason{
+			Code:    ReasonSelfReference,
+			Message: "destination points back to this service",
+		})
+	}
+
+	if e.isPrivateHost(ctx, host) {
+		reasons = append(reasons, Reason{
+			Code:    ReasonPrivateNetwork,
+			Message: "destination resolves to a private or local network address",
+		})
+	}
+
+	e.mu.RLock()
+	bl := e.blocklist
+	e.mu.RUnlock()
+
+	if domain, ok := bl.matchDomain(host); ok {
+		reasons = append(reasons, Reason{
+			Code:    ReasonBlockedDomain,
+			Message: fmt.Sprintf("domain %s is blocked", domain),
+		})
+	}
+	if pattern, ok := bl.matchPattern(rawURL); ok {
+		reasons = append(reasons, Reason{
+			Code:    ReasonBlockedPattern,
+			Message: fmt.Sprintf("URL matches blocked pattern %s", pattern),
+		})
+	}
+
+	return reasons
+}
+
+// CheckQuota counts a link creation against the user's hourly quota.
+// Quota checks fail open if Redis is unavailable.
+func (e *Engine) CheckQuota(ctx context.Context, userID string) *Reason {
+	if e.quotaLimit <= 0 || e.cache == nil {
+		return nil
+	}
+
+	window := time.Now().UTC().Truncate(quotaWindow).Unix()
+	key := fmt.Sprintf("quota:links:%s:%d", userID, window)
+	count, err := e.cache.IncrWithTTL(ctx, key, quotaWindow)
+	if err != nil {
+		slog.WarnContext(ctx, "quota check failed, allowing request", "error", err)
+		return nil
+	}
+
+	if count > int64(e.quotaLimit) {
+		return &Reason{
+			Code:    ReasonQuotaExceeded,
+			Message: fmt.Sprintf("link creation quota of %d per hour exceeded", e.quotaLimit),
+		}
+	}
+	return nil
+}
+
+// ReserveQuota counts n link creations against the user's hourly quota at once
+// and returns how many fit, with the quota reason when not all did. The rest are
+// given back straight away; release gives back the granted ones too, for links
+// that end up not being created. Like CheckQuota it fails open.
+func (e *Engine) ReserveQuota(ctx context.Context, userID string, n int) (granted int, reason *Reason, release func(context.Context)) {
+	release = func(context.Context) {}
+	if e.quotaLimit <= 0 || e.cache == nil || n <= 0 {
+		return n, nil, release
+	}
+
+	window := time.Now().UTC().Truncate(quotaWindow).Unix()
+	key := fmt.Sprintf("quota:links:%s:%d", userID, window)
+	count, err := e.cache.IncrByWithTTL(ctx, key, int64(n), quotaWindow)
+	if err != nil {
+		slog.WarnContext(ctx, "quota check failed, allowing request", "error", err)
+		return n, nil, release
+	}
+
+	granted = n
+	if over := count - int64(e.quotaLimit); over > 0 {
+		granted = n - int(min(over, int64(n)))
+		e.giveBackQuota(ctx, key, n-granted)
+		reason = &Reason{
+			Code:    ReasonQuotaExceeded,
+			Message: fmt.Sprintf("link creation quota of %d per hour exceeded", e.quotaLimit),
+		}
+	}
+	// The same window's key, even if the hour has turned since
+	release = func(ctx context.Context) { e.giveBackQuota(ctx, key, granted) }
+	return granted, reason, release
+}
+
+func (e *Engine) giveBackQuota(ctx context.Context, key string, n int) {
+	if n <= 0 {
+		return
+	}
+	if _, err := e.cache.IncrByWithTTL(ctx, key, -int64(n), quotaWindow); err != nil {
+		slog.WarnContext(ctx, "failed to give back link quota", "error", err)
+	}
+}
+
+// isPrivateHost reports whether host is, or resolves to, a non-public address.
+// Hosts that fail to resolve are not rejected here.
+func (e *Engine) isPrivateHost(ctx context.Context, host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return true
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return isPrivateIP(ip)
+	}
+
+	resolveCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	addrs, err := e.resolver.LookupIPAddr(resolveCtx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsInterfaceLocalMulticast()
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
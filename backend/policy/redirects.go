@@ -0,0 +1,177 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Reason codes returned by redirect chain resolution
+const (
+	ReasonRedirectLoop     = "redirect_loop"
+	ReasonTooManyRedirects = "too_many_redirects"
+)
+
+// RedirectChain is the result of following a destination's redirects
+type RedirectChain struct {
+	FinalURL string   // Last URL reached (equal to the input if it doesn't redirect)
+	Hops     []string // Every URL visited after the input, in order
+}
+
+// RedirectResolver follows a destination's redirect chain before a link is created.
+// Each hop is checked with the same destination policy as the original URL, so a
+// public URL can't be used to reach a blocked, private or self-referencing target.
+type RedirectResolver struct {
+	engine  *Engine
+	client  *http.Client
+	maxHops int
+	timeout time.Duration
+}
+
+// NewRedirectResolver creates a resolver following at most maxHops redirects.
+// timeout bounds the whole chain, not each individual request.
+func NewRedirectResolver(engine *Engine, maxHops int, timeout time.Duration) *RedirectResolver {
+	// CheckDestination resolved each host before, but DNS may answer differently
+	// now (or may not have answered then), so the address actually dialed is checked
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		},
+	}
+	return &RedirectResolver{
+		engine: engine,
+		client: &http.Client{
+			// No proxy, so the dialed address is the destination's
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				MaxIdleConns:        10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// Redirects are followed manually so every hop can be inspected
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxHops: maxHops,
+		timeout: timeout,
+	}
+}
+
+// Resolve follows the redirect chain of rawURL. It returns policy violations when the
+// chain loops (including back into this service), is too long, or passes through a
+// rejected destination. Network errors end the chain early without rejecting the URL.
+func (rr *RedirectResolver) Resolve(ctx context.Context, rawURL string) (*RedirectChain, []Reason) {
+	ctx, cancel := context.WithTimeout(ctx, rr.timeout)
+	defer cancel()
+
+	chain := &RedirectChain{FinalURL: rawURL}
+	visited := map[string]bool{rawURL: true}
+	current := rawURL
+
+	for hop := 1; ; hop++ {
+		next, err := rr.nextHop(ctx, current)
+		if err != nil {
+			slog.InfoContext(ctx, "redirect resolution stopped early", "url", rawURL, "stopped_at", current, "error", err)
+			return chain, nil
+		}
+		if next == "" {
+			return chain, nil
+		}
+
+		if hop > rr.maxHops {
+			return chain, []Reason{{
+				Code:    ReasonTooManyRedirects,
+				Message: fmt.Sprintf("destination redirects more than %d times", rr.maxHops),
+			}}
+		}
+
+		chain.Hops = append(chain.Hops, next)
+		chain.FinalURL = next
+
+		if visited[next] {
+			return chain, []Reason{{
+				Code:    ReasonRedirectLoop,
+				Message: fmt.Sprintf("redirect chain loops at hop %d (%s)", hop, next),
+			}}
+		}
+		visited[next] = true
+
+		if reasons := rr.engine.CheckDestination(ctx, next); len(reasons) > 0 {
+			for i := range reasons {
+				if reasons[i].Code == ReasonSelfReference {
+					reasons[i].Code = ReasonRedirectLoop
+					reasons[i].Message = fmt.Sprintf("redirect chain leads back into this service at hop %d", hop)
+					continue
+				}
+				reasons[i].Message = fmt.Sprintf("hop %d: %s", hop, reasons[i].Message)
+			}
+			return chain, reasons
+		}
+
+		current = next
+	}
+}
+
+// nextHop requests u and returns the absolute redirect target, or "" if u doesn't redirect
+func (rr *RedirectResolver) nextHop(ctx context.Context, u string) (string, error) {
+	resp, err := rr.do(ctx, http.MethodHead, u)
+	if err != nil {
+		return "", err
+	}
+	// Some servers don't implement HEAD, retry those with GET
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		resp, err = rr.do(ctx, http.MethodGet, u)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", nil
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", nil
+	}
+
+	base, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	target, err := base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid Location header %q: %w", location, err)
+	}
+	return target.String(), nil
+}
+
+func (rr *RedirectResolver) do(ctx context.Context, method, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "link-analytics-service/redirect-check")
+
+	resp, err := rr.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Only status and headers are needed
+	resp.Body.Close()
+	return resp, nil
+}
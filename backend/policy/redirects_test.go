@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestResolver returns a resolver whose every request, whatever its host, is
+// served by handler. Hosts don't resolve, so they pass the private network check;
+// the dial-time address check is covered by TestResolveRefusesPrivateAddress.
+func newTestResolver(t *testing.T, handler http.Handler, maxHops int, timeout time.Duration) *RedirectResolver {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	engine, err := NewEngine(nil, "", 0, "https://sho.rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("no DNS in tests")
+		},
+	}
+
+	rr := NewRedirectResolver(engine, maxHops, timeout)
+	addr := srv.Listener.Addr().String()
+	rr.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	return rr
+}
+
+func redirectTo(location string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusFound)
+	}
+}
+
+func hasReason(reasons []Reason, code string) bool {
+	for _, r := range reasons {
+		if r.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestResolveFollowsChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", redirectTo("http://two.test/b"))
+	mux.Handle("/b", redirectTo("/c"))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {})
+	rr := newTestResolver(t, mux, 5, time.Second)
+
+	chain, reasons := rr.Resolve(context.Background(), "http://one.test/a")
+	if len(reasons) != 0 {
+		t.Fatalf("unexpected reasons: %v", reasons)
+	}
+	want := []string{"http://two.test/b", "http://two.test/c"}
+	if fmt.Sprint(chain.Hops) != fmt.Sprint(want) {
+		t.Errorf("hops = %v, want %v", chain.Hops, want)
+	}
+	if chain.FinalURL != "http://two.test/c" {
+		t.Errorf("final URL = %q", chain.FinalURL)
+	}
+}
+
+func TestResolveDetectsLoop(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/a", redirectTo("/b"))
+	mux.Handle("/b", redirectTo("/a"))
+	rr := newTestResolver(t, mux, 10, time.Second)
+
+	chain, reasons := rr.Resolve(context.Background(), "http://loop.test/a")
+	if !hasReason(reasons, ReasonRedirectLoop) {
+		t.Fatalf("reasons = %v, want %s", reasons, ReasonRedirectLoop)
+	}
+	if len(chain.Hops) != 2 {
+		t.Errorf("hops = %v, want the loop closed at the second hop", chain.Hops)
+	}
+}
+
+func TestResolveHopLimit(t *testing.T) {
+	var n int
+	var mu sync.Mutex
+	rr := newTestResolver(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		next := fmt.Sprintf("/hop/%d", n)
+		mu.Unlock()
+		redirectTo(next)(w, r)
+	}), 3, time.Second)
+
+	chain, reasons := rr.Resolve(context.Background(), "http://long.test/start")
+	if !hasReason(reasons, ReasonTooManyRedirects) {
+		t.Fatalf("reasons = %v, want %s", reasons, ReasonTooManyRedirects)
+	}
+	if len(chain.Hops) != 3 {
+		t.Errorf("hops = %v, want 3", chain.Hops)
+	}
+}
+
+func TestResolveTimeoutCoversWholeChain(t *testing.T) {
+	// Every hop is well within the timeout, the chain as a whole is not
+	var n int
+	var mu sync.Mutex
+	rr := newTestResolver(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		n++
+		next := fmt.Sprintf("/hop/%d", n)
+		mu.Unlock()
+		redirectTo(next)(w, r)
+	}), 100, 200*time.Millisecond)
+
+	start := time.Now()
+	chain, reasons := rr.Resolve(context.Background(), "http://slow.test/start")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolution took %v, want it cut off at the chain timeout", elapsed)
+	}
+	// A timeout ends the chain early without rejecting the URL
+	if len(reasons) != 0 {
+		t.Errorf("unexpected reasons: %v", reasons)
+	}
+	if len(chain.Hops) == 0 || len(chain.Hops) > 4 {
+		t.Errorf("hops = %d, want the few that fit in the timeout", len(chain.Hops))
+	}
+}
+
+func TestResolveRedirectIntoService(t *testing.T) {
+	rr := newTestResolver(t, redirectTo("https://sho.rt/abc123"), 5, time.Second)
+
+	_, reasons := rr.Resolve(context.Background(), "http://out.test/")
+	if !hasReason(reasons, ReasonRedirectLoop) {
+		t.Fatalf("reasons = %v, want %s", reasons, ReasonRedirectLoop)
+	}
+	if hasReason(reasons, ReasonSelfReference) {
+		t.Errorf("self reference should be reported as a loop: %v", reasons)
+	}
+}
+
+func TestResolveFallsBackToGet(t *testing.T) {
+	var methods []string
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		redirectTo("/end")(w, r)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {})
+	rr := newTestResolver(t, mux, 5, time.Second)
+
+	chain, reasons := rr.Resolve(context.Background(), "http://nohead.test/start")
+	if len(reasons) != 0 {
+		t.Fatalf("unexpected reasons: %v", reasons)
+	}
+	if chain.FinalURL != "http://nohead.test/end" {
+		t.Errorf("final URL = %q, want the GET redirect followed", chain.FinalURL)
+	}
+	if got := strings.Join(methods, ","); got != "HEAD,GET" {
+		t.Errorf("methods = %s, want HEAD,GET", got)
+	}
+}
+
+func TestResolveRefusesPrivateAddress(t *testing.T) {
+	var hits int
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		redirectTo("/elsewhere")(w, r)
+	}))
+	defer srv.Close()
+
+	engine, err := NewEngine(nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The real transport, so the loopback test server is what a rebound name would reach
+	rr := NewRedirectResolver(engine, 5, time.Second)
+
+	chain, _ := rr.Resolve(context.Background(), srv.URL)
+	if hits != 0 {
+		t.Errorf("server got %d requests, want the dial refused", hits)
+	}
+	if len(chain.Hops) != 0 {
+		t.Errorf("hops = %v, want none", chain.Hops)
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Device classes returned by DeviceClass
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// countryHeaders are set by common CDNs and proxies in front of the service.
+// There is no GeoIP lookup here; without one of these the country is unknown.
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"}
+
+// ExtractCountry returns the ISO country code provided by an upstream proxy, or ""
+func ExtractCountry(r *http.Request) string {
+	for _, h := range countryHeaders {
+		if c := strings.TrimSpace(r.Header.Get(h)); len(c) == 2 {
+			return strings.ToUpper(c)
+		}
+	}
+	return ""
+}
+
+// DeviceClass roughly classifies a user agent. It is coarse on purpose
+// so it can be shared in live feeds without identifying the visitor.
+func DeviceClass(userAgent string) string {
+	if userAgent == "" {
+		return DeviceUnknown
+	}
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "spider") || strings.Contains(ua, "crawl"):
+		return DeviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") ||
+		(strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// ReferrerDomain returns only the host of a referrer URL, dropping path and query
+func ReferrerDomain(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
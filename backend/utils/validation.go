@@ -2,6 +2,7 @@ package utils
 
 import (
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strings"
 )
@@ -42,6 +43,22 @@ func ExtractIP(r *http.Request) string {
 	return ip
 }
 
+// AnonymizeIP zeroes the host part of an IP address: the last octet of IPv4
+// (a /24) and all but the first 48 bits of IPv6. It returns "" for anything
+// that is not an IP address.
+func AnonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	bits := 48
+	if addr.Unmap().Is4() {
+		addr, bits = addr.Unmap(), 24
+	}
+	prefix, _ := addr.WithZone("").Prefix(bits)
+	return prefix.Addr().String()
+}
+
 // ExtractShortCode extracts the short code from the URL path
 // Expects path format: /{shortCode}
 func ExtractShortCode(path string) string {
@@ -5,10 +5,16 @@ import (
 	"encoding/json"
 	"link-analytics-service/db"
 	"link-analytics-service/handlers"
+	"link-analytics-service/metrics"
 	"link-analytics-service/models"
-	"log"
+	"link-analytics-service/tracing"
+	"log/slog"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -17,30 +23,37 @@ const (
 	BatchTimeout   = 5 * time.Second
 )
 
+var (
+	flushDuration = metrics.NewHistogram("analytics_flush_duration_seconds",
+		"Time for a worker to store a batch and update aggregates", metrics.DefaultBuckets)
+	flushedEvents = metrics.NewCounter("analytics_events_flushed_total",
+		"Click events stored in the clicks table")
+)
+
 // StartWorkers starts the analytics worker pool
-func StartWorkers(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *handlers.SSEBroker) {
+func StartWorkers(ctx context.Context, store db.Store, cache db.Cache, broker *handlers.SSEBroker) {
 	var wg sync.WaitGroup
 
 	for i := 0; i < NumWorkers; i++ {
 		wg.Add(1)
 		go func(id int) {
 			defer wg.Done()
-			worker(ctx, id, pgDB, redisDB, broker)
+			worker(ctx, id, store, cache, broker)
 		}(i)
 	}
 
-	log.Printf("Started %d analytics workers", NumWorkers)
+	slog.Info("started analytics workers", "workers", NumWorkers)
 
 	// Wait for context cancellation
 	<-ctx.Done()
-	log.Println("Stopping analytics workers...")
+	slog.Info("stopping analytics workers")
 
 	// Wait for all workers to finish
 	wg.Wait()
-	log.Println("All analytics workers stopped")
+	slog.Info("all analytics workers stopped")
 }
 
-func worker(ctx context.Context, id int, pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *handlers.SSEBroker) {
+func worker(ctx context.Context, id int, store db.Store, cache db.Cache, broker *handlers.SSEBroker) {
 	batch := make([]models.ClickEvent, 0, BatchSize)
 	ticker := time.NewTicker(BatchTimeout)
 	defer ticker.Stop()
@@ -51,31 +64,47 @@ func worker(ctx context.Context, id int, pgDB *db.PostgresDB, redisDB *db.RedisD
 			batch = append(batch, event)
 
 			if len(batch) >= BatchSize {
-				flushBatch(ctx, pgDB, redisDB, broker, batch)
+				flushBatch(ctx, store, cache, broker, batch)
 				batch = batch[:0]
 			}
 
 		case <-ticker.C:
 			if len(batch) > 0 {
-				flushBatch(ctx, pgDB, redisDB, broker, batch)
+				flushBatch(ctx, store, cache, broker, batch)
 				batch = batch[:0]
 			}
 
 		case <-ctx.Done():
 			// Flush remaining events before shutdown
 			if len(batch) > 0 {
-				flushBatch(ctx, pgDB, redisDB, broker, batch)
+				flushBatch(ctx, store, cache, broker, batch)
 			}
 			return
 		}
 	}
 }
 
-func flushBatch(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, broker *handlers.SSEBroker, events []models.ClickEvent) {
+func flushBatch(ctx context.Context, store db.Store, cache db.Cache, broker *handlers.SSEBroker, events []models.ClickEvent) {
 	if len(events) == 0 {
 		return
 	}
 
+	start := time.Now()
+	defer func() { flushDuration.Observe(time.Since(start).Seconds()) }()
+
+	// The flush is its own trace; it links back to the requests that produced the clicks
+	links := make([]trace.Link, 0, len(events))
+	for _, event := range events {
+		if link, ok := tracing.LinkFromTraceParent(event.TraceParent); ok {
+			links = append(links, link)
+		}
+	}
+	ctx, span := tracing.Tracer.Start(ctx, "analytics.flushBatch",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("analytics.batch_size", len(events))))
+	defer span.End()
+
 	// Convert to pointers for batch insert
 	eventPtrs := make([]*models.ClickEvent, len(events))
 	for i := range events {
@@ -83,10 +112,14 @@ func flushBatch(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, b
 	}
 
 	// Batch insert into clicks table
-	if err := pgDB.BatchInsertClickEvents(ctx, eventPtrs); err != nil {
-		log.Printf("Error inserting click events: %v", err)
+	if err := store.BatchInsertClickEvents(ctx, eventPtrs); err != nil {
+		slog.ErrorContext(ctx, "failed to insert click events", "events", len(events), "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "insert failed")
+		handlers.AnalyticsEventsDropped.Add(int64(len(events)), "insert_failed")
 		return
 	}
+	flushedEvents.Add(int64(len(events)))
 
 	// Group events by short code for aggregation
 	codeStats := make(map[string]*codeStat)
@@ -117,7 +150,7 @@ func flushBatch(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, b
 	// Update aggregated statistics
 	for shortCode, stats := range codeStats {
 		// Get current stats
-		currentStats, err := pgDB.GetLinkStats(ctx, shortCode)
+		currentStats, err := store.GetLinkStats(ctx, shortCode)
 		if err != nil {
 			// If no stats exist, create new
 			currentStats = &models.LinkStats{
@@ -128,30 +161,31 @@ func flushBatch(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, b
 		}
 
 		// Calculate actual unique visitors count from database
-		// Recalculate from all clicks to get accurate count
-		uniqueVisitors, err := pgDB.RecalculateUniqueVisitors(ctx, shortCode)
+		// Recalculate from all clicks to get accurate count; read from the primary,
+		// a replica may not have this batch's visitors yet
+		uniqueVisitors, err := store.RecalculateUniqueVisitors(db.ReadFromPrimary(ctx), shortCode)
 		if err != nil {
-			log.Printf("Error recalculating unique visitors for %s: %v", shortCode, err)
+			slog.ErrorContext(ctx, "failed to recalculate unique visitors", "short_code", shortCode, "error", err)
 			// Fallback: use approximate count (current + new unique in batch)
 			uniqueVisitors = currentStats.UniqueVisitors + int64(len(stats.uniqueVisitors))
 		}
 
 		// Update link_stats table
-		if err := pgDB.UpdateLinkStats(ctx, shortCode, stats.totalClicks, uniqueVisitors); err != nil {
-			log.Printf("Error updating link stats for %s: %v", shortCode, err)
+		if err := store.UpdateLinkStats(ctx, shortCode, stats.totalClicks, uniqueVisitors); err != nil {
+			slog.ErrorContext(ctx, "failed to update link stats", "short_code", shortCode, "error", err)
 		}
 
 		// Update top_referrers
 		if refs, ok := referrerStats[shortCode]; ok {
 			for referer, count := range refs {
-				if err := pgDB.UpdateTopReferrers(ctx, shortCode, referer, count); err != nil {
-					log.Printf("Error updating top referrers for %s: %v", shortCode, err)
+				if err := store.UpdateTopReferrers(ctx, shortCode, referer, count); err != nil {
+					slog.ErrorContext(ctx, "failed to update top referrers", "short_code", shortCode, "error", err)
 				}
 			}
 		}
 
 		// Broadcast to SSE clients
-		stats, err := pgDB.GetLinkStats(ctx, shortCode)
+		stats, err := store.GetLinkStats(ctx, shortCode)
 		if err == nil {
 			data := map[string]interface{}{
 				"short_code":   shortCode,
@@ -159,7 +193,7 @@ func flushBatch(ctx context.Context, pgDB *db.PostgresDB, redisDB *db.RedisDB, b
 				"total_clicks": stats.TotalClicks,
 			}
 			if jsonData, err := json.Marshal(data); err == nil {
-				broker.Broadcast(shortCode, jsonData)
+				broker.Publish(ctx, shortCode, handlers.EventClicks, jsonData)
 			}
 		}
 	}
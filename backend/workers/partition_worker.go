@@ -0,0 +1,81 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"link-analytics-service/archive"
+	"link-analytics-service/db"
+	"link-analytics-service/metrics"
+	"log/slog"
+	"time"
+)
+
+const (
+	// PartitionsAhead is how many future monthly clicks partitions are kept ready
+	PartitionsAhead = 3
+	// PartitionMaintenanceInterval is how often partitions are created and expired
+	PartitionMaintenanceInterval = 6 * time.Hour
+)
+
+var (
+	expiredPartitions = metrics.NewCounter("click_partitions_expired_total",
+		"Monthly clicks partitions dropped or detached by the retention policy")
+	archivedPartitions = metrics.NewCounter("click_partitions_archived_total",
+		"Detached clicks partitions exported to the archive and dropped")
+)
+
+// StartPartitionMaintenance creates upcoming clicks partitions and expires the ones older
+// than retentionMonths (0 keeps everything). Expired partitions are dropped, or detached
+// from clicks when detach is set; a non-nil archiver then exports and drops them.
+// Runs until ctx is cancelled.
+func StartPartitionMaintenance(ctx context.Context, partitioner db.ClickPartitioner, archiver *archive.Archiver, retentionMonths int, detach bool) {
+	ticker := time.NewTicker(PartitionMaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		maintainPartitions(ctx, partitioner, retentionMonths, detach)
+		if archiver != nil {
+			archivePartitions(ctx, archiver)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func maintainPartitions(ctx context.Context, partitioner db.ClickPartitioner, retentionMonths int, detach bool) {
+	now := time.Now()
+
+	created, err := partitioner.EnsureClickPartitions(ctx, now, PartitionsAhead)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create clicks partitions", "error", err)
+	} else if created > 0 {
+		slog.InfoContext(ctx, "created clicks partitions", "created", created)
+	}
+
+	if retentionMonths <= 0 {
+		return
+	}
+	expired, err := partitioner.ExpireClickPartitions(ctx, now.AddDate(0, -retentionMonths, 0), detach)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to expire clicks partitions", "error", err)
+		return
+	}
+	if len(expired) > 0 {
+		expiredPartitions.Add(int64(len(expired)))
+		slog.InfoContext(ctx, "expired clicks partitions", "partitions", expired, "detached", detach)
+	}
+}
+
+func archivePartitions(ctx context.Context, archiver *archive.Archiver) {
+	archived, err := archiver.ArchivePartitions(ctx)
+	archivedPartitions.Add(int64(len(archived)))
+	if errors.Is(err, archive.ErrPartitionsLocked) {
+		slog.DebugContext(ctx, "skipping clicks archiving, another instance holds the partition lock")
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to archive clicks partitions", "error", err)
+	}
+}
@@ -19,7 +19,8 @@ export function RealtimeCounter({ shortCode, initialCount }: Props) {
       setIsConnected(true)
     }
 
-    eventSource.onmessage = (event) => {
+    // Totals arrive as typed events: 'snapshot' on connect, 'clicks' on updates
+    const handleTotals = (event: MessageEvent) => {
       try {
         const data = JSON.parse(event.data)
         if (data.total_clicks !== undefined) {
@@ -29,6 +30,8 @@ export function RealtimeCounter({ shortCode, initialCount }: Props) {
         console.error('Error parsing SSE message:', err)
       }
     }
+    eventSource.addEventListener('snapshot', handleTotals)
+    eventSource.addEventListener('clicks', handleTotals)
 
     eventSource.onerror = () => {
       setIsConnected(false)
@@ -1,5 +1,17 @@
+import { ApiError } from '@/types';
+
 const API_BASE = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api';
 
+// errorMessage reads the message from the API's JSON error envelope
+async function errorMessage(response: Response, fallback: string): Promise<string> {
+    try {
+        const body: ApiError = await response.json();
+        return body.message || fallback;
+    } catch {
+        return fallback;
+    }
+}
+
 export async function createLink(url: string, userId: string) {
     const response = await fetch(`${API_BASE}/links`, {
         method: 'POST',
@@ -8,8 +20,7 @@ export async function createLink(url: string, userId: string) {
     });
 
     if (!response.ok) {
-        const error = await response.text();
-        throw new Error(error || 'Failed to create link');
+        throw new Error(await errorMessage(response, 'Failed to create link'));
     }
 
     return response.json();
@@ -45,6 +56,16 @@ export async function getAnalytics(shortCode: string, period: '24h' | '7d' | '30
     return response.json();
 }
 
+export async function getAnalyticsOverview(userId: string, period: '24h' | '7d' | '30d' = '24h') {
+    const response = await fetch(`${API_BASE}/analytics/overview?user_id=${userId}&period=${period}`);
+
+    if (!response.ok) {
+        throw new Error('Failed to fetch analytics overview');
+    }
+
+    return response.json();
+}
+
 export async function trackClick(shortCode: string) {
     const response = await fetch(`${API_BASE}/track/${shortCode}`, {
         method: 'POST',
@@ -4,6 +4,10 @@ export interface Link {
     original_url: string;
     user_id: string;
     created_at: string;
+    title?: string;
+    description?: string;
+    tags?: string[];
+    folder_id?: number;
 }
 
 export interface Analytics {
@@ -14,6 +18,43 @@ export interface Analytics {
     top_referrers: Referrer[];
     click_rate?: number;
     peak_hour?: ClickData;
+    comparison?: PeriodComparison | null;
+    previous_clicks_over_time?: ClickData[]; // shifted forward by the period to overlay clicks_over_time
+    anomaly?: boolean;
+    spikes?: ClickData[];
+}
+
+export interface PeriodComparison {
+    clicks: number;
+    unique_visitors: number;
+    previous_clicks: number;
+    previous_unique_visitors: number;
+    clicks_delta: number;
+    unique_visitors_delta: number;
+    clicks_change: number | null; // percent; null when the previous period had none
+    unique_visitors_change: number | null;
+    trend: 'up' | 'down' | 'flat';
+}
+
+export interface OverviewLink {
+    short_code: string;
+    original_url: string;
+    clicks: number;
+    unique_visitors: number;
+}
+
+export interface AnalyticsOverview {
+    user_id: string;
+    period: '24h' | '7d' | '30d';
+    links: number;
+    total_clicks: number;
+    unique_visitors: number;
+    clicks_over_time: ClickData[];
+    top_links: OverviewLink[];
+    top_referrers: Referrer[];
+    click_rate: number;
+    peak_hour: ClickData | null;
+    change: PeriodComparison;
 }
 
 export interface ClickData {
@@ -37,15 +78,46 @@ export interface CreateLinkResponse {
     short_url: string;
     original_url: string;
     created_at: string;
+    reused?: boolean;
+}
+
+export interface UserSettings {
+    user_id: string;
+    reuse_existing_links: boolean;
 }
 
 export interface ListLinksResponse {
     links: LinkInfo[];
+    total: number;
+    next_cursor: string | null;
 }
 
 export interface LinkInfo {
     short_code: string;
     original_url: string;
     created_at: string;
+    title?: string;
+    tags?: string[];
+    folder_id?: number;
     total_clicks: number;
 }
+
+export interface Folder {
+    id: number;
+    user_id: string;
+    name: string;
+    parent_id: number | null;
+    created_at: string;
+}
+
+export interface TagCount {
+    tag: string;
+    links: number;
+}
+
+export interface ApiError {
+    code: string;
+    message: string;
+    details?: unknown;
+    request_id?: string;
+}